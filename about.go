@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// build metadata injected by the linker e.g. -ldflags "-X main.gitCommit=abc1234"
+var (
+	gitCommit = "unknown"
+	buildDate = "unknown"
+)
+
+// halInfo describes the host as seen by the hardware abstraction layer
+type halInfo struct {
+	Model          string   `json:"model"`
+	OS             string   `json:"os"`
+	Drivers        []string `json:"drivers"`
+	FailedDrivers  []string `json:"failed_drivers,omitempty"`
+	SkippedDrivers []string `json:"skipped_drivers,omitempty"`
+}
+
+// about is the report of /about
+type about struct {
+	Version        int          `json:"version"`
+	BuildType      string       `json:"build_type"`
+	GitCommit      string       `json:"git_commit"`
+	BuildDate      string       `json:"build_date"`
+	GoVersion      string       `json:"go_version"`
+	StartTime      time.Time    `json:"start_time"`
+	UptimeSeconds  float64      `json:"uptime_seconds"`
+	Hostname       string       `json:"hostname"`
+	HAL            halInfo      `json:"hal"`
+	CPUTemperature *float64     `json:"cpu_temperature_celsius,omitempty"`
+	Thermal        []zoneStatus `json:"thermal"`
+	Location       [2]float64   `json:"location"`
+	Light          bool         `json:"light"`
+	Alarm          bool         `json:"alarm"`
+	ConfigFile     string       `json:"config_file"`
+	LogFile        string       `json:"log_file"` // empty when logging to stdout
+}
+
+// getAbout gathers the report of /about
+func getAbout(now time.Time, l plugInterface, a alarmInterface, thermal *thermalMonitor, config configuration, configPath, logPath string) about {
+	hostname, _ := os.Hostname()
+	info := about{
+		Version:       version,
+		BuildType:     buildType,
+		GitCommit:     gitCommit,
+		BuildDate:     buildDate,
+		GoVersion:     runtime.Version(),
+		StartTime:     startTime,
+		UptimeSeconds: now.Sub(startTime).Seconds(),
+		Hostname:      hostname,
+		HAL:           getHALInfo(),
+		Thermal:       thermal.status(),
+		Location:      config.location,
+		Light:         l.state(),
+		Alarm:         a.isSet(),
+		ConfigFile:    configPath,
+		LogFile:       logPath,
+	}
+	if celsius, err := cpuTemperature(); err == nil {
+		info.CPUTemperature = &celsius
+	}
+	return info
+}
+
+// orNone returns s or "none" if it is empty
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+// aboutHandlerFunc returns a handler function that reports about the server
+// the report is JSON if requested with ?format=json or an Accept header of application/json
+func aboutHandlerFunc(l plugInterface, a alarmInterface, thermal *thermalMonitor, store *configStore, configPath, logPath string) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		disableCache(w)
+		info := getAbout(time.Now(), l, a, thermal, store.get(), configPath, logPath)
+		if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+			respondJSON(w, info)
+			return
+		}
+
+		fmt.Fprintf(w, "Heihei: version %2d\n", info.Version)
+		fmt.Fprintf(w, "        at (%v, %v)\n", info.Location[0], info.Location[1])
+		fmt.Fprintf(w, "        light is %v\n", info.Light)
+		fmt.Fprintf(w, "        alarm is %v\n", info.Alarm)
+		fmt.Fprintf(w, "        build type %s\n", info.BuildType)
+		fmt.Fprintf(w, "        commit %s built %s with %s\n", info.GitCommit, info.BuildDate, info.GoVersion)
+		fmt.Fprintf(w, "        up %v since %s\n", time.Duration(info.UptimeSeconds)*time.Second, info.StartTime.Format(time.RFC3339))
+		fmt.Fprintf(w, "        host %s, %s running %s\n", info.Hostname, info.HAL.Model, info.HAL.OS)
+		fmt.Fprintf(w, "        drivers %s\n", orNone(strings.Join(info.HAL.Drivers, ", ")))
+		if len(info.HAL.FailedDrivers) > 0 {
+			fmt.Fprintf(w, "        failed drivers %s\n", strings.Join(info.HAL.FailedDrivers, ", "))
+		}
+		if info.CPUTemperature != nil {
+			fmt.Fprintf(w, "        CPU temperature %.1f°C\n", *info.CPUTemperature)
+		}
+		for _, z := range info.Thermal {
+			fmt.Fprintf(w, "        %s %.1f°C (min %.1f°C, max %.1f°C)\n", z.Zone, z.Current, z.Min, z.Max)
+		}
+		fmt.Fprintf(w, "        config file %s\n", info.ConfigFile)
+		if info.LogFile == "" {
+			fmt.Fprintf(w, "        logging to stdout\n")
+		} else {
+			fmt.Fprintf(w, "        log file %s\n", info.LogFile)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestAboutHandler(t *testing.T) {
+	alarm := fakeAlarm(true)
+	store := &configStore{config: configuration{location: [2]float64{londonLat, londonLon}}}
+	handler := aboutHandlerFunc(&fakePlug{on: true}, &alarm, nil, store, "/etc/heihei/heihei.yaml", "")
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/about", nil))
+	body := w.Body.String()
+	for _, expected := range []string{
+		"Heihei: version  5\n",
+		"light is true\n",
+		"alarm is true\n",
+		"build type " + buildType + "\n",
+		"commit unknown built unknown with " + runtime.Version(),
+		"config file /etc/heihei/heihei.yaml\n",
+		"logging to stdout\n",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("got\n%s\nexpected it to contain %q", body, expected)
+		}
+	}
+
+	jsonRequest := httptest.NewRequest("GET", "/about", nil)
+	jsonRequest.Header.Set("Accept", "application/json")
+	for _, r := range []*http.Request{httptest.NewRequest("GET", "/about?format=json", nil), jsonRequest} {
+		w = httptest.NewRecorder()
+		handler(w, r)
+		var info about
+		if err := json.NewDecoder(w.Body).Decode(&info); err != nil {
+			t.Fatalf("unexpected error %v for %v", err, r.URL)
+		}
+		if info.Version != version || info.GoVersion != runtime.Version() || !info.Light || info.Location[0] != londonLat {
+			t.Errorf("unexpected report %+v", info)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// access log formats
+const (
+	accessFormatCommon   = "common"
+	accessFormatCombined = "combined"
+)
+
+const (
+	accessLogFilename = "access.log"
+	clfTimeLayout     = "02/Jan/2006:15:04:05 -0700"
+)
+
+// accessLogConfiguration describes the access log; an empty path disables it
+type accessLogConfiguration struct {
+	path   string
+	format string
+}
+
+// responseRecorder records the status code and size of a response
+// Flush and Hijack are passed through so that streaming handlers keep working
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		if r.status == 0 {
+			r.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking unsupported")
+	}
+	// a hijacked connection is only used for protocol upgrades
+	r.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// statusCode returns the recorded status; a handler that wrote nothing responded with 200
+func (r *responseRecorder) statusCode() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+// clientIdentity returns the common name of a verified client certificate or - if there is none
+func clientIdentity(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "-"
+	}
+	if name := r.TLS.VerifiedChains[0][0].Subject.CommonName; name != "" {
+		return strings.Replace(name, " ", "_", -1)
+	}
+	return "-"
+}
+
+// dashIfEmpty returns - for an empty string as required by the log formats
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// formatAccess returns an access log line in Common or Combined Log Format
+// the duration of the request in microseconds is appended as a final field
+func formatAccess(format string, r *http.Request, status int, size int64, start time.Time, d time.Duration) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	sizeField := "-"
+	if size > 0 {
+		sizeField = fmt.Sprint(size)
+	}
+	line := fmt.Sprintf("%s - %s [%s] %q %d %s",
+		dashIfEmpty(host),
+		clientIdentity(r),
+		start.Format(clfTimeLayout),
+		fmt.Sprintf("%s %s %s", r.Method, r.URL.RequestURI(), r.Proto),
+		status,
+		sizeField)
+	if format == accessFormatCombined {
+		line += fmt.Sprintf(" %q %q", dashIfEmpty(r.Referer()), dashIfEmpty(r.UserAgent()))
+	}
+	return fmt.Sprintf("%s %d", line, d.Nanoseconds()/int64(time.Microsecond))
+}
+
+// accessLogHandler is a http handler wrapper that writes a line to w for each request
+func accessLogHandler(h http.Handler, w io.Writer, format string) http.HandlerFunc {
+	var mu sync.Mutex
+	return http.HandlerFunc(
+		func(rw http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			recorder := &responseRecorder{ResponseWriter: rw}
+			h.ServeHTTP(recorder, r) // call original
+			line := formatAccess(format, r, recorder.statusCode(), recorder.bytes, start, time.Since(start))
+
+			mu.Lock()
+			defer mu.Unlock()
+			fmt.Fprintln(w, line)
+		})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestFormatAccess(t *testing.T) {
+	start := time.Date(2018, 6, 1, 22, 30, 5, 0, time.FixedZone("BST", 3600))
+	r := httptest.NewRequest("GET", "/light?mode=on&secs=60", nil)
+	r.RemoteAddr = "192.168.1.20:53211"
+	r.Header.Set("User-Agent", "Mozilla/5.0 (iPhone)")
+
+	testCases := []struct {
+		format   string
+		expected string
+	}{
+		{accessFormatCommon, `192.168.1.20 - - [01/Jun/2018:22:30:05 +0100] "GET /light?mode=on&secs=60 HTTP/1.1" 200 12 1500`},
+		{accessFormatCombined, `192.168.1.20 - - [01/Jun/2018:22:30:05 +0100] "GET /light?mode=on&secs=60 HTTP/1.1" 200 12 "-" "Mozilla/5.0 (iPhone)" 1500`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.format, func(t *testing.T) {
+			actual := formatAccess(tc.format, r, 200, 12, start, 1500*time.Microsecond)
+			if actual != tc.expected {
+				t.Errorf("got %q; expected %q", actual, tc.expected)
+			}
+		})
+	}
+}
+
+func TestClientIdentity(t *testing.T) {
+	r := httptest.NewRequest("GET", "/about", nil)
+	if id := clientIdentity(r); id != "-" {
+		t.Errorf("got %v; expected - for plain HTTP", id)
+	}
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "kitchen phone"}}
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	if id := clientIdentity(r); id != "kitchen_phone" {
+		t.Errorf("got %v; expected kitchen_phone", id)
+	}
+}
+
+func TestAccessLogHandler(t *testing.T) {
+	var buf bytes.Buffer
+	mux := http.NewServeMux()
+	mux.HandleFunc("/light", func(w http.ResponseWriter, r *http.Request) {
+		respond(w, "Missing 'mode' value", http.StatusUnprocessableEntity)
+	})
+	handler := accessLogHandler(mux, &buf, accessFormatCommon)
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", "/light", nil))
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/missing", nil))
+
+	pattern := regexp.MustCompile(`^192\.0\.2\.1 - - \[[^\]]+\] "POST /light HTTP/1\.1" 422 21 \d+\n` +
+		`192\.0\.2\.1 - - \[[^\]]+\] "GET /missing HTTP/1\.1" 404 19 \d+\n$`)
+	if !pattern.MatchString(buf.String()) {
+		t.Errorf("unexpected access log %q", buf.String())
+	}
+}
+
+func TestResponseRecorderFlush(t *testing.T) {
+	var _ http.Flusher = &responseRecorder{}
+	var _ http.Hijacker = &responseRecorder{}
+
+	w := httptest.NewRecorder()
+	r := &responseRecorder{ResponseWriter: w}
+	r.Flush()
+	if !w.Flushed || r.statusCode() != 200 {
+		t.Errorf("got flushed %v status %v; expected flushed with 200", w.Flushed, r.statusCode())
+	}
+	fmt.Fprint(r, "data")
+	if r.bytes != 4 {
+		t.Errorf("got %d bytes; expected 4", r.bytes)
+	}
+}
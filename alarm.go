@@ -3,12 +3,15 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
+	"strconv"
 	"time"
 
 	astro "github.com/kelvins/sunrisesunset"
 )
 
+// alarmLog is the logger for the alarm subsystem
+var alarmLog = newLogger("alarm")
+
 type alarm struct {
 	setC   chan bool
 	isSetC chan bool
@@ -16,7 +19,8 @@ type alarm struct {
 }
 
 // newAlarm creates a new alarm
-func newAlarm(ctx context.Context, accuracy time.Duration) alarm {
+// changes to the alarm and rings are published on bus
+func newAlarm(ctx context.Context, accuracy time.Duration, bus *eventBus) alarm {
 	a := alarm{
 		setC:   make(chan bool),
 		isSetC: make(chan bool),
@@ -30,10 +34,14 @@ func newAlarm(ctx context.Context, accuracy time.Duration) alarm {
 			select {
 
 			case on = <-a.setC:
+				alarmSetsTotal.inc(strconv.FormatBool(on))
+				bus.publish(event{Type: eventAlarm, On: on})
 			case a.isSetC <- on:
 			case now := <-a.ticker.C:
 				if on {
-					log.Printf("%v\n", now)
+					alarmLog.infof("%v", now)
+					alarmRingsTotal.inc()
+					bus.publish(event{Type: eventAlarm, Time: now, On: on, Message: "ringing"})
 				}
 			case <-ctx.Done():
 				close(a.setC)
@@ -59,16 +67,33 @@ func (a alarm) isSet() bool {
 // sunset returns the time of sunset in dayOffset days from today in the system's local time
 func sunset(latitude, longitude float64, dayOffset int) (time.Time, error) {
 	now := time.Now().Add(time.Duration(dayOffset*24) * time.Hour) // local time plus offset
-	_, offset := now.Zone()                                        // offset in seconds
+	return sunsetOn(latitude, longitude, now)
+}
+
+// sunsetOn returns the time of sunset on the same day as day in the location of day
+func sunsetOn(latitude, longitude float64, day time.Time) (time.Time, error) {
+	_, offset := day.Zone() // offset in seconds
 
 	// GetSunriseSunset expects the UTC in units of hours
-	_, sunset, err := astro.GetSunriseSunset(latitude, longitude, float64(offset/3600), now)
+	_, sunset, err := astro.GetSunriseSunset(latitude, longitude, float64(offset/3600), day)
 	if err != nil {
 		return sunset, err
 	}
 
-	// the date returned by GetSunriseSunset is the "zero" value so construct a new Time using the current time
-	return time.Date(now.Year(), now.Month(), now.Day(), sunset.Hour(), sunset.Minute(), sunset.Second(), 0, now.Location()), nil
+	// the date returned by GetSunriseSunset is the "zero" value so construct a new Time using the given day
+	return time.Date(day.Year(), day.Month(), day.Day(), sunset.Hour(), sunset.Minute(), sunset.Second(), 0, day.Location()), nil
+}
+
+// sunriseOn returns the time of sunrise on the same day as day in the location of day
+func sunriseOn(latitude, longitude float64, day time.Time) (time.Time, error) {
+	_, offset := day.Zone() // offset in seconds
+
+	// GetSunriseSunset expects the UTC in units of hours
+	sunrise, _, err := astro.GetSunriseSunset(latitude, longitude, float64(offset/3600), day)
+	if err != nil {
+		return sunrise, err
+	}
+	return time.Date(day.Year(), day.Month(), day.Day(), sunrise.Hour(), sunrise.Minute(), sunrise.Second(), 0, day.Location()), nil
 }
 
 // nextTime returns the first time at hour:minute after the given day i.e.
@@ -92,6 +117,6 @@ func newNotification( t time.Time ) (*time.Timer, error){
     if d < 0 {
         return nil, fmt.Errorf("time is in the past: %v", t)
     }
-    log.Printf("notification set that will fire in %v\n", d)
+    newLogger("notification").infof("notification set that will fire in %v", d)
     return time.NewTimer(d), nil
 }
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// plugStatus reports the state of a plug
+type plugStatus struct {
+	Name          string     `json:"name"`
+	On            bool       `json:"on"`
+	OverrideUntil *time.Time `json:"override_until,omitempty"`
+}
+
+// sunsetStatus reports the sunset times around today; a missing time means that there is no sunset on that day
+type sunsetStatus struct {
+	Yesterday *time.Time `json:"yesterday,omitempty"`
+	Today     *time.Time `json:"today,omitempty"`
+	Tomorrow  *time.Time `json:"tomorrow,omitempty"`
+}
+
+// eveningStatus reports an upcoming sunset or lights out
+type eveningStatus struct {
+	Time time.Time `json:"time"`
+	Name string    `json:"name"`
+}
+
+// monitors are the background monitors whose state is reported by the API; nil monitors report nothing
+type monitors struct {
+	thermal    *thermalMonitor
+	motion     *motionMonitor
+	light      *lightMonitor
+	thermostat *thermostat
+}
+
+// status is the state of the server reported by the API
+type status struct {
+	Version    int               `json:"version"`
+	BuildType  string            `json:"build_type"`
+	Time       time.Time         `json:"time"`
+	Location   [2]float64        `json:"location"`
+	Plugs      []plugStatus      `json:"plugs"`
+	Alarm      bool              `json:"alarm"`
+	Sunset     sunsetStatus      `json:"sunset"`
+	LightsOut  string            `json:"lights_out"`
+	Evening    []eveningStatus   `json:"evening"`
+	Thermal    []zoneStatus      `json:"thermal"`
+	Motion     []motionStatus    `json:"motion"`
+	Light      *luxStatus        `json:"light,omitempty"`
+	Thermostat *thermostatStatus `json:"thermostat,omitempty"`
+}
+
+// getStatus collects the state of the server at time now
+func getStatus(now time.Time, plugs []namedPlug, a alarmInterface, monitors monitors, config configuration) status {
+	s := status{
+		Version:    version,
+		BuildType:  buildType,
+		Time:       now,
+		Location:   config.location,
+		Plugs:      []plugStatus{},
+		Alarm:      a.isSet(),
+		LightsOut:  config.lightsOut,
+		Evening:    []eveningStatus{},
+		Thermal:    monitors.thermal.status(),
+		Motion:     monitors.motion.status(),
+		Light:      monitors.light.status(),
+		Thermostat: monitors.thermostat.status(),
+	}
+
+	for _, p := range plugs {
+		ps := plugStatus{Name: p.name, On: p.state()}
+		if until := p.overrideUntil(); !until.IsZero() {
+			ps.OverrideUntil = &until
+		}
+		s.Plugs = append(s.Plugs, ps)
+	}
+
+	latitude, longitude := config.latLong()
+	sunsetPtr := func(dayOffset int) *time.Time {
+		t, err := sunsetOn(latitude, longitude, now.AddDate(0, 0, dayOffset))
+		if err != nil {
+			return nil
+		}
+		return &t
+	}
+	s.Sunset = sunsetStatus{Yesterday: sunsetPtr(-1), Today: sunsetPtr(0), Tomorrow: sunsetPtr(1)}
+
+	for _, e := range upcomingEvening(config, now, now.AddDate(0, 0, 1)) {
+		s.Evening = append(s.Evening, eveningStatus{Time: e.at, Name: e.name})
+	}
+	return s
+}
+
+// respondJSON writes v as the JSON body of the http response
+func respondJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		httpLog.errorf("JSON response error %v", err)
+	}
+}
+
+// statusHandlerFunc returns a handler function that reports the state of the server as JSON
+func statusHandlerFunc(plugs []namedPlug, a alarmInterface, m monitors, store *configStore) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		disableCache(w)
+		respondJSON(w, getStatus(time.Now(), plugs, a, m, store.get()))
+	}
+}
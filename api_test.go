@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakePlug records the state set by the handlers
+type fakePlug struct {
+	on    bool
+	until time.Time
+}
+
+func (p *fakePlug) set(ctx context.Context, on bool) {
+	p.on = on
+}
+
+func (p *fakePlug) setForDuration(ctx context.Context, on bool, d time.Duration) {
+	p.on = on
+	p.until = time.Now().Add(d)
+}
+
+func (p *fakePlug) state() bool {
+	return p.on
+}
+
+func (p *fakePlug) overrideUntil() time.Time {
+	return p.until
+}
+
+func (p *fakePlug) mirror(ctx context.Context, on bool) {
+	p.on = on
+}
+
+// fakeAlarm records whether the alarm is set
+type fakeAlarm bool
+
+func (a *fakeAlarm) set(on bool) {
+	*a = fakeAlarm(on)
+}
+
+func (a *fakeAlarm) isSet() bool {
+	return bool(*a)
+}
+
+func TestGetStatus(t *testing.T) {
+	now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	until := now.Add(time.Hour)
+	plugs := []namedPlug{
+		{name: "light", plugInterface: &fakePlug{on: true, until: until}},
+		{name: "heater", plugInterface: &fakePlug{}},
+	}
+	alarm := fakeAlarm(true)
+	config := configuration{
+		location:  [2]float64{londonLat, londonLon},
+		lightsOut: "23:30",
+		plugs:     []plugConfiguration{{name: "light", id: plugOne}},
+	}
+
+	s := getStatus(now, plugs, &alarm, monitors{}, config)
+	if len(s.Plugs) != 2 || s.Plugs[0].Name != "light" || !s.Plugs[0].On || s.Plugs[1].On {
+		t.Errorf("unexpected plugs %v", s.Plugs)
+	}
+	if s.Plugs[0].OverrideUntil == nil || !s.Plugs[0].OverrideUntil.Equal(until) {
+		t.Errorf("override until %v; expected %v", s.Plugs[0].OverrideUntil, until)
+	}
+	if s.Plugs[1].OverrideUntil != nil {
+		t.Errorf("override until %v; expected none", s.Plugs[1].OverrideUntil)
+	}
+	if !s.Alarm {
+		t.Errorf("alarm is unset; expected set")
+	}
+	if s.Sunset.Today == nil || s.Sunset.Today.Day() != 1 {
+		t.Errorf("today's sunset %v; expected a time on the 1st", s.Sunset.Today)
+	}
+	if len(s.Evening) != 2 || s.Evening[0].Name != "sunset" || s.Evening[1].Name != "lights out" {
+		t.Errorf("got evening %v; expected sunset and lights out", s.Evening)
+	}
+}
+
+func TestStatusHandler(t *testing.T) {
+	alarm := fakeAlarm(false)
+	store := &configStore{config: configuration{location: [2]float64{londonLat, londonLon}, lightsOut: "23:30"}}
+	handler := statusHandlerFunc([]namedPlug{{name: "light", plugInterface: &fakePlug{}}}, &alarm, monitors{}, store)
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/api/v1/status", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("content type %v; expected application/json", ct)
+	}
+	var s status
+	if err := json.NewDecoder(w.Body).Decode(&s); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(s.Plugs) != 1 || s.Plugs[0].Name != "light" || s.LightsOut != "23:30" {
+		t.Errorf("unexpected status %v", s)
+	}
+}
+
+func TestDashboardHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	dashboardHandler(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != 200 || w.Body.Len() == 0 {
+		t.Errorf("got status %v with %d bytes; expected the dashboard", w.Code, w.Body.Len())
+	}
+
+	w = httptest.NewRecorder()
+	dashboardHandler(w, httptest.NewRequest("GET", "/missing", nil))
+	if w.Code != 404 {
+		t.Errorf("got status %v; expected 404", w.Code)
+	}
+}
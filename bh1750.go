@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"periph.io/x/periph/conn/i2c"
+)
+
+// BH1750 addresses, selected by the level of the ADDR pin
+const (
+	bh1750AddressLow  = 0x23
+	bh1750AddressHigh = 0x5c
+)
+
+// BH1750 instructions
+const (
+	bh1750PowerOn        = 0x01
+	bh1750Reset          = 0x07
+	bh1750ContinuousHigh = 0x10 // continuous measurement at a resolution of 1 lx
+)
+
+// bh1750MeasurementTime is the longest time taken by a high resolution measurement
+var bh1750MeasurementTime = 180 * time.Millisecond
+
+// bh1750 is a BH1750 ambient light sensor on an I2C bus
+type bh1750 struct {
+	dev i2c.Dev
+}
+
+// newBH1750 powers on the sensor at addr and starts continuous measurement
+// it waits for the first measurement so that the first read returns a real value
+func newBH1750(bus i2c.Bus, addr uint16) (*bh1750, error) {
+	b := &bh1750{dev: i2c.Dev{Bus: bus, Addr: addr}}
+	for _, instruction := range []byte{bh1750PowerOn, bh1750Reset, bh1750ContinuousHigh} {
+		if err := b.dev.Tx([]byte{instruction}, nil); err != nil {
+			return nil, fmt.Errorf("BH1750 at %#x not responding; %v", addr, err)
+		}
+	}
+	time.Sleep(bh1750MeasurementTime)
+	return b, nil
+}
+
+// lux returns the most recent measurement of the illuminance
+func (b *bh1750) lux() (float64, error) {
+	var data [2]byte
+	if err := b.dev.Tx(nil, data[:]); err != nil {
+		return 0, err
+	}
+	// the count is 1.2 times the illuminance at the default measurement time
+	return float64(uint16(data[0])<<8|uint16(data[1])) / 1.2, nil
+}
+
+func (b *bh1750) String() string {
+	return fmt.Sprintf("BH1750{%s}", &b.dev)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// fakeI2CBus is an I2C bus whose devices are simulated by functions that answer each transaction
+type fakeI2CBus struct {
+	mu      sync.Mutex
+	devices map[uint16]func(w, r []byte) error
+	writes  [][]byte // the data written by each transaction, in order
+	closed  bool
+}
+
+func (b *fakeI2CBus) Tx(addr uint16, w, r []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	device, ok := b.devices[addr]
+	if !ok {
+		return fmt.Errorf("no device at %#x", addr)
+	}
+	if len(w) > 0 {
+		b.writes = append(b.writes, append([]byte(nil), w...))
+	}
+	return device(w, r)
+}
+
+func (b *fakeI2CBus) SetSpeed(hz int64) error { return nil }
+func (b *fakeI2CBus) String() string          { return "fake" }
+
+func (b *fakeI2CBus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	return nil
+}
+
+// fakeBH1750 returns a simulated BH1750 that reports count and fails reads once it is switched off
+func fakeBH1750(count uint16, off *bool) func(w, r []byte) error {
+	return func(w, r []byte) error {
+		if *off {
+			return errors.New("no acknowledgement")
+		}
+		if len(r) > 0 {
+			r[0], r[1] = byte(count>>8), byte(count)
+		}
+		return nil
+	}
+}
+
+func TestBH1750(t *testing.T) {
+	bh1750MeasurementTime = 0
+	testCases := []struct {
+		count    uint16
+		expected float64
+	}{
+		{0, 0},
+		{120, 100},
+		{0xffff, 54612.5},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("count %d", tc.count), func(t *testing.T) {
+			off := false
+			bus := &fakeI2CBus{devices: map[uint16]func(w, r []byte) error{bh1750AddressHigh: fakeBH1750(tc.count, &off)}}
+			sensor, err := newBH1750(bus, bh1750AddressHigh)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedWrites := [][]byte{{bh1750PowerOn}, {bh1750Reset}, {bh1750ContinuousHigh}}
+			if len(bus.writes) != len(expectedWrites) {
+				t.Fatalf("writes %v; expected %v", bus.writes, expectedWrites)
+			}
+			for i, w := range bus.writes {
+				if !bytes.Equal(w, expectedWrites[i]) {
+					t.Errorf("write %d is %v; expected %v", i, w, expectedWrites[i])
+				}
+			}
+
+			if lux, err := sensor.lux(); err != nil || lux != tc.expected {
+				t.Errorf("got %v %v; expected %v lux", lux, err, tc.expected)
+			}
+			off = true
+			if _, err := sensor.lux(); err == nil {
+				t.Errorf("expected an error from a sensor that doesn't respond")
+			}
+		})
+	}
+}
+
+func TestBH1750Missing(t *testing.T) {
+	bus := &fakeI2CBus{devices: map[uint16]func(w, r []byte) error{}}
+	if _, err := newBH1750(bus, bh1750AddressLow); err == nil {
+		t.Errorf("expected an error for a missing sensor")
+	}
+}
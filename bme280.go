@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"periph.io/x/periph/conn/i2c"
+)
+
+// BME280 addresses, selected by the level of the SDO pin
+const (
+	bme280AddressLow  = 0x76
+	bme280AddressHigh = 0x77
+)
+
+// BME280 registers
+const (
+	bme280RegCalibration1 = 0x88 // 26 bytes of temperature, pressure and the first humidity calibration
+	bme280RegID           = 0xd0
+	bme280RegReset        = 0xe0
+	bme280RegCalibration2 = 0xe1 // 7 bytes of humidity calibration
+	bme280RegCtrlHum      = 0xf2
+	bme280RegStatus       = 0xf3
+	bme280RegCtrlMeas     = 0xf4
+	bme280RegData         = 0xf7 // 8 bytes of pressure, temperature and humidity
+)
+
+const (
+	bme280ChipID      = 0x60
+	bme280ResetValue  = 0xb6
+	bme280Measuring   = 0x08 // status bit set while a conversion is running
+	bme280Oversample1 = 0x01
+	// bme280ForcedMeasure oversamples temperature and pressure once and takes a single measurement
+	bme280ForcedMeasure = bme280Oversample1<<5 | bme280Oversample1<<2 | 0x01
+)
+
+// bme280StartupTime is the time taken to copy the calibration after a reset
+var bme280StartupTime = 2 * time.Millisecond
+
+// bme280MeasurementTime is the longest time taken by a measurement without further oversampling
+var bme280MeasurementTime = 10 * time.Millisecond
+
+// bme280Calibration holds the trimming parameters programmed into each BME280
+type bme280Calibration struct {
+	t1             uint16
+	t2, t3         int16
+	p1             uint16
+	p2, p3, p4, p5 int16
+	p6, p7, p8, p9 int16
+	h1, h3         uint8
+	h2, h4, h5     int16
+	h6             int8
+}
+
+// bme280 is a Bosch BME280 temperature, humidity and pressure sensor on an I2C bus
+type bme280 struct {
+	dev         i2c.Dev
+	calibration bme280Calibration
+}
+
+// newBME280 checks the identity of the sensor at addr, resets it and reads its calibration
+func newBME280(bus i2c.Bus, addr uint16) (*bme280, error) {
+	b := &bme280{dev: i2c.Dev{Bus: bus, Addr: addr}}
+	var id [1]byte
+	if err := b.dev.Tx([]byte{bme280RegID}, id[:]); err != nil {
+		return nil, fmt.Errorf("BME280 at %#x not responding; %v", addr, err)
+	}
+	if id[0] != bme280ChipID {
+		return nil, fmt.Errorf("device at %#x has chip id %#x; not a BME280", addr, id[0])
+	}
+	if err := b.dev.Tx([]byte{bme280RegReset, bme280ResetValue}, nil); err != nil {
+		return nil, err
+	}
+	time.Sleep(bme280StartupTime)
+
+	var c1 [26]byte
+	var c2 [7]byte
+	if err := b.dev.Tx([]byte{bme280RegCalibration1}, c1[:]); err != nil {
+		return nil, err
+	}
+	if err := b.dev.Tx([]byte{bme280RegCalibration2}, c2[:]); err != nil {
+		return nil, err
+	}
+	b.calibration = parseBME280Calibration(c1, c2)
+
+	// humidity oversampling only takes effect after ctrl_meas is written
+	if err := b.dev.Tx([]byte{bme280RegCtrlHum, bme280Oversample1}, nil); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// parseBME280Calibration decodes the calibration registers, which are little endian except for the 12-bit h4 and h5
+func parseBME280Calibration(c1 [26]byte, c2 [7]byte) bme280Calibration {
+	u16 := func(b []byte) uint16 { return binary.LittleEndian.Uint16(b) }
+	s16 := func(b []byte) int16 { return int16(binary.LittleEndian.Uint16(b)) }
+	return bme280Calibration{
+		t1: u16(c1[0:]), t2: s16(c1[2:]), t3: s16(c1[4:]),
+		p1: u16(c1[6:]), p2: s16(c1[8:]), p3: s16(c1[10:]), p4: s16(c1[12:]), p5: s16(c1[14:]),
+		p6: s16(c1[16:]), p7: s16(c1[18:]), p8: s16(c1[20:]), p9: s16(c1[22:]),
+		h1: c1[25],
+		h2: s16(c2[0:]), h3: c2[2],
+		h4: int16(int8(c2[3]))<<4 | int16(c2[4]&0x0f),
+		h5: int16(int8(c2[5]))<<4 | int16(c2[4]>>4),
+		h6: int8(c2[6]),
+	}
+}
+
+// sense takes a single measurement
+func (b *bme280) sense() (map[string]float64, error) {
+	if err := b.dev.Tx([]byte{bme280RegCtrlMeas, bme280ForcedMeasure}, nil); err != nil {
+		return nil, err
+	}
+	time.Sleep(bme280MeasurementTime)
+	var status [1]byte
+	if err := b.dev.Tx([]byte{bme280RegStatus}, status[:]); err != nil {
+		return nil, err
+	}
+	if status[0]&bme280Measuring != 0 {
+		return nil, fmt.Errorf("BME280 measurement incomplete")
+	}
+	var data [8]byte
+	if err := b.dev.Tx([]byte{bme280RegData}, data[:]); err != nil {
+		return nil, err
+	}
+	adcP := int32(data[0])<<12 | int32(data[1])<<4 | int32(data[2])>>4
+	adcT := int32(data[3])<<12 | int32(data[4])<<4 | int32(data[5])>>4
+	adcH := int32(data[6])<<8 | int32(data[7])
+	celsius, fine := b.calibration.temperature(adcT)
+	return map[string]float64{
+		quantityTemperature: celsius,
+		quantityPressure:    b.calibration.pressure(adcP, fine) / 100,
+		quantityHumidity:    b.calibration.humidity(adcH, fine),
+	}, nil
+}
+
+// temperature returns degrees Celsius and the fine temperature used to compensate the other measurements
+// the compensation formulas are the floating point versions from the datasheet
+func (c bme280Calibration) temperature(adc int32) (celsius, fine float64) {
+	v1 := (float64(adc)/16384 - float64(c.t1)/1024) * float64(c.t2)
+	v2 := float64(adc)/131072 - float64(c.t1)/8192
+	v2 = v2 * v2 * float64(c.t3)
+	fine = v1 + v2
+	return fine / 5120, fine
+}
+
+// pressure returns Pascals
+func (c bme280Calibration) pressure(adc int32, fine float64) float64 {
+	v1 := fine/2 - 64000
+	v2 := v1 * v1 * float64(c.p6) / 32768
+	v2 += v1 * float64(c.p5) * 2
+	v2 = v2/4 + float64(c.p4)*65536
+	v1 = (float64(c.p3)*v1*v1/524288 + float64(c.p2)*v1) / 524288
+	v1 = (1 + v1/32768) * float64(c.p1)
+	if v1 == 0 {
+		return 0 // avoid division by zero
+	}
+	p := 1048576 - float64(adc)
+	p = (p - v2/4096) * 6250 / v1
+	v1 = float64(c.p9) * p * p / 2147483648
+	v2 = p * float64(c.p8) / 32768
+	return p + (v1+v2+float64(c.p7))/16
+}
+
+// humidity returns the relative humidity in percent
+func (c bme280Calibration) humidity(adc int32, fine float64) float64 {
+	h := fine - 76800
+	h = (float64(adc) - (float64(c.h4)*64 + float64(c.h5)/16384*h)) *
+		(float64(c.h2) / 65536 * (1 + float64(c.h6)/67108864*h*(1+float64(c.h3)/67108864*h)))
+	h *= 1 - float64(c.h1)*h/524288
+	switch {
+	case h > 100:
+		return 100
+	case h < 0:
+		return 0
+	}
+	return h
+}
+
+func (b *bme280) String() string {
+	return fmt.Sprintf("BME280{%s}", &b.dev)
+}
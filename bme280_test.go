@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+)
+
+// fakeRegisters returns a simulated I2C device with 8-bit registers
+// a transaction writes a register address followed by values to write, or reads from that address onward
+func fakeRegisters(registers *[256]byte) func(w, r []byte) error {
+	return func(w, r []byte) error {
+		if len(w) == 0 {
+			return errors.New("no register address")
+		}
+		for i, b := range w[1:] {
+			registers[int(w[0])+i] = b
+		}
+		for i := range r {
+			r[i] = registers[int(w[0])+i]
+		}
+		return nil
+	}
+}
+
+// datasheetBME280 returns the registers of a BME280 with the calibration and raw readings of the datasheet example
+func datasheetBME280() *[256]byte {
+	var registers [256]byte
+	registers[bme280RegID] = bme280ChipID
+	calibration := []int{27504, 26435, -1000, 36477, -10685, 3024, 2855, 140, -7, 15500, -14600, 6000}
+	for i, v := range calibration {
+		binary.LittleEndian.PutUint16(registers[bme280RegCalibration1+2*i:], uint16(int16(v)))
+	}
+	registers[bme280RegCalibration1+25] = 75                                     // h1
+	binary.LittleEndian.PutUint16(registers[bme280RegCalibration2:], 362)        // h2
+	copy(registers[bme280RegCalibration2+2:], []byte{0, 0x13, 0x29, 0x03, 0x1e}) // h3, h4 313, h5 50, h6 30
+	// adc_P 415148, adc_T 519888 and adc_H 30000
+	copy(registers[bme280RegData:], []byte{0x65, 0x5a, 0xc0, 0x7e, 0xed, 0x00, 0x75, 0x30})
+	return &registers
+}
+
+func TestBME280(t *testing.T) {
+	bme280StartupTime, bme280MeasurementTime = 0, 0
+	registers := datasheetBME280()
+	bus := &fakeI2CBus{devices: map[uint16]func(w, r []byte) error{bme280AddressHigh: fakeRegisters(registers)}}
+
+	sensor, err := newBME280(bus, bme280AddressHigh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := sensor.calibration
+	if c.t1 != 27504 || c.t3 != -1000 || c.p9 != 6000 || c.h1 != 75 || c.h2 != 362 || c.h4 != 313 || c.h5 != 50 || c.h6 != 30 {
+		t.Errorf("calibration %+v doesn't match the registers", c)
+	}
+	if registers[bme280RegReset] != bme280ResetValue || registers[bme280RegCtrlHum] != bme280Oversample1 {
+		t.Errorf("reset %#x and ctrl_hum %#x; expected %#x and %#x", registers[bme280RegReset], registers[bme280RegCtrlHum],
+			bme280ResetValue, bme280Oversample1)
+	}
+
+	values, err := sensor.sense()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if registers[bme280RegCtrlMeas] != bme280ForcedMeasure {
+		t.Errorf("ctrl_meas %#x; expected a forced measurement %#x", registers[bme280RegCtrlMeas], bme280ForcedMeasure)
+	}
+	// the datasheet gives 25.08°C and 100653.27 Pa
+	expected := map[string]float64{quantityTemperature: 25.08, quantityPressure: 1006.53}
+	for quantity, value := range expected {
+		if math.Abs(values[quantity]-value) > 0.01 {
+			t.Errorf("%s %v; expected %v", quantity, values[quantity], value)
+		}
+	}
+	if h := values[quantityHumidity]; h <= 0 || h >= 100 {
+		t.Errorf("humidity %v; expected a value between 0 and 100", h)
+	}
+
+	registers[bme280RegStatus] = bme280Measuring
+	if _, err = sensor.sense(); err == nil {
+		t.Errorf("expected an error while measuring")
+	}
+}
+
+func TestBME280Identity(t *testing.T) {
+	registers := datasheetBME280()
+	registers[bme280RegID] = 0x58 // a BMP280
+	bus := &fakeI2CBus{devices: map[uint16]func(w, r []byte) error{bme280AddressLow: fakeRegisters(registers)}}
+	if _, err := newBME280(bus, bme280AddressLow); err == nil {
+		t.Errorf("expected an error for a device that isn't a BME280")
+	}
+	if _, err := newBME280(bus, bme280AddressHigh); err == nil {
+		t.Errorf("expected an error for a missing device")
+	}
+}
+
+func TestBME280Calibration(t *testing.T) {
+	var c1 [26]byte
+	// h4 and h5 are 12-bit values that share the nibbles of one register
+	c2 := [7]byte{0, 0, 0, 0xff, 0x8f, 0x80, 0xfe}
+	c := parseBME280Calibration(c1, c2)
+	if c.h4 != -1 || c.h5 != -2040 || c.h6 != -2 {
+		t.Errorf("h4 %d h5 %d h6 %d; expected -1, -2040 and -2", c.h4, c.h5, c.h6)
+	}
+}
+
+func TestBME280Humidity(t *testing.T) {
+	c := bme280Calibration{h1: 75, h2: 362, h4: 313, h5: 50, h6: 30}
+	_, fine := bme280Calibration{t1: 27504, t2: 26435, t3: -1000}.temperature(519888)
+	low, high := c.humidity(25000, fine), c.humidity(35000, fine)
+	if !(low < high) {
+		t.Errorf("humidity %v at a lower reading isn't below %v", low, high)
+	}
+	if h := c.humidity(0, fine); h != 0 {
+		t.Errorf("humidity %v; expected it to be limited to 0", h)
+	}
+	if h := c.humidity(65535, fine); h != 100 {
+		t.Errorf("humidity %v; expected it to be limited to 100", h)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"periph.io/x/periph/conn/gpio"
+)
+
+// button actions
+const (
+	buttonToggle = "toggle"
+	buttonOn     = "on"
+	buttonOff    = "off"
+	buttonAllOn  = "all_on"
+	buttonAllOff = "all_off"
+)
+
+const (
+	defaultDebounce  = 50 * time.Millisecond
+	defaultLongPress = time.Second
+	// buttonPoll is the longest wait for an edge before checking whether the button should stop
+	buttonPoll = time.Second
+)
+
+// buttonLog is the logger for the button subsystem
+var buttonLog = newLogger("button")
+
+// buttonConfiguration describes a push button wired to a GPIO input
+type buttonConfiguration struct {
+	name       string
+	pin        string // the periph name of the pin, such as GPIO5 or P1_29
+	pull       gpio.Pull
+	activeLow  bool // the button connects the pin to ground when pressed
+	debounce   time.Duration
+	action     string
+	plug       string // the plug changed by toggle, on and off
+	longPress  time.Duration
+	longAction string // empty if a long press is treated as a press
+}
+
+// isButtonAction returns true if action is one that a button can make
+func isButtonAction(action string) bool {
+	switch action {
+	case buttonToggle, buttonOn, buttonOff, buttonAllOn, buttonAllOff:
+		return true
+	}
+	return false
+}
+
+// watchButton configures pin as an input and calls press with the action of each press of the button
+// edges are ignored until the contacts settle at the end of the debounce period
+// when a long press action is set, the press action is made on release so that the two can be told apart
+// the heartbeat of the watching routine is checked for readiness under the given name
+func watchButton(ctx context.Context, b buttonConfiguration, routine string, pin gpio.PinIn, press func(action string)) error {
+	if err := pin.In(b.pull, gpio.BothEdges); err != nil {
+		return err
+	}
+	alive, stop := startHeartbeat(routine, time.Now())
+	go func() {
+		defer stop()
+		defer pin.In(gpio.PullNoChange, gpio.NoEdge)
+		down, long := false, false
+		var downAt time.Time
+		for ctx.Err() == nil {
+			timeout := buttonPoll
+			awaitingLong := down && b.longAction != "" && !long
+			if awaitingLong {
+				if remaining := b.longPress - time.Since(downAt); remaining < timeout {
+					timeout = remaining
+				}
+			}
+			alive.beat(time.Now(), timeout+b.debounce)
+			if timeout > 0 && pin.WaitForEdge(timeout) {
+				time.Sleep(b.debounce)
+				pressed := (pin.Read() == gpio.Low) == b.activeLow
+				switch {
+				case pressed && !down:
+					down, long, downAt = true, false, time.Now()
+					if b.longAction == "" {
+						press(b.action)
+					}
+				case !pressed && down:
+					down = false
+					if b.longAction != "" && !long {
+						press(b.action)
+					}
+				}
+				continue
+			}
+			if awaitingLong && time.Since(downAt) >= b.longPress {
+				long = true
+				press(b.longAction)
+			}
+		}
+	}()
+	return nil
+}
+
+// buttonPressFunc returns a function that makes the actions of button b on plugs
+// presses are published on bus and changes to the plugs have the button as their source
+func buttonPressFunc(ctx context.Context, b buttonConfiguration, plugs []namedPlug, bus *eventBus) func(action string) {
+	ctx = withOrigin(ctx, origin{source: sourceButton})
+	l := buttonLog.with(fields{"button": b.name})
+	return func(action string) {
+		l.infof("pressed %s", action)
+		bus.publish(event{Type: eventButton, Name: b.name, On: true, Source: sourceButton, Message: action})
+		switch action {
+		case buttonAllOn, buttonAllOff:
+			for _, p := range plugs {
+				p.set(ctx, action == buttonAllOn)
+			}
+			return
+		}
+		p, ok := findPlug(plugs, b.plug)
+		if !ok {
+			l.errorf("plug %s not found", b.plug)
+			return
+		}
+		switch action {
+		case buttonToggle:
+			p.set(ctx, !p.state())
+		case buttonOn:
+			p.set(ctx, true)
+		case buttonOff:
+			p.set(ctx, false)
+		}
+	}
+}
+
+// startButtons watches each configured button; a button whose pin can't be used is logged and skipped
+func startButtons(ctx context.Context, buttons []buttonConfiguration, plugs []namedPlug, bus *eventBus) {
+	for _, b := range buttons {
+		pin, err := openInputPin(b.pin)
+		if err == nil {
+			err = watchButton(ctx, b, "button "+b.name, pin, buttonPressFunc(ctx, b, plugs, bus))
+		}
+		if err != nil {
+			buttonLog.with(fields{"button": b.name}).errorf("button on %s unavailable; %v", b.pin, err)
+			continue
+		}
+		buttonLog.with(fields{"button": b.name}).infof("watching %s", b.pin)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"periph.io/x/periph/conn/gpio"
+)
+
+// pressRecorder collects the actions of a watched button
+type pressRecorder chan string
+
+func (r pressRecorder) press(action string) {
+	r <- action
+}
+
+// next returns the next action or an empty string if there is none within a short time
+func (r pressRecorder) next() string {
+	select {
+	case action := <-r:
+		return action
+	case <-time.After(200 * time.Millisecond):
+		return ""
+	}
+}
+
+func TestWatchButton(t *testing.T) {
+	testCases := []struct {
+		note     string
+		button   buttonConfiguration
+		sequence func(p *simulatedPin)
+		expected []string
+	}{
+		{
+			note:   "press",
+			button: buttonConfiguration{pull: gpio.PullUp, activeLow: true, debounce: 5 * time.Millisecond, action: buttonToggle},
+			sequence: func(p *simulatedPin) {
+				p.set(gpio.Low)
+				time.Sleep(20 * time.Millisecond)
+				p.set(gpio.High)
+			},
+			expected: []string{buttonToggle},
+		},
+		{
+			note:   "bouncing contacts",
+			button: buttonConfiguration{pull: gpio.PullUp, activeLow: true, debounce: 20 * time.Millisecond, action: buttonOn},
+			sequence: func(p *simulatedPin) {
+				for i := 0; i < 5; i++ {
+					p.set(gpio.Low)
+					p.set(gpio.High)
+				}
+				p.set(gpio.Low)
+				time.Sleep(50 * time.Millisecond)
+				p.set(gpio.High)
+			},
+			expected: []string{buttonOn},
+		},
+		{
+			note:   "active high",
+			button: buttonConfiguration{pull: gpio.PullDown, debounce: 5 * time.Millisecond, action: buttonOff},
+			sequence: func(p *simulatedPin) {
+				p.set(gpio.High)
+				time.Sleep(20 * time.Millisecond)
+				p.set(gpio.Low)
+			},
+			expected: []string{buttonOff},
+		},
+		{
+			note: "short press with long action",
+			button: buttonConfiguration{pull: gpio.PullUp, activeLow: true, debounce: 5 * time.Millisecond, action: buttonToggle,
+				longPress: 100 * time.Millisecond, longAction: buttonAllOff},
+			sequence: func(p *simulatedPin) {
+				p.set(gpio.Low)
+				time.Sleep(20 * time.Millisecond)
+				p.set(gpio.High)
+			},
+			expected: []string{buttonToggle},
+		},
+		{
+			note: "long press",
+			button: buttonConfiguration{pull: gpio.PullUp, activeLow: true, debounce: 5 * time.Millisecond, action: buttonToggle,
+				longPress: 50 * time.Millisecond, longAction: buttonAllOff},
+			sequence: func(p *simulatedPin) {
+				p.set(gpio.Low)
+				time.Sleep(120 * time.Millisecond)
+				p.set(gpio.High)
+			},
+			expected: []string{buttonAllOff},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.note, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			pin := newSimulatedPin(tc.note)
+			presses := make(pressRecorder, 10)
+			if err := watchButton(ctx, tc.button, "button "+tc.button.name, pin, presses.press); err != nil {
+				t.Fatal(err)
+			}
+			if pin.Pull() != tc.button.pull {
+				t.Errorf("pull %v; expected %v", pin.Pull(), tc.button.pull)
+			}
+
+			tc.sequence(pin)
+			for _, expected := range tc.expected {
+				if action := presses.next(); action != expected {
+					t.Errorf("action '%s'; expected '%s'", action, expected)
+				}
+			}
+			if action := presses.next(); action != "" {
+				t.Errorf("unexpected action '%s'", action)
+			}
+		})
+	}
+}
+
+func TestButtonPress(t *testing.T) {
+	testCases := []struct {
+		action        string
+		light, other  bool
+		expectedLight bool
+		expectedOther bool
+	}{
+		{buttonToggle, false, true, true, true},
+		{buttonToggle, true, false, false, false},
+		{buttonOn, false, false, true, false},
+		{buttonOff, true, true, false, true},
+		{buttonAllOn, false, false, true, true},
+		{buttonAllOff, true, true, false, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.action, func(t *testing.T) {
+			light, other := &fakePlug{on: tc.light}, &fakePlug{on: tc.other}
+			plugs := []namedPlug{{name: "light", plugInterface: light}, {name: "other", plugInterface: other}}
+			b := buttonConfiguration{name: "hall", plug: "light"}
+
+			buttonPressFunc(context.Background(), b, plugs, nil)(tc.action)
+
+			if light.on != tc.expectedLight || other.on != tc.expectedOther {
+				t.Errorf("light %v other %v; expected %v %v", light.on, other.on, tc.expectedLight, tc.expectedOther)
+			}
+		})
+	}
+}
+
+func TestSimulatedInput(t *testing.T) {
+	if simulatedInput("GPIO16") != simulatedInput("GPIO16") {
+		t.Errorf("expected the same pin for the same name")
+	}
+	if pin, err := openInputPin("GPIO12"); err != nil || pin.Name() != "GPIO12" {
+		t.Errorf("got %v %v; expected pin GPIO12", pin, err)
+	}
+}
@@ -0,0 +1,21 @@
+// +build linux
+
+package main
+
+import "syscall"
+
+// adjtimex clock states and status flags from <sys/timex.h>
+const (
+	timeError = 5
+	staUnsync = 0x0040
+)
+
+// clockSynchronised returns true if the kernel reports that the system clock is synchronised, e.g. by NTP
+func clockSynchronised() (bool, error) {
+	var tx syscall.Timex
+	state, err := syscall.Adjtimex(&tx)
+	if err != nil {
+		return false, err
+	}
+	return state != timeError && tx.Status&staUnsync == 0, nil
+}
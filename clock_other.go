@@ -0,0 +1,8 @@
+// +build !linux
+
+package main
+
+// clockSynchronised returns true as the synchronisation of the clock is only known on Linux
+func clockSynchronised() (bool, error) {
+	return true, nil
+}
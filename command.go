@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+const commandUsage = "usage: heihei config check|migrate <file>"
+
+// runCommand runs the subcommand given by args instead of the server, returning the exit status
+func runCommand(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 3 && args[0] == "config" {
+		switch args[1] {
+		case "check":
+			return checkConfigCommand(args[2], stdout, stderr)
+		case "migrate":
+			return migrateConfigCommand(args[2], stdout, stderr)
+		}
+	}
+	fmt.Fprintln(stderr, commandUsage)
+	return 2
+}
+
+// checkConfigCommand validates the configuration file at path without starting the server
+func checkConfigCommand(path string, stdout, stderr io.Writer) int {
+	if _, err := loadConfiguration(path, os.Environ()); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "%s: OK\n", path)
+	return 0
+}
+
+// migrateConfigCommand upgrades the configuration file at path to the current version, keeping the original at path.bak
+// only JSON files are rewritten; the changes needed to other formats are listed
+func migrateConfigCommand(path string, stdout, stderr io.Writer) int {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	var tree interface{}
+	switch configFormat(path) {
+	case configFormatYAML:
+		tree, err = parseYAML(data)
+	case configFormatTOML:
+		tree, err = parseTOML(data)
+	default:
+		if err = json.Unmarshal(data, &tree); err != nil {
+			err = decodeError(data, err)
+		}
+	}
+	root, ok := tree.(map[string]interface{})
+	if err == nil && !ok {
+		err = fmt.Errorf("Configuration should be an object")
+	}
+	var from int
+	var migrated []string
+	if err == nil {
+		from, migrated, err = migrateTree(root)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %s\n", path, err)
+		return 1
+	}
+	if from == configVersion {
+		fmt.Fprintf(stdout, "%s: already at version %d\n", path, configVersion)
+		return 0
+	}
+	for _, m := range migrated {
+		fmt.Fprintf(stdout, "%s: %s\n", path, m)
+	}
+	if configFormat(path) != configFormatJSON {
+		fmt.Fprintf(stderr, "%s: only JSON files can be migrated; make the changes listed and set config_version to %d\n", path, configVersion)
+		return 1
+	}
+
+	upgraded, err := json.MarshalIndent(root, "", "  ")
+	if err == nil {
+		upgraded = append(upgraded, '\n')
+		_, err = getConfiguration(bytes.NewReader(upgraded))
+	}
+	if err == nil {
+		err = writeFileAtomic(path, upgraded)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %s\n", path, err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "%s: migrated to version %d; the original is at %s\n", path, configVersion, path+backupSuffix)
+	return 0
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckConfigCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heihei")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	valid := filepath.Join(dir, "valid.json")
+	invalid := filepath.Join(dir, "invalid.json")
+	if err = ioutil.WriteFile(valid, []byte(validConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(invalid, []byte(`{"location":[1, 2], "light_out":"22:00"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		args   []string
+		status int
+		output string
+	}{
+		{[]string{"config", "check", valid}, 0, valid + ": OK"},
+		{[]string{"config", "check", invalid}, 1, invalid + ": line 1, column 21: Unknown key 'light_out'"},
+		{[]string{"config", "check", filepath.Join(dir, "missing.json")}, 1, "open "},
+		{[]string{"config", "check"}, 2, commandUsage},
+		{[]string{"serve"}, 2, commandUsage},
+	}
+	for _, tc := range testCases {
+		var stdout, stderr bytes.Buffer
+		status := runCommand(tc.args, &stdout, &stderr)
+		output := stdout.String() + stderr.String()
+		if status != tc.status || !strings.HasPrefix(output, tc.output) {
+			t.Errorf("got %d %q for %v; expected %d %q", status, output, tc.args, tc.status, tc.output)
+		}
+	}
+}
@@ -1,17 +1,100 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"reflect"
 	"regexp"
 	"strconv"
+	"strings"
+	"time"
+
+	"periph.io/x/periph/conn/gpio"
 )
 
 type configuration struct {
-	location    [2]float64 // the [latitude, longitude] of the device
-	lightsOut   string
-	logToStdout bool
+	location      [2]float64 // the [latitude, longitude] of the device
+	lightsOut     string
+	logToStdout   bool              // logging.output is stdout rather than the log file
+	tls           *tlsConfiguration // nil if HTTPS is disabled
+	listen        []listenAddress
+	adminSocket   string // path of the Unix domain socket serving admin endpoints
+	plugs         []plugConfiguration
+	logRotation   rotationConfiguration
+	logging       loggingConfiguration
+	accessLog     accessLogConfiguration
+	thermal       thermalConfiguration
+	buttons       []buttonConfiguration
+	motionSensors []motionConfiguration
+	light         *lightConfiguration       // nil if there is no light sensor
+	thermostat    *thermostatConfiguration  // nil if there is no thermostat
+	statusLED     *statusLEDConfiguration   // nil if there is no status LED
+	display       *displayConfiguration     // nil if there is no status display
+	receiver      *receiverConfiguration    // nil if there is no 433MHz receiver
+	transmitter   *transmitterConfiguration // nil if there is no transmitter for learned codes
+	devices       []deviceConfiguration
+	sensors       []sensorConfiguration
+	sensorHistory sensorHistoryConfiguration
+	migrated      []string // descriptions of the migrations applied when the configuration was read
+}
+
+// plugConfiguration describes a named plug
+type plugConfiguration struct {
+	name string
+	id   plugID
+}
+
+// defaultPlugs are used when the configuration doesn't list any plugs
+var defaultPlugs = []plugConfiguration{{name: "light", id: plugOne}}
+
+var namePattern = regexp.MustCompile("^[a-z0-9_-]+$")
+
+// checkName returns an error if name isn't a valid name for a kind, such as Plug, or is already in use
+// otherwise name is added to used
+func checkName(kind, name string, used map[string]bool) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("%s name '%s' should only contain lower case letters, digits, '-' and '_'", kind, name)
+	}
+	if used[name] {
+		return fmt.Errorf("%s name '%s' is used more than once", kind, name)
+	}
+	used[name] = true
+	return nil
+}
+
+// hasPlug returns true if plugs includes a plug with the given name
+func hasPlug(plugs []plugConfiguration, name string) bool {
+	for _, p := range plugs {
+		if p.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTarget returns celsius if it is a reasonable thermostat target
+func checkTarget(celsius float64) (float64, error) {
+	if celsius < minTargetCelsius || celsius > maxTargetCelsius {
+		return 0, fmt.Errorf("Thermostat target_celsius should be between %d and %d; not %v", minTargetCelsius, maxTargetCelsius, celsius)
+	}
+	return celsius, nil
+}
+
+// parsePull converts the name of a pull resistor setting
+func parsePull(s string) (gpio.Pull, error) {
+	switch s {
+	case "up":
+		return gpio.PullUp, nil
+	case "down":
+		return gpio.PullDown, nil
+	case "none":
+		return gpio.Float, nil
+	}
+	return gpio.PullNoChange, fmt.Errorf("pull should be up, down or none; not '%s'", s)
 }
 
 // latLong returns the latitude and longitude of the device
@@ -19,32 +102,212 @@ func (c configuration) latLong() (float64, float64) {
 	return c.location[0], c.location[1]
 }
 
+// configFile is the layout of the configuration file; pointers are used for required values
+type configFile struct {
+	ConfigVersion int        `json:"config_version"`
+	Location      *[]float64 `json:"location"`
+	LightsOut     *string    `json:"lights_out"`
+	TLS           *struct {
+		CertFile     string `json:"cert_file"`
+		KeyFile      string `json:"key_file"`
+		ClientCAFile string `json:"client_ca_file"`
+	} `json:"tls"`
+	Listen      []string `json:"listen"`
+	AdminSocket string   `json:"admin_socket"`
+	Plugs       []struct {
+		Name   string `json:"name"`
+		Socket int    `json:"socket"`
+	} `json:"plugs"`
+	LogRotation *struct {
+		MaxSizeMB   *int `json:"max_size_mb"`
+		MaxAgeHours int  `json:"max_age_hours"`
+		Compress    bool `json:"compress"`
+		Keep        *int `json:"keep"`
+	} `json:"log_rotation"`
+	Logging *struct {
+		Output string            `json:"output"`
+		Format string            `json:"format"`
+		Level  string            `json:"level"`
+		Levels map[string]string `json:"levels"`
+	} `json:"logging"`
+	AccessLog *struct {
+		Path   string `json:"path"`
+		Format string `json:"format"`
+	} `json:"access_log"`
+	Thermal *struct {
+		IntervalSeconds   *int     `json:"interval_seconds"`
+		ThresholdCelsius  float64  `json:"threshold_celsius"`
+		HysteresisCelsius *float64 `json:"hysteresis_celsius"`
+	} `json:"thermal"`
+	Buttons []struct {
+		Name            string `json:"name"`
+		Pin             string `json:"pin"`
+		Pull            string `json:"pull"`
+		ActiveLow       *bool  `json:"active_low"`
+		DebounceMS      *int   `json:"debounce_ms"`
+		Action          string `json:"action"`
+		Plug            string `json:"plug"`
+		LongPressMS     *int   `json:"long_press_ms"`
+		LongPressAction string `json:"long_press_action"`
+	} `json:"buttons"`
+	MotionSensors []struct {
+		Name      string `json:"name"`
+		Pin       string `json:"pin"`
+		Pull      string `json:"pull"`
+		ActiveLow bool   `json:"active_low"`
+		Rules     []struct {
+			Plug            string `json:"plug"`
+			DurationSeconds int    `json:"duration_seconds"`
+			After           string `json:"after"`
+			Before          string `json:"before"`
+		} `json:"rules"`
+	} `json:"motion_sensors"`
+	LightSensor *struct {
+		Bus             string `json:"bus"`
+		Address         *int   `json:"address"`
+		IntervalSeconds *int   `json:"interval_seconds"`
+		Rules           []struct {
+			Plug            string   `json:"plug"`
+			BelowLux        *float64 `json:"below_lux"`
+			HysteresisLux   *float64 `json:"hysteresis_lux"`
+			MinDwellSeconds *int     `json:"min_dwell_seconds"`
+			After           string   `json:"after"`
+			Before          string   `json:"before"`
+		} `json:"rules"`
+	} `json:"light_sensor"`
+	Thermostat *struct {
+		Plug              string   `json:"plug"`
+		Probe             string   `json:"probe"`
+		TargetCelsius     *float64 `json:"target_celsius"`
+		HysteresisCelsius *float64 `json:"hysteresis_celsius"`
+		MinOnSeconds      *int     `json:"min_on_seconds"`
+		MinOffSeconds     *int     `json:"min_off_seconds"`
+		MaxRunMinutes     *int     `json:"max_run_minutes"`
+		IntervalSeconds   *int     `json:"interval_seconds"`
+		Setpoints         []struct {
+			At            string   `json:"at"`
+			TargetCelsius *float64 `json:"target_celsius"`
+		} `json:"setpoints"`
+	} `json:"thermostat"`
+	Sensors []struct {
+		Name            string `json:"name"`
+		Type            string `json:"type"`
+		Bus             string `json:"bus"`
+		Address         *int   `json:"address"`
+		Probe           string `json:"probe"`
+		IntervalSeconds *int   `json:"interval_seconds"`
+	} `json:"sensors"`
+	SensorHistory *struct {
+		Samples *int   `json:"samples"`
+		Path    string `json:"path"`
+	} `json:"sensor_history"`
+	StatusLED *struct {
+		LED       string `json:"led"`
+		Pin       string `json:"pin"`
+		ActiveLow bool   `json:"active_low"`
+		Heartbeat *bool  `json:"heartbeat"`
+		Errors    *bool  `json:"errors"`
+		Transmit  *bool  `json:"transmit"`
+		Alarm     *bool  `json:"alarm"`
+	} `json:"status_led"`
+	Display *struct {
+		Bus             string `json:"bus"`
+		Address         *int   `json:"address"`
+		IntervalSeconds *int   `json:"interval_seconds"`
+	} `json:"display"`
+	Receiver *struct {
+		Pin       string `json:"pin"`
+		Pull      string `json:"pull"`
+		Addresses []int  `json:"addresses"`
+	} `json:"receiver"`
+	Transmitter *struct {
+		Pin     string `json:"pin"`
+		Repeats *int   `json:"repeats"`
+	} `json:"transmitter"`
+	Devices []struct {
+		Name string `json:"name"`
+		On   []int  `json:"on"`
+		Off  []int  `json:"off"`
+	} `json:"devices"`
+}
+
+// isConfigKey returns true if key is a top level key of the configuration file, or of an earlier version that is migrated
+func isConfigKey(key string) bool {
+	t := reflect.TypeOf(configFile{})
+	for i := 0; i < t.NumField(); i++ {
+		if strings.Split(t.Field(i).Tag.Get("json"), ",")[0] == key {
+			return true
+		}
+	}
+	for _, m := range configMigrations {
+		for _, k := range m.keys {
+			if k == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // getConfiguration extracts the server configuration
+// unknown keys are rejected and errors report the line and column of the problem where it can be found
 func getConfiguration(file io.Reader) (config configuration, err error) {
-
-	// use pointers for required values
-	ptrConfig := struct {
-		Location    *[]float64 `json:"location"`
-		LightsOut   *string    `json:"lights_out"`
-		LogToStdout bool       `json:"log_to_stdout"`
-	}{}
-	// decode json
-	decoder := json.NewDecoder(file)
-	err = decoder.Decode(&ptrConfig)
+	data, err := ioutil.ReadAll(file)
 	if err != nil {
 		return
 	}
 
-	// check that a location has been supplied and that it has a length of 2
+	// upgrade configuration written for earlier versions; positions in the upgraded input would be misleading
+	if data, config.migrated, err = migrateConfiguration(data); err != nil {
+		return
+	}
+	if len(config.migrated) > 0 {
+		defer func() { err = withoutPosition(err) }()
+	}
+
+	// key is the top level key being checked so that a failed check can be located in the input
+	var key string
+	defer func() {
+		if err != nil && key != "" {
+			err = positionError(data, keyOffset(data, key), err)
+		}
+	}()
+
+	var ptrConfig configFile
+	// decode json, allowing nothing but white space after the configuration
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&ptrConfig); err != nil {
+		err = decodeError(data, err)
+		return
+	}
+	offset := decoder.InputOffset()
+	if _, tokenErr := decoder.Token(); tokenErr != io.EOF {
+		for offset < int64(len(data)) && strings.ContainsRune(" \t\r\n", rune(data[offset])) {
+			offset++
+		}
+		err = positionError(data, offset, errors.New("Unexpected data after the configuration"))
+		return
+	}
+
+	// check that a location has been supplied, that it has a length of 2 and that the coordinates are in range
+	key = "location"
 	if ptrConfig.Location == nil {
 		err = fmt.Errorf("Location is missing from configuration")
 		return
 	} else if len(*ptrConfig.Location) != 2 {
 		err = fmt.Errorf("Location should contain 2 elements; not %d", len(*ptrConfig.Location))
 		return
+	} else if lat := (*ptrConfig.Location)[0]; lat < -90 || lat > 90 {
+		err = fmt.Errorf("Location latitude should be between -90 and 90; not %v", lat)
+		return
+	} else if lon := (*ptrConfig.Location)[1]; lon < -180 || lon > 180 {
+		err = fmt.Errorf("Location longitude should be between -180 and 180; not %v", lon)
+		return
 	}
 
 	// check that a lights out value has been supplied and that it has a valid syntax
+	key = "lights_out"
 	if ptrConfig.LightsOut == nil {
 		err = fmt.Errorf("Lights out is missing from configuration")
 		return
@@ -53,13 +316,726 @@ func getConfiguration(file io.Reader) (config configuration, err error) {
 		return
 	}
 
+	// check that the certificate and key are supplied together
+	key = "tls"
+	if t := ptrConfig.TLS; t != nil && (t.CertFile == "") != (t.KeyFile == "") {
+		err = fmt.Errorf("TLS cert_file and key_file should both be supplied or both be omitted")
+		return
+	}
+
+	// check the syntax of the listen addresses
+	key = "listen"
+	for _, l := range ptrConfig.Listen {
+		var a listenAddress
+		if a, err = parseListenAddress(l); err != nil {
+			return
+		}
+		config.listen = append(config.listen, a)
+	}
+
+	// check that each plug has a unique name and a valid socket number
+	key = "plugs"
+	names := make(map[string]bool)
+	ids := make(map[plugID]bool)
+	for _, p := range ptrConfig.Plugs {
+		if err = checkName("Plug", p.Name, names); err != nil {
+			return
+		}
+
+		var id plugID
+		switch p.Socket {
+		case 1:
+			id = plugOne
+		case 2:
+			id = plugTwo
+		default:
+			err = fmt.Errorf("Plug '%s' socket should be 1 or 2; not %d", p.Name, p.Socket)
+			return
+		}
+		if ids[id] {
+			err = fmt.Errorf("Plug '%s' socket %d is used by another plug", p.Name, p.Socket)
+			return
+		}
+		ids[id] = true
+		config.plugs = append(config.plugs, plugConfiguration{name: p.Name, id: id})
+	}
+	if len(config.plugs) == 0 {
+		config.plugs = defaultPlugs
+	}
+
+	// check that the log rotation values aren't negative; omitted values take their defaults
+	key = "log_rotation"
+	config.logRotation = defaultRotation
+	if lr := ptrConfig.LogRotation; lr != nil {
+		if lr.MaxSizeMB != nil {
+			if *lr.MaxSizeMB < 0 {
+				err = fmt.Errorf("Log rotation max_size_mb should not be negative; not %d", *lr.MaxSizeMB)
+				return
+			}
+			config.logRotation.maxSize = int64(*lr.MaxSizeMB) * 1024 * 1024
+		}
+		if lr.MaxAgeHours < 0 {
+			err = fmt.Errorf("Log rotation max_age_hours should not be negative; not %d", lr.MaxAgeHours)
+			return
+		}
+		config.logRotation.maxAge = time.Duration(lr.MaxAgeHours) * time.Hour
+		config.logRotation.compress = lr.Compress
+		if lr.Keep != nil {
+			if *lr.Keep < 0 {
+				err = fmt.Errorf("Log rotation keep should not be negative; not %d", *lr.Keep)
+				return
+			}
+			config.logRotation.keep = *lr.Keep
+		}
+	}
+
+	// check the log format and levels
+	key = "logging"
+	config.logging = defaultLogging
+	if l := ptrConfig.Logging; l != nil {
+		switch l.Output {
+		case "", logOutputFile:
+		case logOutputStdout:
+			config.logToStdout = true
+		default:
+			err = fmt.Errorf("Logging output should be %s or %s; not '%s'", logOutputFile, logOutputStdout, l.Output)
+			return
+		}
+		switch l.Format {
+		case "":
+		case logFormatText, logFormatJSON:
+			config.logging.format = l.Format
+		default:
+			err = fmt.Errorf("Logging format should be %s or %s; not '%s'", logFormatText, logFormatJSON, l.Format)
+			return
+		}
+		if l.Level != "" {
+			if config.logging.level, err = parseLogLevel(l.Level); err != nil {
+				return
+			}
+		}
+		if len(l.Levels) > 0 {
+			config.logging.levels = make(map[string]logLevel)
+			for subsystem, name := range l.Levels {
+				if config.logging.levels[subsystem], err = parseLogLevel(name); err != nil {
+					return
+				}
+			}
+		}
+	}
+
+	// check the access log format; the access log is only written when configured
+	key = "access_log"
+	if a := ptrConfig.AccessLog; a != nil {
+		config.accessLog = accessLogConfiguration{path: a.Path, format: a.Format}
+		if config.accessLog.path == "" {
+			config.accessLog.path = accessLogFilename
+		}
+		switch config.accessLog.format {
+		case "":
+			config.accessLog.format = accessFormatCombined
+		case accessFormatCommon, accessFormatCombined:
+		default:
+			err = fmt.Errorf("Access log format should be %s or %s; not '%s'", accessFormatCommon, accessFormatCombined, a.Format)
+			return
+		}
+	}
+
+	// check the thermal sampling interval and alert threshold; omitted values take their defaults
+	key = "thermal"
+	config.thermal = defaultThermal
+	if t := ptrConfig.Thermal; t != nil {
+		if t.IntervalSeconds != nil {
+			if *t.IntervalSeconds < 1 {
+				err = fmt.Errorf("Thermal interval_seconds should be at least 1; not %d", *t.IntervalSeconds)
+				return
+			}
+			config.thermal.interval = time.Duration(*t.IntervalSeconds) * time.Second
+		}
+		if t.ThresholdCelsius < 0 {
+			err = fmt.Errorf("Thermal threshold_celsius should not be negative; not %v", t.ThresholdCelsius)
+			return
+		}
+		config.thermal.threshold = t.ThresholdCelsius
+		if t.HysteresisCelsius != nil {
+			if *t.HysteresisCelsius < 0 {
+				err = fmt.Errorf("Thermal hysteresis_celsius should not be negative; not %v", *t.HysteresisCelsius)
+				return
+			}
+			config.thermal.hysteresis = *t.HysteresisCelsius
+		}
+	}
+
+	// check that each button has its own pin and that its actions and plug are known
+	key = "buttons"
+	pins := newPinUsers()
+	buttonNames := make(map[string]bool)
+	for _, b := range ptrConfig.Buttons {
+		if b.Pin == "" {
+			err = fmt.Errorf("Button pin is missing from configuration")
+			return
+		}
+		if err = pins.claim(b.Pin, "Button", "a button"); err != nil {
+			return
+		}
+		button := buttonConfiguration{name: b.Name, pin: b.Pin, debounce: defaultDebounce, action: b.Action,
+			plug: b.Plug, longPress: defaultLongPress, longAction: b.LongPressAction}
+		if button.name == "" {
+			button.name = strings.ToLower(b.Pin)
+		}
+		if err = checkName("Button", button.name, buttonNames); err != nil {
+			return
+		}
+
+		if b.Pull == "" {
+			b.Pull = "up"
+		}
+		if button.pull, err = parsePull(b.Pull); err != nil {
+			err = fmt.Errorf("Button '%s' %s", button.name, err)
+			return
+		}
+		// a pulled up pin is grounded by its button
+		button.activeLow = button.pull != gpio.PullDown
+		if b.ActiveLow != nil {
+			button.activeLow = *b.ActiveLow
+		}
+		if b.DebounceMS != nil {
+			if *b.DebounceMS < 0 || *b.DebounceMS > 1000 {
+				err = fmt.Errorf("Button '%s' debounce_ms should be between 0 and 1000; not %d", button.name, *b.DebounceMS)
+				return
+			}
+			button.debounce = time.Duration(*b.DebounceMS) * time.Millisecond
+		}
+
+		if button.action == "" {
+			button.action = buttonToggle
+		}
+		for _, action := range []string{button.action, button.longAction} {
+			if action != "" && !isButtonAction(action) {
+				err = fmt.Errorf("Button '%s' action should be %s, %s, %s, %s or %s; not '%s'", button.name,
+					buttonToggle, buttonOn, buttonOff, buttonAllOn, buttonAllOff, action)
+				return
+			}
+			if action == buttonToggle || action == buttonOn || action == buttonOff {
+				if button.plug == "" {
+					err = fmt.Errorf("Button '%s' plug is missing for action %s", button.name, action)
+					return
+				}
+				if !hasPlug(config.plugs, button.plug) {
+					err = fmt.Errorf("Button '%s' plug '%s' is not configured", button.name, button.plug)
+					return
+				}
+			}
+		}
+		if b.LongPressMS != nil {
+			if button.longAction == "" {
+				err = fmt.Errorf("Button '%s' long_press_ms is set without a long_press_action", button.name)
+				return
+			}
+			if d := time.Duration(*b.LongPressMS) * time.Millisecond; d <= button.debounce {
+				err = fmt.Errorf("Button '%s' long_press_ms should be longer than the debounce period; not %d", button.name, *b.LongPressMS)
+				return
+			}
+			button.longPress = time.Duration(*b.LongPressMS) * time.Millisecond
+		}
+		config.buttons = append(config.buttons, button)
+	}
+
+	// check that each motion sensor has its own pin and that its rules name plugs and valid periods
+	key = "motion_sensors"
+	motionNames := make(map[string]bool)
+	for _, s := range ptrConfig.MotionSensors {
+		if s.Pin == "" {
+			err = fmt.Errorf("Motion sensor pin is missing from configuration")
+			return
+		}
+		if err = pins.claim(s.Pin, "Motion sensor", "a motion sensor"); err != nil {
+			return
+		}
+		sensor := motionConfiguration{name: s.Name, pin: s.Pin, activeLow: s.ActiveLow}
+		if sensor.name == "" {
+			sensor.name = strings.ToLower(s.Pin)
+		}
+		if err = checkName("Motion sensor", sensor.name, motionNames); err != nil {
+			return
+		}
+		// a PIR output is driven high on motion so the pull only holds it low while the sensor starts
+		if s.Pull == "" {
+			s.Pull = "down"
+		}
+		if sensor.pull, err = parsePull(s.Pull); err != nil {
+			err = fmt.Errorf("Motion sensor '%s' %s", sensor.name, err)
+			return
+		}
+
+		for _, r := range s.Rules {
+			if !hasPlug(config.plugs, r.Plug) {
+				err = fmt.Errorf("Motion sensor '%s' plug '%s' is not configured", sensor.name, r.Plug)
+				return
+			}
+			if r.DurationSeconds < 1 {
+				err = fmt.Errorf("Motion sensor '%s' duration_seconds should be at least 1; not %d", sensor.name, r.DurationSeconds)
+				return
+			}
+			for _, boundary := range []string{r.After, r.Before} {
+				if boundary != "" && !isPeriodBoundary(boundary) {
+					err = fmt.Errorf("Motion sensor '%s' after and before should be %s, %s, %s or a clock time; not '%s'",
+						sensor.name, boundarySunset, boundarySunrise, boundaryLightsOut, boundary)
+					return
+				}
+			}
+			sensor.rules = append(sensor.rules, motionRule{plug: r.Plug, duration: time.Duration(r.DurationSeconds) * time.Second,
+				period: period{after: r.After, before: r.Before}})
+		}
+		config.motionSensors = append(config.motionSensors, sensor)
+	}
+
+	// check that the receiver has a pin of its own and the addresses of the remotes to mirror
+	key = "receiver"
+	if r := ptrConfig.Receiver; r != nil {
+		if r.Pin == "" {
+			err = fmt.Errorf("Receiver pin is missing from configuration")
+			return
+		}
+		if err = pins.claim(r.Pin, "Receiver", "the receiver"); err != nil {
+			return
+		}
+		config.receiver = &receiverConfiguration{pin: r.Pin}
+		// a receiver module drives its output
+		if r.Pull == "" {
+			r.Pull = "none"
+		}
+		if config.receiver.pull, err = parsePull(r.Pull); err != nil {
+			err = fmt.Errorf("Receiver %s", err)
+			return
+		}
+		if len(r.Addresses) == 0 {
+			err = fmt.Errorf("Receiver addresses are missing; the address of each remote is logged when it is pressed")
+			return
+		}
+		for _, a := range r.Addresses {
+			if a < 0 || a > maxRemoteAddress {
+				err = fmt.Errorf("Receiver address should be between 0 and %d; not %d", maxRemoteAddress, a)
+				return
+			}
+			config.receiver.addresses = append(config.receiver.addresses, uint32(a))
+		}
+	}
+
+	// check that the transmitter has a pin of its own
+	key = "transmitter"
+	if t := ptrConfig.Transmitter; t != nil {
+		if t.Pin == "" {
+			err = fmt.Errorf("Transmitter pin is missing from configuration")
+			return
+		}
+		if err = pins.claim(t.Pin, "Transmitter", "the transmitter"); err != nil {
+			return
+		}
+		config.transmitter = &transmitterConfiguration{pin: t.Pin, repeats: defaultTransmitRepeats}
+		if t.Repeats != nil {
+			if *t.Repeats < 1 {
+				err = fmt.Errorf("Transmitter repeats should be at least 1; not %d", *t.Repeats)
+				return
+			}
+			config.transmitter.repeats = *t.Repeats
+		}
+	}
+
+	// check that each device has a unique name that isn't used by a plug and that its learned codes are frames
+	key = "devices"
+	if len(ptrConfig.Devices) > 0 && config.transmitter == nil {
+		err = fmt.Errorf("Devices need a transmitter to replay their codes")
+		return
+	}
+	deviceNames := make(map[string]bool)
+	for _, d := range ptrConfig.Devices {
+		if err = checkName("Device", d.Name, deviceNames); err != nil {
+			return
+		} else if hasPlug(config.plugs, d.Name) {
+			err = fmt.Errorf("Device name '%s' is used by a plug", d.Name)
+			return
+		}
+		device := deviceConfiguration{name: d.Name}
+		for _, code := range []struct {
+			state  string
+			pulses []int
+			frame  *ookFrame
+		}{
+			{"on", d.On, &device.on},
+			{"off", d.Off, &device.off},
+		} {
+			if len(code.pulses) == 0 {
+				continue
+			}
+			if len(code.pulses) < ookMinPulses || len(code.pulses) > ookMaxPulses || len(code.pulses)%2 != 0 {
+				err = fmt.Errorf("Device '%s' %s should be an even number of pulses from %d to %d; not %d",
+					d.Name, code.state, ookMinPulses, ookMaxPulses, len(code.pulses))
+				return
+			}
+			for _, us := range code.pulses {
+				p := time.Duration(us) * time.Microsecond
+				if p <= 0 || p > maxPulse {
+					err = fmt.Errorf("Device '%s' %s pulses should be from 1 to %d microseconds; not %d",
+						d.Name, code.state, maxPulse/time.Microsecond, us)
+					return
+				}
+				*code.frame = append(*code.frame, p)
+			}
+		}
+		config.devices = append(config.devices, device)
+	}
+
+	// check that the status LED is either an on-board LED or a pin of its own; omitted patterns are shown
+	key = "status_led"
+	if led := ptrConfig.StatusLED; led != nil {
+		if (led.LED == "") == (led.Pin == "") {
+			err = fmt.Errorf("Status LED should have either an led or a pin")
+			return
+		}
+		if led.Pin != "" {
+			if err = pins.claim(led.Pin, "Status LED", "the status LED"); err != nil {
+				return
+			}
+		}
+		config.statusLED = &statusLEDConfiguration{led: led.LED, pin: led.Pin, activeLow: led.ActiveLow,
+			heartbeat: true, errors: true, transmit: true, alarm: true}
+		for _, pattern := range []struct {
+			set  *bool
+			show *bool
+		}{
+			{led.Heartbeat, &config.statusLED.heartbeat},
+			{led.Errors, &config.statusLED.errors},
+			{led.Transmit, &config.statusLED.transmit},
+			{led.Alarm, &config.statusLED.alarm},
+		} {
+			if pattern.set != nil {
+				*pattern.show = *pattern.set
+			}
+		}
+	}
+
+	// check the display address and refresh interval
+	key = "display"
+	if d := ptrConfig.Display; d != nil {
+		config.display = &displayConfiguration{bus: d.Bus, address: ssd1306AddressLow, interval: defaultDisplayInterval}
+		if d.Address != nil {
+			if *d.Address != ssd1306AddressLow && *d.Address != ssd1306AddressHigh {
+				err = fmt.Errorf("Display address should be %d or %d; not %d", ssd1306AddressLow, ssd1306AddressHigh, *d.Address)
+				return
+			}
+			config.display.address = uint16(*d.Address)
+		}
+		if d.IntervalSeconds != nil {
+			if *d.IntervalSeconds < 1 {
+				err = fmt.Errorf("Display interval_seconds should be at least 1; not %d", *d.IntervalSeconds)
+				return
+			}
+			config.display.interval = time.Duration(*d.IntervalSeconds) * time.Second
+		}
+	}
+
+	// check the light sensor address and that its rules name plugs with valid thresholds and periods
+	key = "light_sensor"
+	if ls := ptrConfig.LightSensor; ls != nil {
+		config.light = &lightConfiguration{bus: ls.Bus, address: bh1750AddressLow, interval: defaultLightInterval}
+		if ls.Address != nil {
+			if *ls.Address != bh1750AddressLow && *ls.Address != bh1750AddressHigh {
+				err = fmt.Errorf("Light sensor address should be %d or %d; not %d", bh1750AddressLow, bh1750AddressHigh, *ls.Address)
+				return
+			}
+			config.light.address = uint16(*ls.Address)
+		}
+		if ls.IntervalSeconds != nil {
+			if *ls.IntervalSeconds < 1 {
+				err = fmt.Errorf("Light sensor interval_seconds should be at least 1; not %d", *ls.IntervalSeconds)
+				return
+			}
+			config.light.interval = time.Duration(*ls.IntervalSeconds) * time.Second
+		}
+		for _, r := range ls.Rules {
+			rule := luxRule{plug: r.Plug, hysteresis: defaultLuxHysteresis, dwell: defaultLuxDwell,
+				period: period{after: r.After, before: r.Before}}
+			if !hasPlug(config.plugs, r.Plug) {
+				err = fmt.Errorf("Light sensor plug '%s' is not configured", r.Plug)
+				return
+			}
+			if r.BelowLux == nil || *r.BelowLux <= 0 {
+				err = fmt.Errorf("Light sensor rule for '%s' below_lux should be more than 0", r.Plug)
+				return
+			}
+			rule.below = *r.BelowLux
+			if r.HysteresisLux != nil {
+				if *r.HysteresisLux < 0 {
+					err = fmt.Errorf("Light sensor rule for '%s' hysteresis_lux should not be negative; not %v", r.Plug, *r.HysteresisLux)
+					return
+				}
+				rule.hysteresis = *r.HysteresisLux
+			}
+			if r.MinDwellSeconds != nil {
+				if *r.MinDwellSeconds < 0 {
+					err = fmt.Errorf("Light sensor rule for '%s' min_dwell_seconds should not be negative; not %d", r.Plug, *r.MinDwellSeconds)
+					return
+				}
+				rule.dwell = time.Duration(*r.MinDwellSeconds) * time.Second
+			}
+			for _, boundary := range []string{r.After, r.Before} {
+				if boundary != "" && !isPeriodBoundary(boundary) {
+					err = fmt.Errorf("Light sensor rule for '%s' after and before should be %s, %s, %s or a clock time; not '%s'",
+						r.Plug, boundarySunset, boundarySunrise, boundaryLightsOut, boundary)
+					return
+				}
+			}
+			config.light.rules = append(config.light.rules, rule)
+		}
+	}
+
+	// check that the thermostat has a plug of its own and that its temperatures and times are in range
+	key = "thermostat"
+	if th := ptrConfig.Thermostat; th != nil {
+		c := &thermostatConfiguration{plug: th.Plug, probe: th.Probe, hysteresis: defaultThermostatHysteresis,
+			minOn: defaultMinCycle, minOff: defaultMinCycle, maxRun: defaultMaxRun, interval: defaultThermostatInterval}
+		if !hasPlug(config.plugs, th.Plug) {
+			err = fmt.Errorf("Thermostat plug '%s' is not configured", th.Plug)
+			return
+		}
+		// rules switching the plug would fight the thermostat's cycling and cut off
+		for _, s := range config.motionSensors {
+			for _, r := range s.rules {
+				if r.plug == th.Plug {
+					err = fmt.Errorf("Thermostat plug '%s' should not also be switched by motion sensor '%s'", th.Plug, s.name)
+					return
+				}
+			}
+		}
+		if config.light != nil {
+			for _, r := range config.light.rules {
+				if r.plug == th.Plug {
+					err = fmt.Errorf("Thermostat plug '%s' should not also be switched by the light sensor", th.Plug)
+					return
+				}
+			}
+		}
+		if th.TargetCelsius == nil {
+			err = fmt.Errorf("Thermostat target_celsius is missing from configuration")
+			return
+		}
+		if c.target, err = checkTarget(*th.TargetCelsius); err != nil {
+			return
+		}
+		if th.HysteresisCelsius != nil {
+			if *th.HysteresisCelsius < 0 {
+				err = fmt.Errorf("Thermostat hysteresis_celsius should not be negative; not %v", *th.HysteresisCelsius)
+				return
+			}
+			c.hysteresis = *th.HysteresisCelsius
+		}
+		for _, d := range []struct {
+			name  string
+			value *int
+			unit  time.Duration
+			min   int
+			set   *time.Duration
+		}{
+			{"min_on_seconds", th.MinOnSeconds, time.Second, 0, &c.minOn},
+			{"min_off_seconds", th.MinOffSeconds, time.Second, 0, &c.minOff},
+			{"max_run_minutes", th.MaxRunMinutes, time.Minute, 0, &c.maxRun},
+			{"interval_seconds", th.IntervalSeconds, time.Second, 1, &c.interval},
+		} {
+			if d.value == nil {
+				continue
+			}
+			if *d.value < d.min {
+				err = fmt.Errorf("Thermostat %s should be at least %d; not %d", d.name, d.min, *d.value)
+				return
+			}
+			*d.set = time.Duration(*d.value) * d.unit
+		}
+		if c.maxRun > 0 && c.maxRun <= c.minOn {
+			err = fmt.Errorf("Thermostat max_run_minutes should be longer than min_on_seconds")
+			return
+		}
+		for _, s := range th.Setpoints {
+			if !isPeriodBoundary(s.At) {
+				err = fmt.Errorf("Thermostat setpoint at should be %s, %s, %s or a clock time; not '%s'",
+					boundarySunset, boundarySunrise, boundaryLightsOut, s.At)
+				return
+			}
+			if s.TargetCelsius == nil {
+				err = fmt.Errorf("Thermostat setpoint at %s target_celsius is missing", s.At)
+				return
+			}
+			sp := setpoint{at: s.At}
+			if sp.target, err = checkTarget(*s.TargetCelsius); err != nil {
+				return
+			}
+			c.setpoints = append(c.setpoints, sp)
+		}
+		config.thermostat = c
+	}
+
+	// check that each sensor has a unique name, a known type and an address that its type can have
+	key = "sensors"
+	sensorNames := make(map[string]bool)
+	for _, s := range ptrConfig.Sensors {
+		if err = checkName("Sensor", s.Name, sensorNames); err != nil {
+			return
+		}
+		c := sensorConfiguration{name: s.Name, kind: s.Type, bus: s.Bus, probe: s.Probe, interval: defaultSensorInterval}
+
+		var addresses []int
+		switch s.Type {
+		case sensorBME280:
+			addresses = []int{bme280AddressLow, bme280AddressHigh}
+		case sensorBH1750:
+			addresses = []int{bh1750AddressLow, bh1750AddressHigh}
+		case sensorDS18B20:
+			if s.Bus != "" || s.Address != nil {
+				err = fmt.Errorf("Sensor '%s' is on the 1-Wire bus so it has a probe rather than a bus and address", s.Name)
+				return
+			}
+		default:
+			err = fmt.Errorf("Sensor '%s' type should be %s, %s or %s; not '%s'", s.Name, sensorBME280, sensorBH1750, sensorDS18B20, s.Type)
+			return
+		}
+		if len(addresses) > 0 {
+			if s.Probe != "" {
+				err = fmt.Errorf("Sensor '%s' is on the I2C bus so it has an address rather than a probe", s.Name)
+				return
+			}
+			c.address = uint16(addresses[0])
+			if s.Address != nil {
+				if *s.Address != addresses[0] && *s.Address != addresses[1] {
+					err = fmt.Errorf("Sensor '%s' address should be %d or %d; not %d", s.Name, addresses[0], addresses[1], *s.Address)
+					return
+				}
+				c.address = uint16(*s.Address)
+			}
+		}
+		if s.IntervalSeconds != nil {
+			if *s.IntervalSeconds < 1 {
+				err = fmt.Errorf("Sensor '%s' interval_seconds should be at least 1; not %d", s.Name, *s.IntervalSeconds)
+				return
+			}
+			c.interval = time.Duration(*s.IntervalSeconds) * time.Second
+		}
+		config.sensors = append(config.sensors, c)
+	}
+
+	// check the number of readings kept in memory; omitted values take their defaults
+	key = "sensor_history"
+	config.sensorHistory = defaultSensorHistory
+	if h := ptrConfig.SensorHistory; h != nil {
+		if h.Samples != nil {
+			if *h.Samples < 1 {
+				err = fmt.Errorf("Sensor history samples should be at least 1; not %d", *h.Samples)
+				return
+			}
+			config.sensorHistory.samples = *h.Samples
+		}
+		if h.Path != "" {
+			config.sensorHistory.path = h.Path
+		}
+	}
+
+	key = ""
 	copy(config.location[:], (*ptrConfig.Location)[0:2])
 	config.lightsOut = *ptrConfig.LightsOut
-	config.logToStdout = ptrConfig.LogToStdout
+	if t := ptrConfig.TLS; t != nil {
+		config.tls = &tlsConfiguration{
+			certFile:     t.CertFile,
+			keyFile:      t.KeyFile,
+			clientCAFile: t.ClientCAFile,
+		}
+	}
+	config.adminSocket = ptrConfig.AdminSocket
+
+	// check that plain HTTP isn't served when client certificates are required
+	key = "listen"
+	if err = config.checkListen(); err != nil {
+		return
+	}
+	key = ""
+
+	return
+}
 
+// lineColumn returns the 1-based line and column of the byte at offset in data
+func lineColumn(data []byte, offset int64) (line, column int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	column = int(offset) - bytes.LastIndexByte(before, '\n')
 	return
 }
 
+// positionedError is an error at a line and column of a configuration file
+type positionedError struct {
+	line, column int
+	err          error
+}
+
+func (e *positionedError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.line, e.column, e.err)
+}
+
+// positionError adds the line and column of offset in data to err; a negative offset leaves err unchanged
+func positionError(data []byte, offset int64, err error) error {
+	if offset < 0 {
+		return err
+	}
+	line, column := lineColumn(data, offset)
+	return &positionedError{line: line, column: column, err: err}
+}
+
+// withoutPosition removes the position from err, for errors in input that was converted from another format
+func withoutPosition(err error) error {
+	if p, ok := err.(*positionedError); ok {
+		return p.err
+	}
+	return err
+}
+
+// keyOffset returns the offset of the first use of key as an object key in data, or -1 if it isn't found
+func keyOffset(data []byte, key string) int64 {
+	quoted := []byte(strconv.Quote(key))
+	for start := 0; ; {
+		i := bytes.Index(data[start:], quoted)
+		if i < 0 {
+			return -1
+		}
+		at := start + i
+		rest := bytes.TrimLeft(data[at+len(quoted):], " \t\r\n")
+		if len(rest) > 0 && rest[0] == ':' {
+			return int64(at)
+		}
+		start = at + len(quoted)
+	}
+}
+
+// decodeError converts an error from decoding the configuration in data into one giving its position
+func decodeError(data []byte, err error) error {
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		return positionError(data, e.Offset-1, fmt.Errorf("JSON syntax error; %s", e))
+	case *json.UnmarshalTypeError:
+		field := e.Field[strings.LastIndex(e.Field, ".")+1:]
+		return positionError(data, keyOffset(data, field), fmt.Errorf("%s should be %s; not %s", e.Field, e.Type, e.Value))
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return positionError(data, int64(len(data)), errors.New("Configuration is incomplete"))
+	}
+	const unknownField = "json: unknown field "
+	if msg := err.Error(); strings.HasPrefix(msg, unknownField) {
+		if name, unquoteErr := strconv.Unquote(strings.TrimPrefix(msg, unknownField)); unquoteErr == nil {
+			return positionError(data, keyOffset(data, name), fmt.Errorf("Unknown key '%s'", name))
+		}
+	}
+	return err
+}
+
 var pattern = regexp.MustCompile("^([0-9]{1,2}):([0-9]{2})$")
 
 // decodeClock converts a string with syntax 12:34 or 5:01 into hours and minutes
@@ -3,7 +3,12 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"periph.io/x/periph/conn/gpio"
 )
 
 func TestDecodeTimeError(t *testing.T) {
@@ -76,6 +81,17 @@ func TestGetConfigError(t *testing.T) {
 			note: "location too long"},
 		{raw: bytes.NewBufferString(fmt.Sprintf(`{"location":[%f,%f], "lights_out":"%s"}`, magNLat, magNLon, "111:78")),
 			note: "invalid clock time"},
+		{raw: bytes.NewBufferString(fmt.Sprintf(`{"location":[%f,%f], "lights_out":"%s", "tls":{"cert_file":"c.pem"}}`, magNLat, magNLon, bedtime)),
+			note: "tls cert without key"},
+		{raw: bytes.NewBufferString(fmt.Sprintf(`{"location":[%f,%f], "lights_out":"%s"}`, 91.0, magNLon, bedtime)),
+			note: "latitude out of range"},
+		{raw: bytes.NewBufferString(fmt.Sprintf(`{"location":[%f,%f], "lights_out":"%s"}`, magNLat, -180.5, bedtime)),
+			note: "longitude out of range"},
+		{raw: bytes.NewBufferString(fmt.Sprintf(`{"location":[%f,%f], "light_out":"%s"}`, magNLat, magNLon, bedtime)),
+			note: "unknown key"},
+		{raw: bytes.NewBufferString(validConfig + `{}`), note: "trailing data"},
+		{raw: bytes.NewBufferString(fmt.Sprintf(`{"location":[%f,%f], "lights_out":"%s", "logging":{"output":"syslog"}}`, magNLat, magNLon, bedtime)),
+			note: "unknown log output"},
 	}
 	for _, tc := range testCases {
 		t.Run(fmt.Sprintf("input %v", tc.note), func(t *testing.T) {
@@ -122,3 +138,292 @@ func TestGetConfigLogToStdout(t *testing.T) {
 		t.Errorf("log to stdout is false; expected true")
 	}
 }
+
+func TestGetConfigTLS(t *testing.T) {
+	buf := bytes.NewBufferString(validConfig)
+	config, err := getConfiguration(buf)
+	if err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if config.tls != nil {
+		t.Errorf("tls is %v; expected nil when omitted", config.tls)
+	}
+
+	buf = bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", "tls":{"client_ca_file":"ca.pem"}}`,
+		magNLat, magNLon, bedtime))
+	config, err = getConfiguration(buf)
+	if err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if config.tls == nil || !config.tls.requireClientCert() {
+		t.Errorf("tls is %v; expected client certificates to be required", config.tls)
+	}
+}
+
+func TestGetConfigPlugs(t *testing.T) {
+	buf := bytes.NewBufferString(validConfig)
+	config, err := getConfiguration(buf)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(config.plugs) != 1 || config.plugs[0].name != "light" || config.plugs[0].id != plugOne {
+		t.Errorf("got plugs %v; expected the default light plug", config.plugs)
+	}
+
+	buf = bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s",
+		"plugs":[{"name":"lamp", "socket":2}, {"name":"heater", "socket":1}]}`,
+		magNLat, magNLon, bedtime))
+	config, err = getConfiguration(buf)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := []plugConfiguration{{name: "lamp", id: plugTwo}, {name: "heater", id: plugOne}}
+	if len(config.plugs) != len(expected) || config.plugs[0] != expected[0] || config.plugs[1] != expected[1] {
+		t.Errorf("got plugs %v; expected %v", config.plugs, expected)
+	}
+}
+
+func TestGetConfigPlugsError(t *testing.T) {
+	testCases := []struct {
+		plugs string
+		note  string
+	}{
+		{`[{"name":"Lamp", "socket":1}]`, "upper case name"},
+		{`[{"name":"", "socket":1}]`, "empty name"},
+		{`[{"name":"lamp", "socket":3}]`, "invalid socket"},
+		{`[{"name":"lamp", "socket":1}, {"name":"lamp", "socket":2}]`, "duplicate name"},
+		{`[{"name":"lamp", "socket":1}, {"name":"heater", "socket":1}]`, "duplicate socket"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.note, func(t *testing.T) {
+			buf := bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", "plugs":%s}`,
+				magNLat, magNLon, bedtime, tc.plugs))
+			if _, err := getConfiguration(buf); err == nil {
+				t.Errorf("expected error for %v; but got none", tc.note)
+			}
+		})
+	}
+}
+
+func TestGetConfigNames(t *testing.T) {
+	rules := `"rules":[{"plug":"light", "duration_seconds":60}]`
+	testCases := []struct {
+		note     string
+		config   string
+		expected string
+	}{
+		{"plug", `"plugs":[{"name":"Lamp", "socket":1}]`, "Plug name 'Lamp' should only contain lower case letters, digits, '-' and '_'"},
+		{"button", `"buttons":[{"name":"hall", "pin":"GPIO5", "plug":"light"}, {"name":"hall", "pin":"GPIO6", "plug":"light"}]`,
+			"Button name 'hall' is used more than once"},
+		{"motion sensor", `"motion_sensors":[{"name":"hall pir", "pin":"GPIO5", ` + rules + `}]`,
+			"Motion sensor name 'hall pir' should only contain lower case letters, digits, '-' and '_'"},
+		{"device", `"transmitter":{"pin":"GPIO5"}, "devices":[{"name":"fan"}, {"name":"fan"}]`, "Device name 'fan' is used more than once"},
+		{"sensor", `"sensors":[{"name":"tank", "type":"ds18b20", "probe":"28-0316a2795dff"}, {"name":"tank", "type":"bh1750", "bus":"1"}]`,
+			"Sensor name 'tank' is used more than once"},
+	}
+	for _, tc := range testCases {
+		buf := bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", %s}`, magNLat, magNLon, bedtime, tc.config))
+		if _, err := getConfiguration(buf); err == nil || !strings.HasSuffix(err.Error(), tc.expected) {
+			t.Errorf("%s: got error %v; expected %s", tc.note, err, tc.expected)
+		}
+	}
+}
+
+func TestGetConfigLogRotation(t *testing.T) {
+	buf := bytes.NewBufferString(validConfig)
+	config, err := getConfiguration(buf)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if config.logRotation != defaultRotation {
+		t.Errorf("got %v; expected default rotation %v", config.logRotation, defaultRotation)
+	}
+
+	buf = bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s",
+		"log_rotation":{"max_size_mb":0, "max_age_hours":24, "compress":true, "keep":3}}`,
+		magNLat, magNLon, bedtime))
+	config, err = getConfiguration(buf)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := rotationConfiguration{maxAge: 24 * time.Hour, compress: true, keep: 3}
+	if config.logRotation != expected {
+		t.Errorf("got %v; expected %v", config.logRotation, expected)
+	}
+
+	buf = bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", "log_rotation":{"keep":-1}}`,
+		magNLat, magNLon, bedtime))
+	if _, err = getConfiguration(buf); err == nil {
+		t.Errorf("expected error for negative keep")
+	}
+}
+
+func TestGetConfigLogging(t *testing.T) {
+	buf := bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s",
+		"logging":{"format":"json", "level":"warn", "levels":{"plug":"debug"}}}`,
+		magNLat, magNLon, bedtime))
+	config, err := getConfiguration(buf)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if config.logging.format != logFormatJSON || config.logging.levelFor("plug") != levelDebug || config.logging.levelFor("http") != levelWarn {
+		t.Errorf("unexpected logging configuration %v", config.logging)
+	}
+
+	for _, logging := range []string{`{"format":"xml"}`, `{"level":"loud"}`, `{"levels":{"plug":"loud"}}`} {
+		buf = bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", "logging":%s}`,
+			magNLat, magNLon, bedtime, logging))
+		if _, err = getConfiguration(buf); err == nil {
+			t.Errorf("expected error for logging %v", logging)
+		}
+	}
+}
+
+func TestGetConfigAccessLog(t *testing.T) {
+	buf := bytes.NewBufferString(validConfig)
+	config, err := getConfiguration(buf)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if config.accessLog.path != "" {
+		t.Errorf("got access log %v; expected none by default", config.accessLog)
+	}
+
+	buf = bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", "access_log":{}}`,
+		magNLat, magNLon, bedtime))
+	config, err = getConfiguration(buf)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if config.accessLog.path != accessLogFilename || config.accessLog.format != accessFormatCombined {
+		t.Errorf("got access log %v; expected defaults", config.accessLog)
+	}
+
+	buf = bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", "access_log":{"format":"apache"}}`,
+		magNLat, magNLon, bedtime))
+	if _, err = getConfiguration(buf); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+}
+
+func TestGetConfigErrorPosition(t *testing.T) {
+	testCases := []struct {
+		raw      string
+		expected string
+	}{
+		{"{\n  \"location\": [1, 2],\n  \"light_out\": \"22:00\"\n}", "line 3, column 3: Unknown key 'light_out'"},
+		{"{\n  \"location\": [1, 2],\n  \"lights_out\": \"22:00\",\n}", "line 4, column 1: JSON syntax error"},
+		{"{\"location\": [1, 2], \"lights_out\": 22}", "line 1, column 22: lights_out should be string; not number"},
+		{"{\"location\": [1, 2],\n\"lights_out\": \"25:00\"}", "line 2, column 1: Lights out value"},
+		{"{\"lights_out\": \"22:00\",\n \"location\": [91, 2]}", "line 2, column 2: Location latitude"},
+		{"{\"location\": [1, 2], \"lights_out\": \"22:00\"}\n\n x", "line 3, column 2: Unexpected data"},
+		{"{\"location\": [1, 2],", "line 1, column 21: Configuration is incomplete"},
+		{"{\"lights_out\": \"22:00\"}", "Location is missing"},
+	}
+	for _, tc := range testCases {
+		_, err := getConfiguration(bytes.NewBufferString(tc.raw))
+		if err == nil || !strings.HasPrefix(err.Error(), tc.expected) {
+			t.Errorf("got error %v for %q; expected %q", err, tc.raw, tc.expected)
+		}
+	}
+}
+
+func TestGetConfigThermal(t *testing.T) {
+	config, err := getConfiguration(bytes.NewBufferString(validConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.thermal != defaultThermal {
+		t.Errorf("got %+v; expected the default %+v", config.thermal, defaultThermal)
+	}
+
+	buf := bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", "thermal":{"interval_seconds":10, "threshold_celsius":72.5}}`,
+		magNLat, magNLon, bedtime))
+	if config, err = getConfiguration(buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := thermalConfiguration{interval: 10 * time.Second, threshold: 72.5, hysteresis: defaultThermal.hysteresis}
+	if config.thermal != expected {
+		t.Errorf("got %+v; expected %+v", config.thermal, expected)
+	}
+
+	for _, thermal := range []string{`{"interval_seconds":0}`, `{"threshold_celsius":-1}`, `{"hysteresis_celsius":-2}`} {
+		buf = bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", "thermal":%s}`, magNLat, magNLon, bedtime, thermal))
+		if _, err = getConfiguration(buf); err == nil {
+			t.Errorf("expected error for thermal %s", thermal)
+		}
+	}
+}
+
+func TestCanonicalPin(t *testing.T) {
+	for name, expected := range map[string]string{
+		"GPIO5":  "GPIO5",
+		"P1_29":  "GPIO5",
+		"5":      "GPIO5",
+		"P1_11":  "GPIO17",
+		"LED0":   "LED0",
+		"P1_1":   "P1_1",
+		"GPIO17": "GPIO17",
+	} {
+		if got := canonicalPin(name); got != expected {
+			t.Errorf("got %s for %s; expected %s", got, name, expected)
+		}
+	}
+}
+
+func TestGetConfigPinClashes(t *testing.T) {
+	testCases := []struct {
+		note     string
+		config   string
+		expected string
+	}{
+		{"encoder alias", `"buttons":[{"pin":"P1_11", "plug":"light"}]`, "Button pin P1_11 is used by the Energenie encoder"},
+		{"encoder chipset name", `"receiver":{"pin":"GPIO25", "addresses":[1]}`, "Receiver pin GPIO25 is used by the Energenie encoder"},
+		{"alias of another pin", `"buttons":[{"pin":"GPIO5", "plug":"light"}], "motion_sensors":[{"pin":"P1_29", "rules":[{"plug":"light", "duration_seconds":60}]}]`,
+			"Motion sensor pin P1_29 is used by a button"},
+		{"status LED and transmitter", `"transmitter":{"pin":"13"}, "status_led":{"pin":"P1_33"}`, "Status LED pin P1_33 is used by the transmitter"},
+	}
+	for _, tc := range testCases {
+		buf := bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", %s}`, magNLat, magNLon, bedtime, tc.config))
+		if _, err := getConfiguration(buf); err == nil || !strings.HasSuffix(err.Error(), tc.expected) {
+			t.Errorf("%s: got error %v; expected %s", tc.note, err, tc.expected)
+		}
+	}
+}
+
+func TestGetConfigButtons(t *testing.T) {
+	buf := bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", "buttons":[
+		{"pin":"GPIO16", "plug":"light"},
+		{"name":"hall", "pin":"GPIO26", "pull":"down", "debounce_ms":20, "action":"on", "plug":"light", "long_press_action":"all_off", "long_press_ms":2000}]}`,
+		magNLat, magNLon, bedtime))
+	config, err := getConfiguration(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []buttonConfiguration{
+		{name: "gpio16", pin: "GPIO16", pull: gpio.PullUp, activeLow: true, debounce: defaultDebounce, action: buttonToggle,
+			plug: "light", longPress: defaultLongPress},
+		{name: "hall", pin: "GPIO26", pull: gpio.PullDown, debounce: 20 * time.Millisecond, action: buttonOn,
+			plug: "light", longPress: 2 * time.Second, longAction: buttonAllOff},
+	}
+	if !reflect.DeepEqual(config.buttons, expected) {
+		t.Errorf("got %+v; expected %+v", config.buttons, expected)
+	}
+
+	for _, buttons := range []string{
+		`[{"plug":"light"}]`,
+		`[{"pin":"GPIO16", "plug":"light"}, {"pin":"GPIO16", "plug":"light"}]`,
+		`[{"pin":"GPIO16", "plug":"light", "pull":"sideways"}]`,
+		`[{"pin":"GPIO16", "plug":"light", "action":"dim"}]`,
+		`[{"pin":"GPIO16"}]`,
+		`[{"pin":"GPIO16", "plug":"lamp"}]`,
+		`[{"pin":"GPIO16", "action":"all_off", "long_press_ms":500}]`,
+		`[{"pin":"GPIO16", "action":"all_off", "long_press_action":"all_on", "long_press_ms":10}]`,
+		`[{"pin":"GPIO16", "action":"all_off", "debounce_ms":-1}]`,
+	} {
+		buf = bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", "buttons":%s}`, magNLat, magNLon, bedtime, buttons))
+		if _, err = getConfiguration(buf); err == nil {
+			t.Errorf("expected error for buttons %s", buttons)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// configuration file formats
+const (
+	configFormatJSON = "json"
+	configFormatYAML = "yaml"
+	configFormatTOML = "toml"
+)
+
+// configContentTypes are the media types of the configuration file formats
+var configContentTypes = map[string]string{
+	configFormatJSON: "application/json",
+	configFormatYAML: "application/yaml",
+	configFormatTOML: "application/toml",
+}
+
+const (
+	configEnvironmentVariable = "HEIHEI_CONFIG"
+	environmentPrefix         = "HEIHEI_"
+	environmentKeySeparator   = "__" // separates the keys of nested values in a variable name
+)
+
+// configOverride is a value from the environment that replaces one in the configuration file
+type configOverride struct {
+	variable string
+	path     []string
+	value    interface{}
+}
+
+// configPath returns the path of the configuration file, which is the one given on the command line,
+// the one named by HEIHEI_CONFIG or else configuration.json next to the executable
+func configPath(flagValue string) (string, error) {
+	if flagValue != "" {
+		return flagValue, nil
+	}
+	if p := os.Getenv(configEnvironmentVariable); p != "" {
+		return p, nil
+	}
+	ex, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(ex), configFilename), nil
+}
+
+// configFormat returns the format of the configuration file at path from its extension; JSON is the default
+func configFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return configFormatYAML
+	case ".toml":
+		return configFormatTOML
+	}
+	return configFormatJSON
+}
+
+// yamlValue is a decoded YAML value made of the types decoded from JSON
+// mapping keys are kept as written so that keys such as on and off aren't resolved to booleans
+type yamlValue struct {
+	value interface{}
+}
+
+func (v *yamlValue) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw interface{}
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	switch raw.(type) {
+	case map[interface{}]interface{}:
+		var mapping map[string]yamlValue
+		if err := unmarshal(&mapping); err != nil {
+			return err
+		}
+		tree := make(map[string]interface{}, len(mapping))
+		for key, value := range mapping {
+			tree[key] = value.value
+		}
+		v.value = tree
+	case []interface{}:
+		var sequence []yamlValue
+		if err := unmarshal(&sequence); err != nil {
+			return err
+		}
+		values := make([]interface{}, len(sequence))
+		for i, value := range sequence {
+			values[i] = value.value
+		}
+		v.value = values
+	default:
+		v.value = raw
+	}
+	return nil
+}
+
+// parseYAML decodes a YAML document into the types decoded from JSON; repeated keys are an error
+func parseYAML(data []byte) (interface{}, error) {
+	var v yamlValue
+	if err := yaml.UnmarshalStrict(data, &v); err != nil {
+		return nil, err
+	}
+	return v.value, nil
+}
+
+// parseTOML decodes a TOML document; integers are decoded as int64 rather than the float64 of JSON
+func parseTOML(data []byte) (map[string]interface{}, error) {
+	root := map[string]interface{}{}
+	if _, err := toml.Decode(string(data), &root); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// environmentOverrides returns the configuration values set by environment variables, in name order
+// HEIHEI_LIGHTS_OUT=22:30 sets lights_out and HEIHEI_LOGGING__LEVEL=debug sets the level within logging;
+// values are decoded as JSON if possible and are strings otherwise. Variables that don't start with
+// a configuration key are logged and ignored as they may be meant for something else
+func environmentOverrides(environ []string) (overrides []configOverride) {
+	for _, e := range environ {
+		i := strings.IndexByte(e, '=')
+		if i < 0 || !strings.HasPrefix(e[:i], environmentPrefix) || e[:i] == configEnvironmentVariable {
+			continue
+		}
+		name, raw := e[:i], e[i+1:]
+		var value interface{}
+		if err := json.Unmarshal([]byte(raw), &value); err != nil {
+			value = raw
+		}
+		path := strings.Split(strings.ToLower(strings.TrimPrefix(name, environmentPrefix)), environmentKeySeparator)
+		if !isConfigKey(path[0]) {
+			configLog.warnf("%s ignored; %s isn't a configuration key", name, path[0])
+			continue
+		}
+		overrides = append(overrides, configOverride{variable: name, path: path, value: value})
+	}
+	sort.Slice(overrides, func(i, j int) bool { return overrides[i].variable < overrides[j].variable })
+	return overrides
+}
+
+// set stores the override's value in tree, replacing anything that isn't an object on the way
+func (o configOverride) set(tree map[string]interface{}) {
+	for _, key := range o.path[:len(o.path)-1] {
+		child, ok := tree[key].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			tree[key] = child
+		}
+		tree = child
+	}
+	tree[o.path[len(o.path)-1]] = o.value
+}
+
+// decodeConfiguration extracts the server configuration from data in the given format, applying the environment overrides
+// YAML, TOML and overridden files are converted to JSON for validation so errors found then carry no position
+func decodeConfiguration(data []byte, format string, environ []string) (configuration, error) {
+	overrides := environmentOverrides(environ)
+	if format == configFormatJSON && len(overrides) == 0 {
+		return getConfiguration(bytes.NewReader(data))
+	}
+
+	var tree interface{}
+	var err error
+	switch format {
+	case configFormatYAML:
+		tree, err = parseYAML(data)
+	case configFormatTOML:
+		tree, err = parseTOML(data)
+	default:
+		if err = json.Unmarshal(data, &tree); err != nil {
+			err = decodeError(data, err)
+		}
+	}
+	if err != nil {
+		return configuration{}, err
+	}
+	root, ok := tree.(map[string]interface{})
+	if tree == nil {
+		root, ok = map[string]interface{}{}, true
+	}
+	if !ok {
+		return configuration{}, errors.New("Configuration should be an object")
+	}
+
+	for _, o := range overrides {
+		o.set(root)
+	}
+	converted, err := json.Marshal(root)
+	if err != nil {
+		return configuration{}, err
+	}
+	config, err := getConfiguration(bytes.NewReader(converted))
+	return config, withoutPosition(err)
+}
+
+// loadConfiguration reads the configuration file at path, applying the environment overrides
+func loadConfiguration(path string, environ []string) (configuration, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return configuration{}, err
+	}
+	config, err := decodeConfiguration(data, configFormat(path), environ)
+	if err != nil {
+		return config, fmt.Errorf("%s: %s", path, err)
+	}
+	return config, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfigFormat(t *testing.T) {
+	testCases := map[string]string{
+		"/etc/heihei/configuration.json": configFormatJSON,
+		"heihei.yaml":                    configFormatYAML,
+		"heihei.YML":                     configFormatYAML,
+		"heihei.toml":                    configFormatTOML,
+		"heihei.conf":                    configFormatJSON,
+	}
+	for path, expected := range testCases {
+		if got := configFormat(path); got != expected {
+			t.Errorf("got format %v for %v; expected %v", got, path, expected)
+		}
+	}
+}
+
+func TestConfigPath(t *testing.T) {
+	t.Setenv(configEnvironmentVariable, "/etc/heihei/heihei.yaml")
+	if got, _ := configPath("/tmp/flag.json"); got != "/tmp/flag.json" {
+		t.Errorf("got %v; expected the flag to take precedence", got)
+	}
+	if got, _ := configPath(""); got != "/etc/heihei/heihei.yaml" {
+		t.Errorf("got %v; expected the environment variable", got)
+	}
+	t.Setenv(configEnvironmentVariable, "")
+	if got, _ := configPath(""); filepath.Base(got) != configFilename {
+		t.Errorf("got %v; expected %v beside the executable", got, configFilename)
+	}
+}
+
+func TestEnvironmentOverrides(t *testing.T) {
+	environ := []string{
+		"PATH=/usr/bin",
+		"HEIHEI_LOGGING__LEVEL=debug",
+		"HEIHEI_CONFIG=/etc/heihei.json",
+		"HEIHEI_LOCATION=[1.5, 2]",
+		"HEIHEI_LIGHTS_OUT=22:30",
+		"HEIHEI_FOO=1",
+	}
+	expected := []configOverride{
+		{variable: "HEIHEI_LIGHTS_OUT", path: []string{"lights_out"}, value: "22:30"},
+		{variable: "HEIHEI_LOCATION", path: []string{"location"}, value: []interface{}{1.5, 2.0}},
+		{variable: "HEIHEI_LOGGING__LEVEL", path: []string{"logging", "level"}, value: "debug"},
+	}
+	if got := environmentOverrides(environ); !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v; expected %v", got, expected)
+	}
+}
+
+func TestDecodeConfigurationFormats(t *testing.T) {
+	testCases := []struct {
+		format string
+		data   string
+	}{
+		{configFormatJSON, fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", "plugs":[{"name":"fan","socket":2}]}`,
+			magNLat, magNLon, bedtime)},
+		{configFormatYAML, fmt.Sprintf("location: [%f, %f]\nlights_out: \"%s\"\nplugs:\n  - name: fan\n    socket: 2\n",
+			magNLat, magNLon, bedtime)},
+		{configFormatTOML, fmt.Sprintf("location = [%f, %f]\nlights_out = \"%s\"\n[[plugs]]\nname = \"fan\"\nsocket = 2\n",
+			magNLat, magNLon, bedtime)},
+	}
+	for _, tc := range testCases {
+		config, err := decodeConfiguration([]byte(tc.data), tc.format, nil)
+		if err != nil {
+			t.Errorf("unexpected %v error %v", tc.format, err)
+			continue
+		}
+		if lat, lon := config.latLong(); lat != magNLat || lon != magNLon || config.lightsOut != bedtime {
+			t.Errorf("got %v, %v and %v from %v", lat, lon, config.lightsOut, tc.format)
+		}
+		if len(config.plugs) != 1 || config.plugs[0].name != "fan" || config.plugs[0].id != plugTwo {
+			t.Errorf("got plugs %v from %v", config.plugs, tc.format)
+		}
+	}
+}
+
+func TestParseYAML(t *testing.T) {
+	// keys that YAML 1.1 resolves to booleans are kept as written, as are clock times
+	got, err := parseYAML([]byte("lights_out: 23:30\ndevices:\n  - name: fan\n    on: [300, 9000]\n    off:\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"lights_out": "23:30",
+		"devices":    []interface{}{map[string]interface{}{"name": "fan", "on": []interface{}{300, 9000}, "off": nil}},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %#v; expected %#v", got, expected)
+	}
+
+	for _, doc := range []string{"a: 1\na: 2", "a: [1, 2", "a: 1\n  b: 2"} {
+		if _, err = parseYAML([]byte(doc)); err == nil || !strings.Contains(err.Error(), "line") {
+			t.Errorf("got error %v for %q; expected one with its line", err, doc)
+		}
+	}
+}
+
+func TestParseTOML(t *testing.T) {
+	got, err := parseTOML([]byte("lights_out = \"23:30\"\n[[devices]]\nname = \"fan\"\non = [300, 9000]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"lights_out": "23:30",
+		"devices":    []map[string]interface{}{{"name": "fan", "on": []interface{}{int64(300), int64(9000)}}},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %#v; expected %#v", got, expected)
+	}
+
+	for _, doc := range []string{"a = 1\na = 2", "a = [1, 2", "a = \"unterminated"} {
+		if _, err = parseTOML([]byte(doc)); err == nil || !strings.Contains(err.Error(), "line") {
+			t.Errorf("got error %v for %q; expected one with its line", err, doc)
+		}
+	}
+}
+
+func TestDecodeConfigurationOverrides(t *testing.T) {
+	environ := []string{"HEIHEI_LIGHTS_OUT=21:00", "HEIHEI_LOGGING__LEVEL=debug", "HEIHEI_LOG_TO_STDOUT=false"}
+	config, err := decodeConfiguration([]byte(validConfig), configFormatJSON, environ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.lightsOut != "21:00" || config.logging.level != levelDebug || config.logToStdout {
+		t.Errorf("got %v, %v and %v; expected overridden values", config.lightsOut, config.logging.level, config.logToStdout)
+	}
+
+	// variables that aren't configuration keys are ignored
+	if config, err = decodeConfiguration([]byte(validConfig), configFormatJSON, []string{"HEIHEI_FOO=1", "HEIHEI_LIGHT_OUT=21:00"}); err != nil {
+		t.Errorf("unexpected error %v from unrelated variables", err)
+	} else if config.lightsOut != bedtime {
+		t.Errorf("got lights out %v; expected the configured value", config.lightsOut)
+	}
+
+	// mistakes in overrides are reported without positions in the converted configuration
+	_, err = decodeConfiguration([]byte(validConfig), configFormatJSON, []string{"HEIHEI_LOGGING__LEVL=debug"})
+	if err == nil || err.Error() != "Unknown key 'levl'" {
+		t.Errorf("got error %v; expected an unknown key", err)
+	}
+}
+
+func TestLoadConfiguration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heihei")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "heihei.yaml")
+	if err = ioutil.WriteFile(path, []byte("location: [1, 2]\nlights_out: 25:00\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = loadConfiguration(path, nil)
+	if err == nil || !strings.HasPrefix(err.Error(), path+": Lights out value") {
+		t.Errorf("got error %v; expected the path and problem", err)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// dashboardHandler serves the dashboard page; any other path under / is not found
+func dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	disableCache(w)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, dashboardHTML)
+}
+
+// dashboardHTML is a self-contained page so that it works without internet access
+const dashboardHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Heihei</title>
+<style>
+  body { font-family: -apple-system, "Segoe UI", Roboto, sans-serif; margin: 0; background: #f2f2f2; color: #222; }
+  header { background: #223; color: #fff; padding: 0.8em 1em; display: flex; justify-content: space-between; align-items: baseline; }
+  header h1 { font-size: 1.3em; margin: 0; }
+  main { display: grid; grid-template-columns: repeat(auto-fill, minmax(280px, 1fr)); gap: 1em; padding: 1em; }
+  section { background: #fff; border-radius: 8px; padding: 1em; box-shadow: 0 1px 3px rgba(0,0,0,0.15); }
+  h2 { font-size: 1.1em; margin: 0 0 0.6em 0; }
+  .state { font-weight: bold; }
+  .on { color: #1a7f37; }
+  .off { color: #777; }
+  .row { display: flex; gap: 0.5em; margin: 0.5em 0; flex-wrap: wrap; }
+  button, select { font-size: 1em; padding: 0.6em 1em; border-radius: 6px; border: 1px solid #aaa; background: #fafafa; }
+  button.primary { background: #1a7f37; color: #fff; border-color: #1a7f37; }
+  ul { list-style: none; padding: 0; margin: 0; }
+  li { padding: 0.3em 0; border-bottom: 1px solid #eee; }
+  li:last-child { border-bottom: none; }
+  .muted { color: #777; font-size: 0.9em; }
+  #error { color: #b00; padding: 0 1em; }
+</style>
+</head>
+<body>
+<header><h1>Heihei</h1><span id="clock" class="muted"></span></header>
+<div id="error"></div>
+<main>
+  <div id="plugs" style="display: contents"></div>
+  <section>
+    <h2>Alarm</h2>
+    <p>Alarm is <span id="alarm" class="state"></span></p>
+    <div class="row">
+      <button onclick="control('/alarm?set=on')">Set</button>
+      <button onclick="control('/alarm?set=off')">Unset</button>
+    </div>
+  </section>
+  <section>
+    <h2>Sunset</h2>
+    <ul id="sunset"></ul>
+    <p class="muted">Lights out at <span id="lightsout"></span></p>
+  </section>
+  <section>
+    <h2>Coming up</h2>
+    <ul id="evening"></ul>
+  </section>
+</main>
+<script>
+"use strict";
+function fmtTime(t) {
+  return t ? new Date(t).toLocaleTimeString([], {hour: "2-digit", minute: "2-digit"}) : "none";
+}
+function fmtDay(t) {
+  return new Date(t).toLocaleDateString([], {weekday: "short"}) + " " + fmtTime(t);
+}
+function text(tag, content, cls) {
+  var e = document.createElement(tag);
+  e.textContent = content;
+  if (cls) { e.className = cls; }
+  return e;
+}
+function control(url) {
+  fetch(url, {cache: "no-store"}).then(function (r) {
+    if (!r.ok) { return r.text().then(function (t) { throw new Error(t); }); }
+  }).then(refresh).catch(showError);
+}
+function showError(err) {
+  document.getElementById("error").textContent = err ? String(err) : "";
+}
+function plugCard(p) {
+  var card = document.createElement("section");
+  var base = "/plug/" + encodeURIComponent(p.name) + "?mode=";
+  card.appendChild(text("h2", p.name));
+  var state = document.createElement("p");
+  state.appendChild(text("span", p.on ? "on" : "off", "state " + (p.on ? "on" : "off")));
+  if (p.override_until) {
+    state.appendChild(text("span", " until " + fmtTime(p.override_until), "muted"));
+  }
+  card.appendChild(state);
+
+  var row = document.createElement("div");
+  row.className = "row";
+  var toggle = text("button", p.on ? "Switch off" : "Switch on", "primary");
+  toggle.onclick = function () { control(base + (p.on ? "off" : "on")); };
+  row.appendChild(toggle);
+  card.appendChild(row);
+
+  row = document.createElement("div");
+  row.className = "row";
+  var secs = document.createElement("select");
+  [15, 30, 60, 120].forEach(function (m) {
+    var o = text("option", m + " min");
+    o.value = m * 60;
+    secs.appendChild(o);
+  });
+  var timedOn = text("button", "On for");
+  timedOn.onclick = function () { control(base + "on&secs=" + secs.value); };
+  var timedOff = text("button", "Off for");
+  timedOff.onclick = function () { control(base + "off&secs=" + secs.value); };
+  row.appendChild(timedOn);
+  row.appendChild(timedOff);
+  row.appendChild(secs);
+  card.appendChild(row);
+  return card;
+}
+function render(s) {
+  document.getElementById("clock").textContent = "v" + s.version + " " + new Date(s.time).toLocaleString();
+  var plugs = document.getElementById("plugs");
+  plugs.textContent = "";
+  s.plugs.forEach(function (p) { plugs.appendChild(plugCard(p)); });
+
+  var alarm = document.getElementById("alarm");
+  alarm.textContent = s.alarm ? "set" : "unset";
+  alarm.className = "state " + (s.alarm ? "on" : "off");
+
+  var sunset = document.getElementById("sunset");
+  sunset.textContent = "";
+  [["Yesterday", s.sunset.yesterday], ["Today", s.sunset.today], ["Tomorrow", s.sunset.tomorrow]].forEach(function (d) {
+    sunset.appendChild(text("li", d[0] + ": " + fmtTime(d[1])));
+  });
+  document.getElementById("lightsout").textContent = s.lights_out;
+
+  var evening = document.getElementById("evening");
+  evening.textContent = "";
+  if (s.evening.length === 0) {
+    evening.appendChild(text("li", "No sunset due", "muted"));
+  }
+  s.evening.forEach(function (e) {
+    evening.appendChild(text("li", fmtDay(e.time) + " " + e.name));
+  });
+}
+function refresh() {
+  fetch("/api/v1/status", {cache: "no-store"}).then(function (r) {
+    if (!r.ok) { throw new Error("status " + r.status); }
+    return r.json();
+  }).then(function (s) { showError(); render(s); }).catch(showError);
+}
+refresh();
+// refresh on every state change; polling catches up after a dropped stream and keeps the times current
+if (window.EventSource) {
+  var events = new EventSource("/events");
+  ["plug", "alarm", "notification"].forEach(function (t) { events.addEventListener(t, refresh); });
+  events.onopen = refresh;
+  setInterval(refresh, 60000);
+} else {
+  setInterval(refresh, 5000);
+}
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"periph.io/x/periph/conn/i2c"
+)
+
+const (
+	defaultDisplayInterval = time.Second
+	displayRetry           = time.Minute // wait before reopening a display that failed
+	displayRecalculate     = time.Minute // the sunset and evening are slow to calculate so they are kept for this long
+)
+
+// text geometry of the 5x7 font with a column between characters and a row between lines
+const (
+	glyphWidth   = 5
+	charWidth    = glyphWidth + 1
+	displayLines = ssd1306Pages
+	displayChars = ssd1306Width / charWidth
+)
+
+// displayLog is the logger for the display subsystem
+var displayLog = newLogger("display")
+
+// displayConfiguration describes an OLED status display
+type displayConfiguration struct {
+	bus      string // the I2C bus name or number; empty for the first bus
+	address  uint16
+	interval time.Duration
+}
+
+// displayDevice returns the settings of the display that are only read at start up
+func displayDevice(c *displayConfiguration) interface{} {
+	if c == nil {
+		return nil
+	}
+	return [2]interface{}{c.bus, c.address}
+}
+
+// font5x7 holds the columns of the printable ASCII characters from space, each with the top pixel in bit 0
+var font5x7 = [...][glyphWidth]byte{
+	{0x00, 0x00, 0x00, 0x00, 0x00}, // space
+	{0x00, 0x00, 0x5f, 0x00, 0x00}, // !
+	{0x00, 0x07, 0x00, 0x07, 0x00}, // "
+	{0x14, 0x7f, 0x14, 0x7f, 0x14}, // #
+	{0x24, 0x2a, 0x7f, 0x2a, 0x12}, // $
+	{0x23, 0x13, 0x08, 0x64, 0x62}, // %
+	{0x36, 0x49, 0x55, 0x22, 0x50}, // &
+	{0x00, 0x05, 0x03, 0x00, 0x00}, // '
+	{0x00, 0x1c, 0x22, 0x41, 0x00}, // (
+	{0x00, 0x41, 0x22, 0x1c, 0x00}, // )
+	{0x14, 0x08, 0x3e, 0x08, 0x14}, // *
+	{0x08, 0x08, 0x3e, 0x08, 0x08}, // +
+	{0x00, 0x50, 0x30, 0x00, 0x00}, // ,
+	{0x08, 0x08, 0x08, 0x08, 0x08}, // -
+	{0x00, 0x60, 0x60, 0x00, 0x00}, // .
+	{0x20, 0x10, 0x08, 0x04, 0x02}, // /
+	{0x3e, 0x51, 0x49, 0x45, 0x3e}, // 0
+	{0x00, 0x42, 0x7f, 0x40, 0x00}, // 1
+	{0x42, 0x61, 0x51, 0x49, 0x46}, // 2
+	{0x21, 0x41, 0x45, 0x4b, 0x31}, // 3
+	{0x18, 0x14, 0x12, 0x7f, 0x10}, // 4
+	{0x27, 0x45, 0x45, 0x45, 0x39}, // 5
+	{0x3c, 0x4a, 0x49, 0x49, 0x30}, // 6
+	{0x01, 0x71, 0x09, 0x05, 0x03}, // 7
+	{0x36, 0x49, 0x49, 0x49, 0x36}, // 8
+	{0x06, 0x49, 0x49, 0x29, 0x1e}, // 9
+	{0x00, 0x36, 0x36, 0x00, 0x00}, // :
+	{0x00, 0x56, 0x36, 0x00, 0x00}, // ;
+	{0x08, 0x14, 0x22, 0x41, 0x00}, // <
+	{0x14, 0x14, 0x14, 0x14, 0x14}, // =
+	{0x00, 0x41, 0x22, 0x14, 0x08}, // >
+	{0x02, 0x01, 0x51, 0x09, 0x06}, // ?
+	{0x32, 0x49, 0x79, 0x41, 0x3e}, // @
+	{0x7e, 0x11, 0x11, 0x11, 0x7e}, // A
+	{0x7f, 0x49, 0x49, 0x49, 0x36}, // B
+	{0x3e, 0x41, 0x41, 0x41, 0x22}, // C
+	{0x7f, 0x41, 0x41, 0x22, 0x1c}, // D
+	{0x7f, 0x49, 0x49, 0x49, 0x41}, // E
+	{0x7f, 0x09, 0x09, 0x09, 0x01}, // F
+	{0x3e, 0x41, 0x49, 0x49, 0x7a}, // G
+	{0x7f, 0x08, 0x08, 0x08, 0x7f}, // H
+	{0x00, 0x41, 0x7f, 0x41, 0x00}, // I
+	{0x20, 0x40, 0x41, 0x3f, 0x01}, // J
+	{0x7f, 0x08, 0x14, 0x22, 0x41}, // K
+	{0x7f, 0x40, 0x40, 0x40, 0x40}, // L
+	{0x7f, 0x02, 0x0c, 0x02, 0x7f}, // M
+	{0x7f, 0x04, 0x08, 0x10, 0x7f}, // N
+	{0x3e, 0x41, 0x41, 0x41, 0x3e}, // O
+	{0x7f, 0x09, 0x09, 0x09, 0x06}, // P
+	{0x3e, 0x41, 0x51, 0x21, 0x5e}, // Q
+	{0x7f, 0x09, 0x19, 0x29, 0x46}, // R
+	{0x46, 0x49, 0x49, 0x49, 0x31}, // S
+	{0x01, 0x01, 0x7f, 0x01, 0x01}, // T
+	{0x3f, 0x40, 0x40, 0x40, 0x3f}, // U
+	{0x1f, 0x20, 0x40, 0x20, 0x1f}, // V
+	{0x3f, 0x40, 0x38, 0x40, 0x3f}, // W
+	{0x63, 0x14, 0x08, 0x14, 0x63}, // X
+	{0x07, 0x08, 0x70, 0x08, 0x07}, // Y
+	{0x61, 0x51, 0x49, 0x45, 0x43}, // Z
+	{0x00, 0x7f, 0x41, 0x41, 0x00}, // [
+	{0x02, 0x04, 0x08, 0x10, 0x20}, // backslash
+	{0x00, 0x41, 0x41, 0x7f, 0x00}, // ]
+	{0x04, 0x02, 0x01, 0x02, 0x04}, // ^
+	{0x40, 0x40, 0x40, 0x40, 0x40}, // _
+	{0x00, 0x01, 0x02, 0x04, 0x00}, // `
+	{0x20, 0x54, 0x54, 0x54, 0x78}, // a
+	{0x7f, 0x48, 0x44, 0x44, 0x38}, // b
+	{0x38, 0x44, 0x44, 0x44, 0x20}, // c
+	{0x38, 0x44, 0x44, 0x48, 0x7f}, // d
+	{0x38, 0x54, 0x54, 0x54, 0x18}, // e
+	{0x08, 0x7e, 0x09, 0x01, 0x02}, // f
+	{0x0c, 0x52, 0x52, 0x52, 0x3e}, // g
+	{0x7f, 0x08, 0x04, 0x04, 0x78}, // h
+	{0x00, 0x44, 0x7d, 0x40, 0x00}, // i
+	{0x20, 0x40, 0x44, 0x3d, 0x00}, // j
+	{0x7f, 0x10, 0x28, 0x44, 0x00}, // k
+	{0x00, 0x41, 0x7f, 0x40, 0x00}, // l
+	{0x7c, 0x04, 0x18, 0x04, 0x78}, // m
+	{0x7c, 0x08, 0x04, 0x04, 0x78}, // n
+	{0x38, 0x44, 0x44, 0x44, 0x38}, // o
+	{0x7c, 0x14, 0x14, 0x14, 0x08}, // p
+	{0x08, 0x14, 0x14, 0x18, 0x7c}, // q
+	{0x7c, 0x08, 0x04, 0x04, 0x08}, // r
+	{0x48, 0x54, 0x54, 0x54, 0x20}, // s
+	{0x04, 0x3f, 0x44, 0x40, 0x20}, // t
+	{0x3c, 0x40, 0x40, 0x20, 0x7c}, // u
+	{0x1c, 0x20, 0x40, 0x20, 0x1c}, // v
+	{0x3c, 0x40, 0x30, 0x40, 0x3c}, // w
+	{0x44, 0x28, 0x10, 0x28, 0x44}, // x
+	{0x0c, 0x50, 0x50, 0x50, 0x3c}, // y
+	{0x44, 0x64, 0x54, 0x4c, 0x44}, // z
+	{0x00, 0x08, 0x36, 0x41, 0x00}, // {
+	{0x00, 0x00, 0x7f, 0x00, 0x00}, // |
+	{0x00, 0x41, 0x36, 0x08, 0x00}, // }
+	{0x10, 0x08, 0x08, 0x10, 0x08}, // ~
+}
+
+// glyph returns the columns of r; characters outside the font are shown as ?
+func glyph(r rune) [glyphWidth]byte {
+	if r < ' ' || int(r-' ') >= len(font5x7) {
+		r = '?'
+	}
+	return font5x7[r-' ']
+}
+
+// print writes s on a line of text, clearing the rest of the line; text beyond the width of the display is cut off
+func (f *frame) print(line int, s string) {
+	row := f[line*ssd1306Width : (line+1)*ssd1306Width]
+	for i := range row {
+		row[i] = 0
+	}
+	col := 0
+	for _, r := range s {
+		if col+glyphWidth > ssd1306Width {
+			break
+		}
+		g := glyph(r)
+		copy(row[col:], g[:])
+		col += charWidth
+	}
+}
+
+// displayCache holds the sunset and evening from their last calculation
+type displayCache struct {
+	at      time.Time
+	sunset  *time.Time
+	evening []eveningStatus
+}
+
+// displayStatus collects the state of the server shown by the display at time now
+// the sunset and evening in cache are used until displayRecalculate has passed or the next sunset or lights out is due
+func displayStatus(now time.Time, plugs []namedPlug, a alarmInterface, config configuration, cache *displayCache) status {
+	s := status{Time: now, Plugs: []plugStatus{}, Alarm: a.isSet()}
+	for _, p := range plugs {
+		s.Plugs = append(s.Plugs, plugStatus{Name: p.name, On: p.state()})
+	}
+	if cache.at.IsZero() || now.Before(cache.at) || now.Sub(cache.at) >= displayRecalculate ||
+		(len(cache.evening) > 0 && !now.Before(cache.evening[0].Time)) {
+		*cache = displayCache{at: now}
+		latitude, longitude := config.latLong()
+		if t, err := sunsetOn(latitude, longitude, now); err == nil {
+			cache.sunset = &t
+		}
+		for _, e := range upcomingEvening(config, now, now.AddDate(0, 0, 1)) {
+			cache.evening = append(cache.evening, eveningStatus{Time: e.at, Name: e.name})
+		}
+	}
+	s.Sunset.Today, s.Evening = cache.sunset, cache.evening
+	return s
+}
+
+// displayText lays out the state of the server as lines of text
+// the time and sunset come first and the next sunset or lights out and alarm last, with as many plugs as fit between them
+func displayText(s status) []string {
+	lines := []string{fmt.Sprintf("%-*s%s", displayChars-8, s.Time.Format("Mon 2 Jan"), s.Time.Format("15:04:05"))}
+	if s.Sunset.Today != nil {
+		lines = append(lines, "Sunset "+s.Sunset.Today.Format("15:04"))
+	} else {
+		lines = append(lines, "No sunset today")
+	}
+
+	next := "No sunset due"
+	if len(s.Evening) > 0 {
+		e := s.Evening[0]
+		next = fmt.Sprintf("Next %s %s", e.Time.Format("15:04"), e.Name)
+	}
+	alarm := "Alarm off"
+	if s.Alarm {
+		alarm = "Alarm set"
+	}
+
+	// the last line for plugs counts those that don't fit
+	room := displayLines - len(lines) - 2
+	for i, p := range s.Plugs {
+		if i == room-1 && len(s.Plugs) > room {
+			lines = append(lines, fmt.Sprintf("+%d more plugs", len(s.Plugs)-i))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%-*.*s %3s", displayChars-4, displayChars-4, p.Name, onOff(p.On)))
+	}
+	return append(lines, next, alarm)
+}
+
+// onOff describes a plug state
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+// startDisplay starts a routine that shows the state of the server on the display at the configured interval
+// the display is opened with open on first use and reopened after a failure, waiting displayRetry between attempts
+// nothing is started if no display is configured
+func startDisplay(ctx context.Context, store *configStore, plugs []namedPlug, a alarmInterface, open func(displayConfiguration) (*ssd1306, i2c.BusCloser, error)) {
+	if store.get().display == nil {
+		return
+	}
+	go func() {
+		var d *ssd1306
+		var bus i2c.BusCloser
+		var retry time.Time
+		var f frame
+		var cache displayCache
+		for {
+			now := time.Now()
+			config := store.get()
+			if d == nil && !now.Before(retry) && config.display != nil {
+				var err error
+				if d, bus, err = open(*config.display); err != nil {
+					displayLog.warnf("display unavailable; %v", err)
+					retry = now.Add(displayRetry)
+				}
+			}
+			if d != nil {
+				lines := displayText(displayStatus(now, plugs, a, config, &cache))
+				for i := 0; i < displayLines; i++ {
+					line := ""
+					if i < len(lines) {
+						line = lines[i]
+					}
+					f.print(i, line)
+				}
+				if err := d.draw(&f); err != nil {
+					displayLog.warnf("display write error %v", err)
+					bus.Close()
+					d, bus = nil, nil
+					retry = now.Add(displayRetry)
+				}
+			}
+
+			interval := defaultDisplayInterval
+			if config.display != nil {
+				interval = config.display.interval
+			}
+			timer := time.NewTimer(interval)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				if d != nil {
+					d.off()
+					bus.Close()
+				}
+				return
+			}
+		}
+	}()
+}
+
+// openDisplay opens the I2C bus and initialises the display described by c
+func openDisplay(c displayConfiguration) (*ssd1306, i2c.BusCloser, error) {
+	bus, err := openI2CBus(c.bus)
+	if err != nil {
+		return nil, nil, err
+	}
+	d, err := newSSD1306(bus, c.address)
+	if err != nil {
+		bus.Close()
+		return nil, nil, err
+	}
+	return d, bus, nil
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"periph.io/x/periph/conn/i2c"
+)
+
+// fakeSSD1306 is a simulated SSD1306 that captures each frame written to its display memory in horizontal addressing mode
+type fakeSSD1306 struct {
+	mu       sync.Mutex
+	commands []byte
+	memory   frame
+	cursor   int
+	frames   []frame
+	fail     bool
+}
+
+func (d *fakeSSD1306) tx(w, r []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.fail {
+		return errors.New("no acknowledgement")
+	}
+	if len(w) == 0 {
+		return errors.New("no control byte")
+	}
+	switch w[0] {
+	case ssd1306Command:
+		d.commands = append(d.commands, w[1:]...)
+		if len(w) == 7 && w[1] == ssd1306ColumnRange && w[4] == ssd1306PageRange {
+			d.cursor = int(w[5])*ssd1306Width + int(w[2])
+		}
+	case ssd1306Data:
+		for _, b := range w[1:] {
+			d.memory[d.cursor] = b
+			d.cursor = (d.cursor + 1) % len(d.memory)
+			if d.cursor == 0 {
+				d.frames = append(d.frames, d.memory)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown control byte %#x", w[0])
+	}
+	return nil
+}
+
+func (d *fakeSSD1306) captured() []frame {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]frame(nil), d.frames...)
+}
+
+// textFrame returns the frame showing lines of text
+func textFrame(lines ...string) frame {
+	var f frame
+	for i := 0; i < displayLines; i++ {
+		line := ""
+		if i < len(lines) {
+			line = lines[i]
+		}
+		f.print(i, line)
+	}
+	return f
+}
+
+func TestSSD1306(t *testing.T) {
+	fake := &fakeSSD1306{}
+	bus := &fakeI2CBus{devices: map[uint16]func(w, r []byte) error{ssd1306AddressLow: fake.tx}}
+	d, err := newSSD1306(bus, ssd1306AddressLow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fake.commands, ssd1306Init) {
+		t.Errorf("commands % x; expected the initialisation % x", fake.commands, ssd1306Init)
+	}
+
+	f := textFrame("Hello")
+	if err = d.draw(&f); err != nil {
+		t.Fatal(err)
+	}
+	if frames := fake.captured(); len(frames) != 1 || frames[0] != f {
+		t.Errorf("captured %d frames; expected the drawn frame", len(frames))
+	}
+	if err = d.off(); err != nil || fake.commands[len(fake.commands)-1] != ssd1306DisplayOff {
+		t.Errorf("display not switched off %v", err)
+	}
+
+	if _, err = newSSD1306(bus, ssd1306AddressHigh); err == nil {
+		t.Errorf("expected an error for a missing display")
+	}
+}
+
+func TestFramePrint(t *testing.T) {
+	var f frame
+	f.print(1, "I?")
+	expected := append(append([]byte{0x00, 0x41, 0x7f, 0x41, 0x00, 0}, font5x7['?'-' '][:]...), 0)
+	if row := f[ssd1306Width : ssd1306Width+2*charWidth]; !bytes.Equal(row, expected) {
+		t.Errorf("got % x; expected % x", row, expected)
+	}
+	if g := glyph('é'); g != font5x7['?'-' '] {
+		t.Errorf("got %v; expected a character outside the font to be shown as ?", g)
+	}
+
+	// the line is cleared and text beyond the width is cut off
+	f.print(1, strings.Repeat("W", displayChars+3))
+	last := f[2*ssd1306Width-ssd1306Width%charWidth-charWidth : 2*ssd1306Width]
+	if !bytes.Equal(last[:glyphWidth], font5x7['W'-' '][:]) || !bytes.Equal(last[glyphWidth:], make([]byte, len(last)-glyphWidth)) {
+		t.Errorf("end of line % x; expected the last W followed by blank columns", last)
+	}
+	if f[0] != 0 || f[2*ssd1306Width] != 0 {
+		t.Errorf("expected the other lines to be untouched")
+	}
+}
+
+func TestDisplayText(t *testing.T) {
+	now := time.Date(2020, time.December, 1, 16, 2, 3, 0, time.UTC)
+	sunsetAt := time.Date(2020, time.December, 1, 15, 55, 0, 0, time.UTC)
+	plugs := func(n int) (plugs []plugStatus) {
+		for i := 0; i < n; i++ {
+			plugs = append(plugs, plugStatus{Name: fmt.Sprintf("plug%d", i), On: i == 0})
+		}
+		return plugs
+	}
+	testCases := []struct {
+		note     string
+		status   status
+		expected []string
+	}{
+		{
+			note: "everything",
+			status: status{Time: now, Sunset: sunsetStatus{Today: &sunsetAt}, Plugs: plugs(2), Alarm: true,
+				Evening: []eveningStatus{{Time: now.Add(7 * time.Hour), Name: "lights out"}}},
+			expected: []string{"Tue 1 Dec    16:02:03", "Sunset 15:55",
+				"plug0              on", "plug1             off", "Next 23:02 lights out", "Alarm set"},
+		},
+		{
+			note:   "too many plugs",
+			status: status{Time: now, Plugs: plugs(6)},
+			expected: []string{"Tue 1 Dec    16:02:03", "No sunset today",
+				"plug0              on", "plug1             off", "plug2             off", "+3 more plugs", "No sunset due", "Alarm off"},
+		},
+		{
+			note:   "plugs that just fit",
+			status: status{Time: now, Plugs: plugs(4)},
+			expected: []string{"Tue 1 Dec    16:02:03", "No sunset today",
+				"plug0              on", "plug1             off", "plug2             off", "plug3             off", "No sunset due", "Alarm off"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.note, func(t *testing.T) {
+			if lines := displayText(tc.status); !reflect.DeepEqual(lines, tc.expected) {
+				t.Errorf("got %q; expected %q", lines, tc.expected)
+			}
+		})
+	}
+}
+
+func TestDisplayStatus(t *testing.T) {
+	config := configuration{location: [2]float64{51.5, -0.1}, lightsOut: "23:00", plugs: []plugConfiguration{{name: "lamp", id: plugOne}}}
+	plugs := []namedPlug{{name: "lamp", plugInterface: &fakePlug{on: true}}}
+	now := time.Date(2020, time.December, 1, 12, 0, 0, 0, time.UTC)
+	cached := time.Date(2020, time.December, 1, 15, 0, 0, 0, time.UTC)
+	next := []eveningStatus{{Time: now.Add(time.Hour), Name: "sunset"}}
+	testCases := []struct {
+		note   string
+		cache  displayCache
+		reused bool
+	}{
+		{"recent", displayCache{at: now.Add(-30 * time.Second), sunset: &cached, evening: next}, true},
+		{"expired", displayCache{at: now.Add(-displayRecalculate), sunset: &cached, evening: next}, false},
+		{"event due", displayCache{at: now.Add(-30 * time.Second), sunset: &cached, evening: []eveningStatus{{Time: now, Name: "sunset"}}}, false},
+		{"empty", displayCache{}, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.note, func(t *testing.T) {
+			cache := tc.cache
+			s := displayStatus(now, plugs, new(fakeAlarm), config, &cache)
+			if len(s.Plugs) != 1 || !s.Plugs[0].On || s.Alarm {
+				t.Errorf("got plugs %+v and alarm %v; expected the lamp on and the alarm off", s.Plugs, s.Alarm)
+			}
+			if reused := s.Sunset.Today == &cached; reused != tc.reused {
+				t.Errorf("sunset %v reused %v; expected %v", s.Sunset.Today, reused, tc.reused)
+			}
+			if !tc.reused && (s.Sunset.Today == nil || len(s.Evening) == 0 || s.Evening[0].Name != "sunset" || !cache.at.Equal(now)) {
+				t.Errorf("got sunset %v and evening %+v; expected them to be calculated", s.Sunset.Today, s.Evening)
+			}
+		})
+	}
+}
+
+func TestStartDisplay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	fake := &fakeSSD1306{}
+	bus := &fakeI2CBus{devices: map[uint16]func(w, r []byte) error{ssd1306AddressLow: fake.tx}}
+	c := &displayConfiguration{address: ssd1306AddressLow, interval: 10 * time.Millisecond}
+	store := &configStore{config: configuration{location: [2]float64{51.5, -0.1}, lightsOut: "23:00", display: c}}
+	lamp := &fakePlug{on: true}
+	plugs := []namedPlug{{name: "lamp", plugInterface: lamp}}
+	opened := make(chan bool, 10)
+	startDisplay(ctx, store, plugs, new(fakeAlarm), func(c displayConfiguration) (*ssd1306, i2c.BusCloser, error) {
+		opened <- true
+		d, err := newSSD1306(bus, c.address)
+		return d, bus, err
+	})
+
+	// the first refresh calculates the sunset and evening, which is slow
+	deadline := time.Now().Add(10 * time.Second)
+	for len(fake.captured()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	frames := fake.captured()
+	if len(frames) < 2 {
+		t.Fatalf("captured %d frames; expected the display to be refreshed", len(frames))
+	}
+	lampLine := textFrame("", "", "lamp               on")
+	if !bytes.Equal(frames[len(frames)-1][2*ssd1306Width:3*ssd1306Width], lampLine[2*ssd1306Width:3*ssd1306Width]) {
+		t.Errorf("expected the third line to show the lamp on")
+	}
+
+	// a failed write closes the bus and the display isn't reopened until the retry
+	fake.mu.Lock()
+	fake.fail = true
+	fake.mu.Unlock()
+	closed := false
+	for deadline = time.Now().Add(time.Second); !closed && time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		bus.mu.Lock()
+		closed = bus.closed
+		bus.mu.Unlock()
+	}
+	if !closed || len(opened) != 1 {
+		t.Errorf("bus closed %v and display opened %d times; expected the bus closed after one open", closed, len(opened))
+	}
+	cancel()
+}
+
+func TestGetConfigDisplay(t *testing.T) {
+	buf := bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", "display":{"bus":"1", "address":61}}`,
+		magNLat, magNLon, bedtime))
+	config, err := getConfiguration(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &displayConfiguration{bus: "1", address: ssd1306AddressHigh, interval: defaultDisplayInterval}
+	if !reflect.DeepEqual(config.display, expected) {
+		t.Errorf("got %+v; expected %+v", config.display, expected)
+	}
+
+	for _, display := range []string{
+		`{"address":35}`,
+		`{"interval_seconds":0}`,
+		`{"rotate":true}`,
+	} {
+		buf = bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", "display":%s}`, magNLat, magNLon, bedtime, display))
+		if _, err = getConfiguration(buf); err == nil {
+			t.Errorf("expected error for display %s", display)
+		}
+	}
+}
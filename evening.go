@@ -0,0 +1,46 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// eveningEvent is a sunset or lights out; they are shown for information and nothing is switched by them
+type eveningEvent struct {
+	at   time.Time
+	name string
+}
+
+// upcomingEvening returns the sunsets and lights outs after from and no later than until, in time order
+// each lights out follows the sunset of its evening; days without a sunset are skipped
+func upcomingEvening(config configuration, from, until time.Time) (events []eveningEvent) {
+	hour, minute, err := decodeClock(config.lightsOut)
+	if err != nil {
+		return nil
+	}
+	latitude, longitude := config.latLong()
+
+	// start the day before to catch a lights out in the early hours of the morning
+	for day := from.AddDate(0, 0, -1); !day.After(until); day = day.AddDate(0, 0, 1) {
+		sunsetAt, err := sunsetOn(latitude, longitude, day)
+		if err != nil {
+			continue
+		}
+		// a lights out earlier than sunset belongs to the early hours of the next day
+		lightsOutAt := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, day.Location())
+		if lightsOutAt.Before(sunsetAt) {
+			lightsOutAt = lightsOutAt.AddDate(0, 0, 1)
+		}
+		events = append(events, eveningEvent{at: sunsetAt, name: "sunset"}, eveningEvent{at: lightsOutAt, name: "lights out"})
+	}
+
+	// remove events outside of the range
+	inRange := events[:0]
+	for _, e := range events {
+		if e.at.After(from) && !e.at.After(until) {
+			inRange = append(inRange, e)
+		}
+	}
+	sort.SliceStable(inRange, func(i, j int) bool { return inRange[i].at.Before(inRange[j].at) })
+	return inRange
+}
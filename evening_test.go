@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+const (
+	londonLat = 51.5
+	londonLon = -0.12
+)
+
+func TestUpcomingEvening(t *testing.T) {
+	config := configuration{location: [2]float64{londonLat, londonLon}, lightsOut: "23:30"}
+	from := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	events := upcomingEvening(config, from, from.AddDate(0, 0, 1))
+	if len(events) != 2 {
+		t.Fatalf("got %d events; expected 2 %v", len(events), events)
+	}
+
+	if sunset := events[0]; sunset.name != "sunset" || sunset.at.Day() != 1 || sunset.at.Hour() < 19 || sunset.at.Hour() > 21 {
+		t.Errorf("first event %v; expected the sunset", sunset)
+	}
+	lightsOut := time.Date(2018, 6, 1, 23, 30, 0, 0, time.UTC)
+	if e := events[1]; e.name != "lights out" || !e.at.Equal(lightsOut) {
+		t.Errorf("second event %v; expected lights out at %v", e, lightsOut)
+	}
+}
+
+func TestUpcomingEveningAfterMidnight(t *testing.T) {
+	config := configuration{location: [2]float64{londonLat, londonLon}, lightsOut: "0:30"}
+	from := time.Date(2018, 6, 1, 23, 0, 0, 0, time.UTC)
+	events := upcomingEvening(config, from, from.Add(2*time.Hour))
+	lightsOut := time.Date(2018, 6, 2, 0, 30, 0, 0, time.UTC)
+	if len(events) != 1 || events[0].name != "lights out" || !events[0].at.Equal(lightsOut) {
+		t.Errorf("got %v; expected a single lights out at %v", events, lightsOut)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// event types
+const (
+	eventPlug         = "plug"
+	eventAlarm        = "alarm"
+	eventNotification = "notification"
+	eventButton       = "button"
+	eventMotion       = "motion"
+)
+
+// subscriberBuffer is the number of events held for a subscriber before events are dropped
+const subscriberBuffer = 16
+
+// event is a change of state published on the event bus
+type event struct {
+	Type    string    `json:"type"`
+	Time    time.Time `json:"time"`
+	Name    string    `json:"name,omitempty"`
+	On      bool      `json:"on"`
+	Source  string    `json:"source,omitempty"`
+	Message string    `json:"message,omitempty"`
+}
+
+// eventLog is the logger for the events subsystem
+var eventLog = newLogger("events")
+
+// eventBus distributes published events to every subscriber
+// a nil bus discards events
+type eventBus struct {
+	publishC     chan event
+	subscribeC   chan chan event
+	unsubscribeC chan chan event
+	done         <-chan struct{}
+}
+
+// newEventBus creates a new event bus
+func newEventBus(ctx context.Context) *eventBus {
+	b := &eventBus{
+		publishC:     make(chan event),
+		subscribeC:   make(chan chan event),
+		unsubscribeC: make(chan chan event),
+		done:         ctx.Done(),
+	}
+
+	// start routine to manage subscribers
+	go func() {
+		subscribers := make(map[chan event]bool)
+		for {
+			select {
+
+			case e := <-b.publishC:
+				for c := range subscribers {
+					// a slow subscriber must not hold up the publisher
+					select {
+					case c <- e:
+					default:
+						eventLog.warnf("event dropped for slow subscriber")
+					}
+				}
+			case c := <-b.subscribeC:
+				subscribers[c] = true
+			case c := <-b.unsubscribeC:
+				if subscribers[c] {
+					delete(subscribers, c)
+					close(c)
+				}
+			case <-ctx.Done():
+				for c := range subscribers {
+					close(c)
+				}
+				return
+			}
+		}
+	}()
+
+	return b
+}
+
+// publish sends the event to all subscribers
+func (b *eventBus) publish(e event) {
+	if b == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	select {
+	case b.publishC <- e:
+	case <-b.done:
+	}
+}
+
+// subscribe returns a channel that receives all published events
+// the channel is closed by unsubscribe or when the bus stops
+func (b *eventBus) subscribe() chan event {
+	c := make(chan event, subscriberBuffer)
+	select {
+	case b.subscribeC <- c:
+	case <-b.done:
+		close(c)
+	}
+	return c
+}
+
+// unsubscribe stops events being sent to c
+func (b *eventBus) unsubscribe(c chan event) {
+	select {
+	case b.unsubscribeC <- c:
+	case <-b.done:
+	}
+}
+
+// sseKeepAlive is the period between comments sent to keep idle event streams open
+const sseKeepAlive = 30 * time.Second
+
+// eventsHandlerFunc returns a handler function that streams events as Server-Sent Events
+func eventsHandlerFunc(b *eventBus) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			respond(w, "Streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		disableCache(w)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		c := b.subscribe()
+		defer b.unsubscribe(c)
+		keepAlive := time.NewTicker(sseKeepAlive)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case e, ok := <-c:
+				if !ok {
+					return
+				}
+				data, err := json.Marshal(e)
+				if err != nil {
+					eventLog.errorf("event encoding error %v", err)
+					continue
+				}
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+				flusher.Flush()
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventBus(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bus := newEventBus(ctx)
+
+	first, second := bus.subscribe(), bus.subscribe()
+	bus.publish(event{Type: eventPlug, Name: "light", On: true})
+	for _, c := range []chan event{first, second} {
+		select {
+		case e := <-c:
+			if e.Type != eventPlug || e.Name != "light" || !e.On || e.Time.IsZero() {
+				t.Errorf("unexpected event %v", e)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event not received")
+		}
+	}
+
+	bus.unsubscribe(first)
+	if _, ok := <-first; ok {
+		t.Errorf("channel open; expected unsubscribe to close it")
+	}
+
+	cancel()
+	if _, ok := <-second; ok {
+		t.Errorf("channel open; expected the bus to close it when stopped")
+	}
+}
+
+func TestEventBusNil(t *testing.T) {
+	var bus *eventBus
+	bus.publish(event{Type: eventAlarm}) // must not block or panic
+}
+
+func TestEventBusSlowSubscriber(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bus := newEventBus(ctx)
+	bus.subscribe() // never read
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 2*subscriberBuffer; i++ {
+			bus.publish(event{Type: eventAlarm})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("publisher blocked by slow subscriber")
+	}
+}
+
+func TestEventsHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bus := newEventBus(ctx)
+	server := httptest.NewServer(newServeMuxFunc("/events", eventsHandlerFunc(bus)))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("content type %v; expected text/event-stream", ct)
+	}
+
+	// the subscription is made once the headers are sent so keep publishing until an event arrives
+	go func() {
+		for ctx.Err() == nil {
+			bus.publish(event{Type: eventPlug, Name: "light", On: true})
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	scanner := bufio.NewScanner(resp.Body)
+	var lines []string
+	for scanner.Scan() && len(lines) < 2 {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) != 2 || lines[0] != "event: plug" || !strings.HasPrefix(lines[1], "data: ") {
+		t.Fatalf("unexpected stream %q", lines)
+	}
+	var e event
+	if err = json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data: ")), &e); err != nil || e.Name != "light" {
+		t.Errorf("unexpected data %v %v", e, err)
+	}
+}
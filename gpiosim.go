@@ -0,0 +1,116 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"periph.io/x/periph/conn/gpio"
+)
+
+// simulatedPin is a GPIO pin whose level is set in software
+// it stands in for header pins and LEDs in devel builds and tests
+type simulatedPin struct {
+	name string
+
+	mu    sync.Mutex
+	level gpio.Level
+	pull  gpio.Pull
+	edge  gpio.Edge
+	edges chan struct{} // holds a pending edge
+}
+
+// newSimulatedPin creates a simulated pin that reads low until it is pulled or set
+func newSimulatedPin(name string) *simulatedPin {
+	return &simulatedPin{name: name, edges: make(chan struct{}, 1)}
+}
+
+func (s *simulatedPin) String() string   { return s.name }
+func (s *simulatedPin) Name() string     { return s.name }
+func (s *simulatedPin) Number() int      { return -1 }
+func (s *simulatedPin) Function() string { return "In/" + s.Read().String() }
+func (s *simulatedPin) Halt() error      { return nil }
+
+// In sets the pull and the edges to detect; a pull up or down sets the level as nothing else drives the pin
+func (s *simulatedPin) In(pull gpio.Pull, edge gpio.Edge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pull, s.edge = pull, edge
+	switch pull {
+	case gpio.PullUp:
+		s.level = gpio.High
+	case gpio.PullDown:
+		s.level = gpio.Low
+	}
+	return nil
+}
+
+func (s *simulatedPin) Read() gpio.Level {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.level
+}
+
+// WaitForEdge waits for a detected edge; a negative timeout waits forever
+func (s *simulatedPin) WaitForEdge(timeout time.Duration) bool {
+	if timeout < 0 {
+		<-s.edges
+		return true
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-s.edges:
+		return true
+	case <-t.C:
+		return false
+	}
+}
+
+func (s *simulatedPin) Pull() gpio.Pull {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pull
+}
+
+func (s *simulatedPin) DefaultPull() gpio.Pull {
+	return gpio.Float
+}
+
+// set drives the pin to l, signalling an edge if the change is one being detected
+func (s *simulatedPin) set(l gpio.Level) {
+	s.mu.Lock()
+	changed := l != s.level
+	s.level = l
+	detect := s.edge == gpio.BothEdges || (s.edge == gpio.RisingEdge && l == gpio.High) || (s.edge == gpio.FallingEdge && l == gpio.Low)
+	s.mu.Unlock()
+	if changed && detect {
+		select {
+		case s.edges <- struct{}{}:
+		default: // an edge is already pending
+		}
+	}
+}
+
+// Out drives the pin to l as an output
+func (s *simulatedPin) Out(l gpio.Level) error {
+	s.set(l)
+	return nil
+}
+
+// simulatedPins holds the simulated pins by name so that the same pin is returned for each request
+var simulatedPins = struct {
+	sync.Mutex
+	pins map[string]*simulatedPin
+}{pins: make(map[string]*simulatedPin)}
+
+// simulatedInput returns the simulated pin with the given name, creating it on first use
+func simulatedInput(name string) *simulatedPin {
+	simulatedPins.Lock()
+	defer simulatedPins.Unlock()
+	p, ok := simulatedPins.pins[name]
+	if !ok {
+		p = newSimulatedPin(name)
+		simulatedPins.pins[name] = p
+	}
+	return p
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// heartbeatGrace is how late the heartbeat of a routine may be before the routine is reported as stalled
+const heartbeatGrace = time.Minute
+
+// halError is the error from the last initialisation of the pins; protected by mutex
+var halError error
+
+// transmitResults holds the error of the most recent transmission to each plug
+var transmitResults = struct {
+	sync.Mutex
+	errors map[string]error
+}{errors: make(map[string]error)}
+
+// recordTransmit records the result of a transmission to the named plug
+func recordTransmit(name string, err error) {
+	transmitResults.Lock()
+	defer transmitResults.Unlock()
+	transmitResults.errors[name] = err
+}
+
+// heartbeat records when a routine last showed that it was alive and when it's due to again
+type heartbeat struct {
+	mu        sync.Mutex
+	last, due time.Time
+}
+
+// beat records that the routine was alive at now and will beat again within wait
+func (h *heartbeat) beat(now time.Time, wait time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.last, h.due = now, now.Add(wait)
+}
+
+// times returns the time of the last beat and when the next is due
+func (h *heartbeat) times() (last, due time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.last, h.due
+}
+
+// heartbeats holds the heartbeats of the running routines that switch plugs, by name
+var heartbeats = struct {
+	sync.Mutex
+	routines map[string]*heartbeat
+}{routines: make(map[string]*heartbeat)}
+
+// startHeartbeat returns the heartbeat of the named routine, which is checked for readiness until stop is called
+func startHeartbeat(name string, now time.Time) (h *heartbeat, stop func()) {
+	h = &heartbeat{last: now, due: now}
+	heartbeats.Lock()
+	defer heartbeats.Unlock()
+	heartbeats.routines[name] = h
+	return h, func() {
+		heartbeats.Lock()
+		defer heartbeats.Unlock()
+		if heartbeats.routines[name] == h {
+			delete(heartbeats.routines, name)
+		}
+	}
+}
+
+// healthCheck is the result of one readiness check
+type healthCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// readiness is the response of /readyz
+type readiness struct {
+	Ready  bool          `json:"ready"`
+	Checks []healthCheck `json:"checks"`
+}
+
+// checkHAL checks that the pins were initialised
+func checkHAL() healthCheck {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if halError != nil {
+		return healthCheck{Name: "gpio", Detail: halError.Error()}
+	}
+	return healthCheck{Name: "gpio", OK: true}
+}
+
+// checkTransmits checks that the most recent transmission to each plug had no pin errors
+func checkTransmits() healthCheck {
+	transmitResults.Lock()
+	defer transmitResults.Unlock()
+	var names []string
+	for name := range transmitResults.errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := transmitResults.errors[name]; err != nil {
+			return healthCheck{Name: "transmit", Detail: fmt.Sprintf("plug %s: %s", name, err)}
+		}
+	}
+	return healthCheck{Name: "transmit", OK: true}
+}
+
+// checkRoutines checks that the routines that switch plugs have beaten on time
+func checkRoutines(now time.Time) healthCheck {
+	heartbeats.Lock()
+	defer heartbeats.Unlock()
+	var names []string
+	for name := range heartbeats.routines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if last, due := heartbeats.routines[name].times(); now.Sub(due) > heartbeatGrace {
+			return healthCheck{Name: "routines", Detail: fmt.Sprintf("%s: no heartbeat since %s", name, last.Format(time.RFC3339))}
+		}
+	}
+	return healthCheck{Name: "routines", OK: true}
+}
+
+// checkClock checks that the system clock is synchronised
+func checkClock() healthCheck {
+	synchronised, err := clockSynchronised()
+	switch {
+	case err != nil:
+		return healthCheck{Name: "clock", Detail: err.Error()}
+	case !synchronised:
+		return healthCheck{Name: "clock", Detail: "not synchronised"}
+	}
+	return healthCheck{Name: "clock", OK: true}
+}
+
+// getReadiness runs the readiness checks
+func getReadiness(now time.Time) readiness {
+	r := readiness{
+		Ready:  true,
+		Checks: []healthCheck{checkHAL(), checkTransmits(), checkRoutines(now), checkClock()},
+	}
+	for _, c := range r.Checks {
+		r.Ready = r.Ready && c.OK
+	}
+	return r
+}
+
+// healthzHandler reports that the server is alive; it answers as long as requests are being served
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	disableCache(w)
+	respond(w, "OK", http.StatusOK)
+}
+
+// readyzHandler reports the readiness checks as JSON with status 503 if any of them failed
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	disableCache(w)
+	result := getReadiness(time.Now())
+	w.Header().Set("Content-Type", "application/json")
+	if !result.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	respondJSON(w, result)
+}
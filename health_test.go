@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckRoutines(t *testing.T) {
+	now := time.Now()
+	alive, stop := startHeartbeat("test routine", now.Add(-time.Hour))
+	testCases := []struct {
+		note string
+		last time.Time
+		wait time.Duration
+		ok   bool
+	}{
+		{"on time", now.Add(-time.Minute), 2 * time.Minute, true},
+		{"within the grace", now.Add(-time.Minute), time.Second, true},
+		{"stalled", now.Add(-time.Hour), time.Minute, false},
+	}
+	for _, tc := range testCases {
+		alive.beat(tc.last, tc.wait)
+		if c := checkRoutines(now); c.OK != tc.ok || (!c.OK && !strings.HasPrefix(c.Detail, "test routine: no heartbeat since")) {
+			t.Errorf("%s: got %+v; expected ok %v", tc.note, c, tc.ok)
+		}
+	}
+
+	// a routine that has stopped isn't checked
+	stop()
+	if c := checkRoutines(now); !c.OK {
+		t.Errorf("got %+v; expected ok once the routine stopped", c)
+	}
+}
+
+func TestCheckTransmits(t *testing.T) {
+	recordTransmit("light", nil)
+	if c := checkTransmits(); !c.OK {
+		t.Errorf("got %v; expected ok", c)
+	}
+	recordTransmit("fan", errors.New("pin failure"))
+	if c := checkTransmits(); c.OK || c.Detail != "plug fan: pin failure" {
+		t.Errorf("got %v; expected the fan error", c)
+	}
+	recordTransmit("fan", nil)
+	if c := checkTransmits(); !c.OK {
+		t.Errorf("got %v; expected ok after a successful transmit", c)
+	}
+}
+
+func TestReadyzHandler(t *testing.T) {
+	mutex.Lock()
+	halError = errors.New("no GPIO")
+	mutex.Unlock()
+	defer func() {
+		mutex.Lock()
+		halError = nil
+		mutex.Unlock()
+	}()
+
+	w := httptest.NewRecorder()
+	readyzHandler(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != 503 {
+		t.Errorf("got status %v; expected 503", w.Code)
+	}
+	var r readiness
+	if err := json.NewDecoder(w.Body).Decode(&r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Ready || len(r.Checks) != 4 || r.Checks[0].Name != "gpio" || r.Checks[0].OK || r.Checks[0].Detail != "no GPIO" {
+		t.Errorf("got %+v; expected the gpio check to fail", r)
+	}
+}
+
+func TestHealthzHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	healthzHandler(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != 200 {
+		t.Errorf("got status %v; expected 200", w.Code)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// learning timing and tolerance
+const (
+	learnTimeout = 30 * time.Second // the longest wait for the remote to be pressed
+	learnSlack   = 100 * time.Microsecond
+)
+
+// errLearning is returned when a code is learned while another is being learned
+var errLearning = errors.New("a code is already being learned")
+
+// learner passes the frames from the receiver to a request to learn a code
+type learner struct {
+	mu     sync.Mutex
+	frames chan ookFrame // nil unless a code is being learned
+}
+
+// received passes frame f to the code being learned, if any, dropping it if the learner is busy
+func (l *learner) received(f ookFrame, at time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.frames == nil {
+		return
+	}
+	select {
+	case l.frames <- f:
+	default:
+	}
+}
+
+// learn waits for two similar frames in a row, as sent by a remote while pressed, and returns their average
+// it returns the error of ctx if it's done first
+func (l *learner) learn(ctx context.Context) (ookFrame, error) {
+	l.mu.Lock()
+	if l.frames != nil {
+		l.mu.Unlock()
+		return nil, errLearning
+	}
+	frames := make(chan ookFrame, 16)
+	l.frames = frames
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.frames = nil
+		l.mu.Unlock()
+	}()
+
+	var last ookFrame
+	for {
+		select {
+		case f := <-frames:
+			if similarFrames(last, f) {
+				return averageFrames(last, f), nil
+			}
+			last = f
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// similarFrames returns true if a and b have the same number of pulses and each pair differs by at most a quarter
+// the gaps that end the frames aren't compared as a frame ended by silence has a longer gap
+func similarFrames(a, b ookFrame) bool {
+	if len(a) == 0 || len(a) != len(b) {
+		return false
+	}
+	for i := range a[:len(a)-1] {
+		long, short := a[i], b[i]
+		if short > long {
+			long, short = short, long
+		}
+		if long-short > long/4+learnSlack {
+			return false
+		}
+	}
+	return true
+}
+
+// averageFrames returns the average of the pulses of similar frames a and b, ended by the shorter gap
+func averageFrames(a, b ookFrame) ookFrame {
+	f := make(ookFrame, len(a))
+	for i := range a {
+		f[i] = (a[i] + b[i]) / 2
+	}
+	f[len(f)-1] = a[len(a)-1]
+	if b[len(b)-1] < f[len(f)-1] {
+		f[len(f)-1] = b[len(b)-1]
+	}
+	return f
+}
+
+// microseconds returns the widths of the pulses of f in microseconds, as in the configuration
+func (f ookFrame) microseconds() []int {
+	us := make([]int, len(f))
+	for i, p := range f {
+		us[i] = int(p / time.Microsecond)
+	}
+	return us
+}
+
+// saveLearnedCode sets the code of the named device in the JSON configuration file at path to the pulses
+// the file is validated before it's replaced and, like a migrated file, its keys are sorted
+func saveLearnedCode(path, device, state string, pulses []int) (configuration, error) {
+	if configFormat(path) != configFormatJSON {
+		return configuration{}, fmt.Errorf("learned codes are only saved to JSON configuration files; add %s to the %s device", state, device)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return configuration{}, err
+	}
+	var tree map[string]interface{}
+	if err = json.Unmarshal(data, &tree); err != nil {
+		return configuration{}, decodeError(data, err)
+	}
+	devices, _ := tree["devices"].([]interface{})
+	found := false
+	for _, d := range devices {
+		if entry, ok := d.(map[string]interface{}); ok && entry["name"] == device {
+			entry[state] = pulses
+			found = true
+		}
+	}
+	if !found {
+		return configuration{}, fmt.Errorf("device %s isn't in %s", device, path)
+	}
+	if data, err = json.MarshalIndent(tree, "", "  "); err != nil {
+		return configuration{}, err
+	}
+	data = append(data, '\n')
+	config, err := decodeConfiguration(data, configFormatJSON, os.Environ())
+	if err != nil {
+		return configuration{}, err
+	}
+	return config, writeFileAtomic(path, data)
+}
+
+// learnHandlerFunc returns a handler function that learns the on or off code of a configured device from its remote
+// the code is saved to the configuration file at path; the pulses are returned so that they can be added by hand
+// to configuration files in other formats
+func learnHandlerFunc(path string, store *configStore, l *learner) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		disableCache(w)
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			respond(w, "Learning supports POST", http.StatusMethodNotAllowed)
+			return
+		}
+		config := store.get()
+		device, state := r.URL.Query().Get("device"), r.URL.Query().Get("state")
+		if _, ok := findDevice(config.devices, device); !ok {
+			respond(w, fmt.Sprintf("Device '%s' is not configured", device), http.StatusNotFound)
+			return
+		}
+		if state != "on" && state != "off" {
+			respond(w, fmt.Sprintf("State should be on or off; not '%s'", state), http.StatusBadRequest)
+			return
+		}
+		if config.receiver == nil {
+			respond(w, "Learning needs a receiver", http.StatusConflict)
+			return
+		}
+
+		log := receiverLog.withContext(r.Context()).with(fields{"device": device, "state": state})
+		log.infof("learning; press the remote within %v", learnTimeout)
+		ctx, cancel := context.WithTimeout(r.Context(), learnTimeout)
+		defer cancel()
+		f, err := l.learn(ctx)
+		switch {
+		case err == errLearning:
+			respond(w, "Another code is being learned", http.StatusConflict)
+			return
+		case err != nil:
+			log.warnf("nothing learned; %v", err)
+			respond(w, fmt.Sprintf("No repeated code received within %v", learnTimeout), http.StatusRequestTimeout)
+			return
+		}
+		log.infof("learned %d pulses", len(f))
+
+		pulses := f.microseconds()
+		result := map[string]interface{}{"device": device, "state": state, "pulses": pulses, "saved": false}
+		config, err = saveLearnedCode(path, device, state, pulses)
+		if err != nil {
+			log.warnf("code not saved; %v", err)
+			result["error"] = err.Error()
+			respondJSON(w, result)
+			return
+		}
+		restart := replaceConfiguration(config, store)
+		if restart == nil {
+			restart = []string{}
+		}
+		configLog.withContext(r.Context()).infof("%s code of %s saved to %s", state, device, path)
+		result["saved"], result["restart_required"] = true, restart
+		respondJSON(w, result)
+	}
+}
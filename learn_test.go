@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSimilarFrames(t *testing.T) {
+	us := func(widths ...int) (f ookFrame) {
+		for _, w := range widths {
+			f = append(f, time.Duration(w)*time.Microsecond)
+		}
+		return f
+	}
+	a := us(300, 900, 900, 300, 9000)
+	testCases := []struct {
+		note     string
+		b        ookFrame
+		expected bool
+	}{
+		{"identical", a, true},
+		{"jitter", us(380, 820, 1000, 250, 9100), true},
+		{"silence", us(300, 900, 900, 300, 100000), true},
+		{"bit changed", us(300, 900, 300, 900, 9000), false},
+		{"fewer pulses", us(300, 900, 9000), false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.note, func(t *testing.T) {
+			if similar := similarFrames(a, tc.b); similar != tc.expected {
+				t.Errorf("got %v; expected %v", similar, tc.expected)
+			}
+		})
+	}
+	if similarFrames(nil, nil) {
+		t.Errorf("expected empty frames not to be similar")
+	}
+	if f := averageFrames(a, us(320, 880, 920, 280, 100000)); !similarFrames(f, a) || f[1] != 890*time.Microsecond || f[4] != a[4] {
+		t.Errorf("got %v; expected the average ended by the shorter gap", f)
+	}
+}
+
+// startLearning starts l learning and waits until it accepts frames, returning the channel of its result
+func startLearning(t *testing.T, ctx context.Context, l *learner) chan ookFrame {
+	learned := make(chan ookFrame, 1)
+	go func() {
+		f, _ := l.learn(ctx)
+		learned <- f
+	}()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		l.mu.Lock()
+		learning := l.frames != nil
+		l.mu.Unlock()
+		if learning {
+			return learned
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("learner not started")
+	return nil
+}
+
+func TestLearn(t *testing.T) {
+	l := &learner{}
+	l.received(ookFrame{time.Millisecond}, time.Now()) // frames are dropped when nothing is being learned
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	learned := startLearning(t, ctx, l)
+	if _, err := l.learn(ctx); err != errLearning {
+		t.Errorf("got %v; expected %v", err, errLearning)
+	}
+	replayCapture(readCapture(t, "socket1_on.txt"), l.received)
+	f := <-learned
+	if code, ok := f.hs1527(); !ok || code != 0x5a3c1f {
+		t.Errorf("learned %v; expected the frame of code 0x5a3c1f", f)
+	}
+
+	// a single frame isn't learned
+	short, cancelShort := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancelShort()
+	learned = startLearning(t, short, l)
+	replayCapture(readCapture(t, "single_frame.txt"), l.received)
+	if f = <-learned; f != nil {
+		t.Errorf("learned %v; expected nothing from a single frame", f)
+	}
+}
+
+func TestLearnHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heihei")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// the configuration is also valid YAML
+	config := fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", "receiver":{"pin":"GPIO5", "addresses":[1]}, "transmitter":{"pin":"GPIO6"}, "devices":[{"name":"doorbell"}]}`,
+		magNLat, magNLon, bedtime)
+	for _, name := range []string{"config.json", "config.yaml"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			if err = ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+				t.Fatal(err)
+			}
+			store := &configStore{}
+			if err = reloadConfiguration(path, store); err != nil {
+				t.Fatal(err)
+			}
+			l := &learner{}
+			handler := learnHandlerFunc(path, store, l)
+
+			for target, code := range map[string]int{
+				"/api/v1/learn?device=bell&state=on":     404,
+				"/api/v1/learn?device=doorbell&state=up": 400,
+			} {
+				w := httptest.NewRecorder()
+				handler(w, httptest.NewRequest("POST", target, nil))
+				if w.Code != code {
+					t.Errorf("%s: got status %v; expected %v", target, w.Code, code)
+				}
+			}
+
+			w := httptest.NewRecorder()
+			done := make(chan bool)
+			go func() {
+				handler(w, httptest.NewRequest("POST", "/api/v1/learn?device=doorbell&state=on", nil))
+				close(done)
+			}()
+			deadline := time.Now().Add(time.Second)
+			for learning := false; !learning && time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+				l.mu.Lock()
+				learning = l.frames != nil
+				l.mu.Unlock()
+			}
+			replayCapture(readCapture(t, "other_remote.txt"), l.received)
+			<-done
+
+			var result struct {
+				Pulses []int `json:"pulses"`
+				Saved  bool  `json:"saved"`
+			}
+			if err = json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+				t.Fatalf("%v: %s", err, w.Body.String())
+			}
+			json := filepath.Ext(name) == ".json"
+			if len(result.Pulses) != 2*ookBits+2 || result.Saved != json {
+				t.Errorf("got %d pulses saved %v; expected a frame saved %v", len(result.Pulses), result.Saved, json)
+			}
+			d, _ := findDevice(store.get().devices, "doorbell")
+			if learned := len(d.on) > 0; learned != json {
+				t.Errorf("got code %v; expected it applied %v", d.on, json)
+			}
+			if !json {
+				return
+			}
+			saved, err := loadConfiguration(path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d, _ = findDevice(saved.devices, "doorbell"); len(d.on) != len(result.Pulses) || len(d.off) != 0 {
+				t.Errorf("got device %+v; expected the on code saved", d)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"periph.io/x/periph/conn/i2c"
+)
+
+const (
+	defaultLightInterval = time.Minute
+	defaultLuxHysteresis = 10
+	defaultLuxDwell      = 5 * time.Minute
+)
+
+// lightLog is the logger for the light sensor subsystem
+var lightLog = newLogger("light")
+
+// lightConfiguration describes an ambient light sensor and the rules that switch plugs by its readings
+type lightConfiguration struct {
+	bus      string // the I2C bus name or number; empty for the first bus
+	address  uint16
+	interval time.Duration
+	rules    []luxRule
+}
+
+// luxRule switches a plug on when the illuminance falls below a threshold within its period
+// the plug is switched off again once the illuminance rises above the threshold plus the hysteresis
+// a rule doesn't change its plug again until the dwell time has passed
+type luxRule struct {
+	plug       string
+	below      float64
+	hysteresis float64
+	dwell      time.Duration
+	period
+}
+
+// luxRuleState is what a rule has done to its plug
+type luxRuleState struct {
+	switchedOn bool // the rule switched the plug on and hasn't switched it off
+	overridden bool // the plug was switched off while the rule had it on; cleared when it's light or the period ends
+	changed    time.Time
+}
+
+// luxStatus reports the latest reading of the light sensor
+type luxStatus struct {
+	Lux  float64   `json:"lux"`
+	Time time.Time `json:"time"`
+}
+
+// lightMonitor samples the light sensor and applies the rules
+type lightMonitor struct {
+	mu     sync.Mutex
+	latest *luxStatus
+	rules  []luxRuleState
+}
+
+// newLightMonitor starts a routine that reads the illuminance with read at the configured interval and applies the rules
+// nothing is started if no light sensor is configured
+func newLightMonitor(ctx context.Context, store *configStore, plugs []namedPlug, read func() (float64, error)) *lightMonitor {
+	m := &lightMonitor{}
+	if store.get().light == nil {
+		return m
+	}
+	ctx = withOrigin(ctx, origin{source: sourceLight})
+	alive, stop := startHeartbeat("light sensor", time.Now())
+	go func() {
+		defer stop()
+		for {
+			config := store.get()
+			interval := defaultLightInterval
+			if config.light != nil {
+				interval = config.light.interval
+				if lux, err := read(); err != nil {
+					lightLog.warnf("light sensor read error %v", err)
+				} else {
+					m.apply(ctx, time.Now(), lux, config, plugs)
+				}
+			}
+
+			alive.beat(time.Now(), interval)
+			timer := time.NewTimer(interval)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+	}()
+	return m
+}
+
+// apply records lux, read at now, and switches the plugs of the rules that it crosses the threshold of
+// a rule only switches off a plug that it switched on, and forgets it once its period ends or the plug is switched off;
+// a plug switched off by hand or by lights out is left off until the illuminance rises above the hysteresis or the period ends
+func (m *lightMonitor) apply(ctx context.Context, now time.Time, lux float64, config configuration, plugs []namedPlug) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.latest = &luxStatus{Lux: lux, Time: now}
+	rules := config.light.rules
+	if len(m.rules) != len(rules) {
+		// the rules have been reloaded so what was done by the old rules can't be matched to the new ones
+		m.rules = make([]luxRuleState, len(rules))
+	}
+
+	for i, r := range rules {
+		state := &m.rules[i]
+		p, ok := findPlug(plugs, r.plug)
+		if !ok {
+			continue
+		}
+		on := p.state()
+		if !r.applies(now, config) {
+			state.switchedOn, state.overridden = false, false
+			continue
+		}
+		l := lightLog.with(fields{"plug": r.plug, "lux": lux})
+		if state.switchedOn && !on {
+			l.infof("switched off while below %v lux; left off until above %v lux", r.below, r.below+r.hysteresis)
+			state.switchedOn, state.overridden = false, true
+		}
+		if state.overridden {
+			state.overridden = lux <= r.below+r.hysteresis
+			continue
+		}
+		if now.Sub(state.changed) < r.dwell {
+			continue
+		}
+		switch {
+		case lux < r.below && !on:
+			l.infof("below %v lux", r.below)
+			p.set(ctx, true)
+			*state = luxRuleState{switchedOn: true, changed: now}
+		case lux > r.below+r.hysteresis && state.switchedOn:
+			l.infof("above %v lux", r.below+r.hysteresis)
+			p.set(ctx, false)
+			*state = luxRuleState{changed: now}
+		}
+	}
+}
+
+// status returns the latest reading; a nil monitor or one without a reading returns nil
+func (m *lightMonitor) status() *luxStatus {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.latest == nil {
+		return nil
+	}
+	latest := *m.latest
+	return &latest
+}
+
+// luxReader returns a function that reads a BH1750 on the bus and at the address in c
+// the sensor is opened on first use and reopened after a failure, so a sensor that is connected later is found
+func luxReader(c lightConfiguration) func() (float64, error) {
+	var bus i2c.BusCloser
+	var sensor *bh1750
+	return func() (lux float64, err error) {
+		if sensor == nil {
+			if bus, err = openI2CBus(c.bus); err != nil {
+				return 0, err
+			}
+			if sensor, err = newBH1750(bus, c.address); err != nil {
+				bus.Close()
+				return 0, err
+			}
+			lightLog.infof("reading %s", sensor)
+		}
+		if lux, err = sensor.lux(); err != nil {
+			bus.Close()
+			sensor = nil
+		}
+		return lux, err
+	}
+}
+
+// lightInput returns the settings of the light sensor that are only read at start up
+func lightInput(c *lightConfiguration) interface{} {
+	if c == nil {
+		return nil
+	}
+	return [2]interface{}{c.bus, c.address}
+}
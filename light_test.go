@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLightMonitorApply(t *testing.T) {
+	start := time.Date(2020, time.December, 1, 15, 0, 0, 0, time.UTC)
+	// each reading is taken a minute after the last
+	testCases := []struct {
+		note     string
+		readings []float64
+		manual   func(p *fakePlug, i int) // changes the plug before the reading at index i
+		expected []bool
+	}{
+		{
+			note:     "darkening",
+			readings: []float64{200, 100, 40, 45, 55, 100},
+			expected: []bool{false, false, true, true, true, true},
+		},
+		{
+			note:     "brightening after the dwell",
+			readings: []float64{40, 100, 100, 100, 100, 100, 100},
+			expected: []bool{true, true, true, true, true, false, false},
+		},
+		{
+			note:     "within the hysteresis",
+			readings: []float64{40, 55, 59, 55, 58},
+			expected: []bool{true, true, true, true, true},
+		},
+		{
+			note:     "switched on by hand",
+			readings: []float64{100, 100, 100, 100, 100, 100},
+			manual: func(p *fakePlug, i int) {
+				if i == 1 {
+					p.on = true
+				}
+			},
+			expected: []bool{false, true, true, true, true, true},
+		},
+		{
+			note:     "switched off by hand in the dark",
+			readings: []float64{40, 40, 40, 40, 40, 40, 40, 40, 55, 40},
+			manual: func(p *fakePlug, i int) {
+				if i == 1 {
+					p.on = false
+				}
+			},
+			expected: []bool{true, false, false, false, false, false, false, false, false, false},
+		},
+		{
+			note:     "dark again after switched off by hand",
+			readings: []float64{40, 40, 40, 70, 40, 40},
+			manual: func(p *fakePlug, i int) {
+				if i == 1 {
+					p.on = false
+				}
+			},
+			expected: []bool{true, false, false, false, false, true},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.note, func(t *testing.T) {
+			p := &fakePlug{}
+			plugs := []namedPlug{{name: "lounge", plugInterface: p}}
+			config := configuration{location: [2]float64{51.5, -0.1}, lightsOut: "23:00", light: &lightConfiguration{
+				rules: []luxRule{{plug: "lounge", below: 50, hysteresis: 10, dwell: 5 * time.Minute}}}}
+			m := &lightMonitor{}
+
+			for i, lux := range tc.readings {
+				if tc.manual != nil {
+					tc.manual(p, i)
+				}
+				now := start.Add(time.Duration(i) * time.Minute)
+				m.apply(context.Background(), now, lux, config, plugs)
+				if p.on != tc.expected[i] {
+					t.Errorf("reading %d of %v lux; plug %v, expected %v", i, lux, p.on, tc.expected[i])
+				}
+				if s := m.status(); s == nil || s.Lux != lux || !s.Time.Equal(now) {
+					t.Errorf("status %+v; expected %v lux at %v", s, lux, now)
+				}
+			}
+		})
+	}
+}
+
+func TestLightMonitorPeriod(t *testing.T) {
+	p := &fakePlug{}
+	plugs := []namedPlug{{name: "lounge", plugInterface: p}}
+	config := configuration{location: [2]float64{51.5, -0.1}, lightsOut: "23:00", light: &lightConfiguration{
+		rules: []luxRule{{plug: "lounge", below: 50, period: period{after: "12:00", before: boundaryLightsOut}}}}}
+	m := &lightMonitor{}
+
+	m.apply(context.Background(), time.Date(2020, time.December, 1, 23, 30, 0, 0, time.UTC), 0, config, plugs)
+	if p.on {
+		t.Errorf("plug switched on after lights out")
+	}
+	m.apply(context.Background(), time.Date(2020, time.December, 2, 12, 30, 0, 0, time.UTC), 0, config, plugs)
+	if !p.on {
+		t.Errorf("plug not switched on within the period")
+	}
+
+	// a plug switched off by lights out stays off until the period starts again
+	p.on = false
+	m.apply(context.Background(), time.Date(2020, time.December, 2, 20, 0, 0, 0, time.UTC), 0, config, plugs)
+	if p.on {
+		t.Errorf("plug switched back on within the period")
+	}
+	m.apply(context.Background(), time.Date(2020, time.December, 2, 23, 30, 0, 0, time.UTC), 0, config, plugs)
+	m.apply(context.Background(), time.Date(2020, time.December, 3, 12, 30, 0, 0, time.UTC), 0, config, plugs)
+	if !p.on {
+		t.Errorf("plug not switched on when the period started again")
+	}
+}
+
+func TestLightMonitorNil(t *testing.T) {
+	var m *lightMonitor
+	if s := m.status(); s != nil {
+		t.Errorf("got %v; expected no status", s)
+	}
+	if s := (&lightMonitor{}).status(); s != nil {
+		t.Errorf("got %v; expected no status before a reading", s)
+	}
+}
+
+func TestGetConfigLightSensor(t *testing.T) {
+	buf := bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", "light_sensor":{"address":92, "rules":[
+		{"plug":"light", "below_lux":50, "after":"12:00", "before":"lights_out"}]}}`, magNLat, magNLon, bedtime))
+	config, err := getConfiguration(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &lightConfiguration{address: bh1750AddressHigh, interval: defaultLightInterval, rules: []luxRule{
+		{plug: "light", below: 50, hysteresis: defaultLuxHysteresis, dwell: defaultLuxDwell, period: period{after: "12:00", before: boundaryLightsOut}}}}
+	if !reflect.DeepEqual(config.light, expected) {
+		t.Errorf("got %+v; expected %+v", config.light, expected)
+	}
+
+	for _, sensor := range []string{
+		`{"address":16}`,
+		`{"interval_seconds":0}`,
+		`{"rules":[{"plug":"lamp", "below_lux":50}]}`,
+		`{"rules":[{"plug":"light"}]}`,
+		`{"rules":[{"plug":"light", "below_lux":50, "hysteresis_lux":-1}]}`,
+		`{"rules":[{"plug":"light", "below_lux":50, "min_dwell_seconds":-1}]}`,
+		`{"rules":[{"plug":"light", "below_lux":50, "before":"dawn"}]}`,
+	} {
+		buf = bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", "light_sensor":%s}`, magNLat, magNLon, bedtime, sensor))
+		if _, err = getConfiguration(buf); err == nil {
+			t.Errorf("expected error for light sensor %s", sensor)
+		}
+	}
+}
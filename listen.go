@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const (
+	defaultHTTPAddress  = "http://:8000"
+	defaultHTTPSAddress = "https://:8443"
+)
+
+// listenAddress is a parsed listener address such as http://:8000 or https://192.168.1.2:8443
+type listenAddress struct {
+	scheme string
+	host   string
+}
+
+func (a listenAddress) String() string {
+	return a.scheme + "://" + a.host
+}
+
+// parseListenAddress converts a string with syntax scheme://[host]:port into a listenAddress
+// the scheme must be http or https
+func parseListenAddress(input string) (a listenAddress, err error) {
+	u, err := url.Parse(input)
+	if err != nil {
+		return a, fmt.Errorf("listen address %s has an unsupported syntax", input)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return a, fmt.Errorf("listen address %s should start with http:// or https://", input)
+	}
+	if _, port, err := net.SplitHostPort(u.Host); err != nil || port == "" {
+		return a, fmt.Errorf("listen address %s is missing a port", input)
+	}
+	if u.Path != "" && u.Path != "/" {
+		return a, fmt.Errorf("listen address %s should not contain a path", input)
+	}
+	return listenAddress{scheme: u.Scheme, host: u.Host}, nil
+}
+
+// listenFlag collects the values of a repeated -listen command line flag
+type listenFlag []string
+
+func (f *listenFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *listenFlag) Set(value string) error {
+	if _, err := parseListenAddress(value); err != nil {
+		return err
+	}
+	*f = append(*f, value)
+	return nil
+}
+
+// listenAddresses returns the addresses the server listens on
+// if none are configured, HTTP is served on port 8000 and, if TLS is configured, HTTPS on port 8443
+func (c configuration) listenAddresses() []listenAddress {
+	if len(c.listen) > 0 {
+		return c.listen
+	}
+	httpAddr, _ := parseListenAddress(defaultHTTPAddress)
+	httpsAddr, _ := parseListenAddress(defaultHTTPSAddress)
+	if c.tls == nil {
+		return []listenAddress{httpAddr}
+	}
+	// plain HTTP would bypass the client certificate check so only serve HTTPS
+	if c.tls.requireClientCert() {
+		return []listenAddress{httpsAddr}
+	}
+	return []listenAddress{httpAddr, httpsAddr}
+}
+
+// checkListen returns an error if a plain HTTP address would bypass the client certificates required by the TLS configuration
+func (c configuration) checkListen() error {
+	if c.tls == nil || !c.tls.requireClientCert() {
+		return nil
+	}
+	for _, a := range c.listenAddresses() {
+		if a.scheme != "https" {
+			return fmt.Errorf("Listen address %v would serve without the client certificates required by tls client_ca_file; use https", a)
+		}
+	}
+	return nil
+}
+
+// serve listens on every address and serves handler until one of the listeners fails
+// dir is used to locate the TLS files named by the configuration and stateDir to store a generated certificate
+func serve(addresses []listenAddress, config configuration, dir, stateDir string, handler http.Handler) error {
+	errC := make(chan error, len(addresses))
+	for _, a := range addresses {
+		server := &http.Server{Addr: a.host, Handler: handler}
+		if a.scheme == "https" {
+			c := tlsConfiguration{}
+			if config.tls != nil {
+				c = *config.tls
+			}
+			tlsConfig, err := newTLSConfig(c, dir, stateDir)
+			if err != nil {
+				return err
+			}
+			server.TLSConfig = tlsConfig
+		}
+
+		httpLog.infof("listening on %v", a)
+		go func(a listenAddress, server *http.Server) {
+			if a.scheme == "https" {
+				errC <- server.ListenAndServeTLS("", "")
+			} else {
+				errC <- server.ListenAndServe()
+			}
+		}(a, server)
+	}
+	return <-errC
+}
+
+// serveAdmin serves handler on a Unix domain socket at path
+// the socket is only accessible by the owner and group of the process
+func serveAdmin(path string, handler http.Handler) error {
+	// remove a socket left behind by a previous run
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(path, 0660); err != nil {
+		l.Close()
+		return err
+	}
+	httpLog.infof("admin endpoints listening on %s", path)
+	return http.Serve(l, handler)
+}
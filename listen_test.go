@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestParseListenAddressError(t *testing.T) {
+	testCases := []string{
+		":8000",
+		"ftp://:21",
+		"http://localhost",
+		"https://:8443/path",
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("input %v", tc), func(t *testing.T) {
+			if _, err := parseListenAddress(tc); err == nil {
+				t.Errorf("expected error for input %v; but got none", tc)
+			}
+		})
+	}
+}
+
+func TestParseListenAddressValid(t *testing.T) {
+	testCases := []struct {
+		input, scheme, host string
+	}{
+		{"http://:8000", "http", ":8000"},
+		{"https://:8443", "https", ":8443"},
+		{"http://192.168.1.2:80", "http", "192.168.1.2:80"},
+		{"https://[::1]:8443/", "https", "[::1]:8443"},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("input %v", tc.input), func(t *testing.T) {
+			a, err := parseListenAddress(tc.input)
+			if err != nil {
+				t.Errorf("unexpected error for input %v; %v", tc.input, err)
+			} else if a.scheme != tc.scheme || a.host != tc.host {
+				t.Errorf("got %v; expected %v://%v", a, tc.scheme, tc.host)
+			}
+		})
+	}
+}
+
+func TestListenAddressesDefault(t *testing.T) {
+	testCases := []struct {
+		tls      *tlsConfiguration
+		expected string
+		note     string
+	}{
+		{nil, "[http://:8000]", "no tls"},
+		{&tlsConfiguration{}, "[http://:8000 https://:8443]", "tls"},
+		{&tlsConfiguration{clientCAFile: "ca.pem"}, "[https://:8443]", "client certificates"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.note, func(t *testing.T) {
+			config := configuration{tls: tc.tls}
+			if actual := fmt.Sprint(config.listenAddresses()); actual != tc.expected {
+				t.Errorf("got %v; expected %v", actual, tc.expected)
+			}
+		})
+	}
+}
+
+func TestGetConfigListen(t *testing.T) {
+	buf := bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", "listen":["http://:80", "https://:443"]}`,
+		magNLat, magNLon, bedtime))
+	config, err := getConfiguration(buf)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if actual := fmt.Sprint(config.listenAddresses()); actual != "[http://:80 https://:443]" {
+		t.Errorf("got %v; expected configured addresses", actual)
+	}
+
+	buf = bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", "listen":["tcp://:80"]}`,
+		magNLat, magNLon, bedtime))
+	if _, err = getConfiguration(buf); err == nil {
+		t.Errorf("expected error for invalid listen address")
+	}
+}
+
+func TestGetConfigListenClientCA(t *testing.T) {
+	for listen, plain := range map[string]bool{
+		`["https://:443"]`:               false,
+		`["http://:80", "https://:443"]`: true,
+		`["http://127.0.0.1:8000"]`:      true,
+	} {
+		buf := bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", "tls":{"client_ca_file":"ca.pem"}, "listen":%s}`,
+			magNLat, magNLon, bedtime, listen))
+		_, err := getConfiguration(buf)
+		if rejected := err != nil; rejected != plain {
+			t.Errorf("got error %v for %s; expected rejected %v", err, listen, plain)
+		}
+	}
+
+	// the default addresses only serve HTTPS
+	buf := bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", "tls":{"client_ca_file":"ca.pem"}}`,
+		magNLat, magNLon, bedtime))
+	if _, err := getConfiguration(buf); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logLevel is the severity of a log record
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l logLevel) String() string {
+	if l < levelDebug || l > levelError {
+		return fmt.Sprintf("logLevel(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// parseLogLevel converts a level name such as "info" into a logLevel
+func parseLogLevel(name string) (logLevel, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return logLevel(i), nil
+		}
+	}
+	return levelInfo, fmt.Errorf("log level '%s' should be one of %s", name, strings.Join(levelNames, ", "))
+}
+
+// log formats
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// log outputs
+const (
+	logOutputFile   = "file"
+	logOutputStdout = "stdout"
+)
+
+// loggingConfiguration describes the format of log records and the minimum level written per subsystem
+type loggingConfiguration struct {
+	format string
+	level  logLevel
+	levels map[string]logLevel // overrides level for the named subsystems
+}
+
+// defaultLogging is used when the configuration doesn't describe logging
+var defaultLogging = loggingConfiguration{format: logFormatText, level: levelInfo}
+
+// levelFor returns the minimum level written for the subsystem
+func (c loggingConfiguration) levelFor(subsystem string) logLevel {
+	if l, ok := c.levels[subsystem]; ok {
+		return l
+	}
+	return c.level
+}
+
+// logSink is where log records are written
+// text records go through the standard log package, which init discards by default i.e. for unit testing
+var logSink = struct {
+	sync.Mutex
+	config loggingConfiguration
+	json   io.Writer // destination of JSON records
+}{config: defaultLogging, json: ioutil.Discard}
+
+// configureLogging sets the format and levels of log records; JSON records are written to w
+func configureLogging(w io.Writer, c loggingConfiguration) {
+	logSink.Lock()
+	defer logSink.Unlock()
+	logSink.config = c
+	logSink.json = w
+}
+
+// fields are the structured values attached to a log record
+type fields map[string]interface{}
+
+// logger writes levelled, structured log records for a subsystem
+type logger struct {
+	subsystem string
+	fields    fields
+}
+
+// newLogger creates a logger for the named subsystem
+func newLogger(subsystem string) logger {
+	return logger{subsystem: subsystem}
+}
+
+// with returns a logger that adds f to every record
+func (l logger) with(f fields) logger {
+	merged := make(fields, len(l.fields)+len(f))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range f {
+		merged[k] = v
+	}
+	return logger{subsystem: l.subsystem, fields: merged}
+}
+
+// withContext returns a logger that adds the source and request id carried by ctx
+func (l logger) withContext(ctx context.Context) logger {
+	o := originFrom(ctx)
+	f := fields{}
+	if o.source != "" {
+		f["source"] = o.source
+	}
+	if o.requestID != "" {
+		f["request"] = o.requestID
+	}
+	return l.with(f)
+}
+
+func (l logger) debugf(format string, v ...interface{}) { l.output(levelDebug, format, v...) }
+func (l logger) infof(format string, v ...interface{})  { l.output(levelInfo, format, v...) }
+func (l logger) warnf(format string, v ...interface{})  { l.output(levelWarn, format, v...) }
+func (l logger) errorf(format string, v ...interface{}) { l.output(levelError, format, v...) }
+
+// fatalf writes an error record and exits
+func (l logger) fatalf(format string, v ...interface{}) {
+	l.output(levelError, format, v...)
+	os.Exit(1)
+}
+
+// output writes a record if level is enabled for the subsystem
+func (l logger) output(level logLevel, format string, v ...interface{}) {
+	logSink.Lock()
+	defer logSink.Unlock()
+	if level < logSink.config.levelFor(l.subsystem) {
+		return
+	}
+	msg := strings.TrimSuffix(fmt.Sprintf(format, v...), "\n")
+
+	if logSink.config.format == logFormatJSON {
+		record := make(map[string]interface{}, len(l.fields)+4)
+		for k, v := range l.fields {
+			record[k] = v
+		}
+		record["time"] = time.Now().Format(time.RFC3339Nano)
+		record["level"] = level.String()
+		record["subsystem"] = l.subsystem
+		record["msg"] = msg
+		if line, err := json.Marshal(record); err == nil {
+			logSink.json.Write(append(line, '\n'))
+		}
+		return
+	}
+
+	// calldepth 3 reports the caller of debugf, infof etc.
+	log.Output(3, fmt.Sprintf("%s [%s] %s%s", strings.ToUpper(level.String()), l.subsystem, msg, formatFields(l.fields)))
+}
+
+// formatFields returns the fields as " key=value" pairs in key order
+func formatFields(f fields) string {
+	keys := make([]string, 0, len(f))
+	for k := range f {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		v := fmt.Sprint(f[k])
+		if strings.ContainsAny(v, " \"=") {
+			v = fmt.Sprintf("%q", v)
+		}
+		fmt.Fprintf(&b, " %s=%s", k, v)
+	}
+	return b.String()
+}
+
+// sources of changes
+const (
+	sourceAPI        = "api"
+	sourceTimer      = "timer"
+	sourceAlarm      = "alarm"
+	sourceButton     = "button"
+	sourceMotion     = "motion"
+	sourceLight      = "light_sensor"
+	sourceThermostat = "thermostat"
+	sourceRemote     = "remote"
+)
+
+// origin describes what requested a change
+type origin struct {
+	source    string
+	requestID string
+}
+
+type originKey struct{}
+
+// withOrigin returns a context carrying o
+func withOrigin(ctx context.Context, o origin) context.Context {
+	return context.WithValue(ctx, originKey{}, o)
+}
+
+// originFrom returns the origin carried by ctx, if any
+func originFrom(ctx context.Context) origin {
+	o, _ := ctx.Value(originKey{}).(origin)
+	return o
+}
+
+// newRequestID returns a random identifier for correlating the log records of a request
+func newRequestID() string {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "00000000"
+	}
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureLogging sends log records to a buffer for the duration of a test
+func captureLogging(t *testing.T, c loggingConfiguration) *bytes.Buffer {
+	var buf bytes.Buffer
+	flags := log.Flags()
+	log.SetFlags(0)
+	log.SetOutput(&buf)
+	configureLogging(&buf, c)
+	t.Cleanup(func() {
+		log.SetFlags(flags)
+		log.SetOutput(ioutil.Discard)
+		configureLogging(ioutil.Discard, defaultLogging)
+	})
+	return &buf
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for _, name := range []string{"debug", "INFO", "Warn", "error"} {
+		if _, err := parseLogLevel(name); err != nil {
+			t.Errorf("unexpected error for %v; %v", name, err)
+		}
+	}
+	if _, err := parseLogLevel("verbose"); err == nil {
+		t.Errorf("expected error for verbose")
+	}
+}
+
+func TestLoggerText(t *testing.T) {
+	buf := captureLogging(t, defaultLogging)
+	l := newLogger("plug").with(fields{"plug": "light", "on": true})
+	l.infof("set %v", "plugOne")
+	l.debugf("hidden by the default level")
+
+	expected := "INFO [plug] set plugOne on=true plug=light\n"
+	if buf.String() != expected {
+		t.Errorf("got %q; expected %q", buf.String(), expected)
+	}
+}
+
+func TestLoggerJSON(t *testing.T) {
+	buf := captureLogging(t, loggingConfiguration{format: logFormatJSON, level: levelInfo})
+	ctx := withOrigin(context.Background(), origin{source: sourceAPI, requestID: "1234abcd"})
+	newLogger("http").withContext(ctx).warnf("slow response")
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("unexpected error %v for %q", err, buf.String())
+	}
+	expected := map[string]string{"level": "warn", "subsystem": "http", "msg": "slow response", "source": "api", "request": "1234abcd"}
+	for k, v := range expected {
+		if record[k] != v {
+			t.Errorf("field %s is %v; expected %v", k, record[k], v)
+		}
+	}
+	if at, ok := logLineTime(strings.TrimSpace(buf.String())); !ok || time.Since(at) > time.Minute {
+		t.Errorf("got time %v; expected the time of the record", at)
+	}
+}
+
+func TestLoggerSubsystemLevels(t *testing.T) {
+	buf := captureLogging(t, loggingConfiguration{
+		format: logFormatText,
+		level:  levelWarn,
+		levels: map[string]logLevel{"pin": levelDebug},
+	})
+	newLogger("pin").debugf("pin d0 set true")
+	newLogger("plug").infof("set plugOne true")
+	newLogger("plug").errorf("pin error")
+
+	expected := "DEBUG [pin] pin d0 set true\nERROR [plug] pin error\n"
+	if buf.String() != expected {
+		t.Errorf("got %q; expected %q", buf.String(), expected)
+	}
+}
+
+func TestFormatFieldsQuoting(t *testing.T) {
+	if actual := formatFields(fields{"b": "two words", "a": 1}); actual != ` a=1 b="two words"` {
+		t.Errorf("got %q", actual)
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	logTimeLayout    = "2006/01/02 15:04:05" // prefix written by log.Ldate | log.Ltime
+	maxLogLineLength = 1024 * 1024
+	tailBlockSize    = 64 * 1024
+	followInterval   = 500 * time.Millisecond
+)
+
+// queryTimeLayouts are the accepted layouts for the since and until query values
+var queryTimeLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02"}
+
+// logFilter selects lines from a log file
+type logFilter struct {
+	tail    int // zero for all lines
+	since   time.Time
+	until   time.Time
+	substr  string
+	re      *regexp.Regexp
+	follow  bool
+	rotated int // number of rotated segments read before the current file; -1 for all
+}
+
+// parseQueryTime converts a query value into a time in the local time zone
+func parseQueryTime(value string) (time.Time, error) {
+	for _, layout := range queryTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("time %s has an unsupported syntax", value)
+}
+
+// parseLogFilter extracts a log filter from the query values tail, since, until, q, re, follow and rotated
+func parseLogFilter(query url.Values) (f logFilter, err error) {
+	if v := query.Get("tail"); v != "" {
+		if f.tail, err = strconv.Atoi(v); err != nil || f.tail < 0 {
+			return f, fmt.Errorf("Invalid 'tail' value '%s'", v)
+		}
+	}
+	if v := query.Get("since"); v != "" {
+		if f.since, err = parseQueryTime(v); err != nil {
+			return f, fmt.Errorf("Invalid 'since' value; %s", err)
+		}
+	}
+	if v := query.Get("until"); v != "" {
+		if f.until, err = parseQueryTime(v); err != nil {
+			return f, fmt.Errorf("Invalid 'until' value; %s", err)
+		}
+	}
+	f.substr = query.Get("q")
+	if v := query.Get("re"); v != "" {
+		if f.re, err = regexp.Compile(v); err != nil {
+			return f, fmt.Errorf("Invalid 're' value; %s", err)
+		}
+	}
+	switch v := query.Get("rotated"); v {
+	case "":
+	case "all":
+		f.rotated = -1
+	default:
+		if f.rotated, err = strconv.Atoi(v); err != nil || f.rotated < 0 {
+			return f, fmt.Errorf("Invalid 'rotated' value '%s'", v)
+		}
+	}
+	switch v := query.Get("follow"); v {
+	case "", "0", "false":
+	case "1", "true":
+		f.follow = true
+	default:
+		return f, fmt.Errorf("Invalid 'follow' value '%s'", v)
+	}
+	return f, nil
+}
+
+// timed returns true if the filter selects lines by time
+func (f logFilter) timed() bool {
+	return !f.since.IsZero() || !f.until.IsZero()
+}
+
+// searched returns true if the filter selects lines by content
+func (f logFilter) searched() bool {
+	return f.substr != "" || f.re != nil
+}
+
+// match returns true if line, written at time at, is selected by the filter
+// a zero at means that the time of the line is unknown
+func (f logFilter) match(line string, at time.Time) bool {
+	if f.timed() {
+		if at.IsZero() || (!f.since.IsZero() && at.Before(f.since)) || (!f.until.IsZero() && at.After(f.until)) {
+			return false
+		}
+	}
+	if f.substr != "" && !strings.Contains(line, f.substr) {
+		return false
+	}
+	if f.re != nil && !f.re.MatchString(line) {
+		return false
+	}
+	return true
+}
+
+// logLineTime returns the time at the start of a text log line or the time field of a JSON log line
+func logLineTime(line string) (time.Time, bool) {
+	if strings.HasPrefix(line, "{") {
+		var record struct {
+			Time time.Time `json:"time"`
+		}
+		if err := json.Unmarshal([]byte(line), &record); err != nil || record.Time.IsZero() {
+			return time.Time{}, false
+		}
+		return record.Time, true
+	}
+	if len(line) < len(logTimeLayout) {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(logTimeLayout, line[:len(logTimeLayout)], time.Local)
+	return t, err == nil
+}
+
+// logScanner reads lines from a log, tracking the time of the most recent timestamped line
+type logScanner struct {
+	*bufio.Scanner
+	at time.Time
+}
+
+func newLogScanner(r io.Reader) *logScanner {
+	s := &logScanner{Scanner: bufio.NewScanner(r)}
+	s.Buffer(make([]byte, 0, 64*1024), maxLogLineLength)
+	return s
+}
+
+// scan advances to the next line; lines without a timestamp are given the time of the line before
+func (s *logScanner) scan() bool {
+	if !s.Scan() {
+		return false
+	}
+	if t, ok := logLineTime(s.Text()); ok {
+		s.at = t
+	}
+	return true
+}
+
+// filterLog writes the lines of r selected by f to w
+// when tailing, only the last f.tail selected lines are held in memory
+func filterLog(r io.Reader, w io.Writer, f logFilter) error {
+	s := newLogScanner(r)
+	var ring []string
+	next := 0
+	for s.scan() {
+		line := s.Text()
+		if !f.match(line, s.at) {
+			continue
+		}
+		if f.tail == 0 {
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+			continue
+		}
+		if len(ring) < f.tail {
+			ring = append(ring, line)
+		} else {
+			ring[next] = line
+			next = (next + 1) % f.tail
+		}
+	}
+	for i := range ring {
+		if _, err := fmt.Fprintln(w, ring[(next+i)%len(ring)]); err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
+
+// seekTail positions f at the start of the last n lines by reading backwards from the end
+func seekTail(f io.ReadSeeker, n int) error {
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	offset := end
+	newlines := 0
+	buf := make([]byte, tailBlockSize)
+	for offset > 0 {
+		size := int64(len(buf))
+		if offset < size {
+			size = offset
+		}
+		offset -= size
+		if _, err = f.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err = io.ReadFull(f, buf[:size]); err != nil {
+			return err
+		}
+		for i := size - 1; i >= 0; i-- {
+			if buf[i] != '\n' || offset+i == end-1 {
+				continue // the newline that ends the final line doesn't start a new line
+			}
+			newlines++
+			if newlines == n {
+				_, err = f.Seek(offset+i+1, io.SeekStart)
+				return err
+			}
+		}
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	return err
+}
+
+// followLog writes lines selected by f as they are appended to the file at path, starting at offset
+// it returns when stop is closed; a truncated or replaced file is read again from the start
+func followLog(path string, offset int64, w io.Writer, flush func(), f logFilter, stop <-chan struct{}) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { file.Close() }()
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	var partial []byte
+	var at time.Time
+	// emit writes the complete lines read so far, holding back any partial line
+	emit := func(data []byte) error {
+		partial = append(partial, data...)
+		for {
+			i := bytes.IndexByte(partial, '\n')
+			if i < 0 {
+				return nil
+			}
+			line := string(partial[:i])
+			partial = partial[i+1:]
+			if t, ok := logLineTime(line); ok {
+				at = t
+			}
+			if f.match(line, at) {
+				if _, err := fmt.Fprintln(w, line); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	buf := make([]byte, tailBlockSize)
+	ticker := time.NewTicker(followInterval)
+	defer ticker.Stop()
+	for {
+		n, err := file.Read(buf)
+		if n > 0 {
+			if err = emit(buf[:n]); err != nil {
+				return err
+			}
+			flush()
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+
+		// start again if the file has been truncated or replaced e.g. by rotation
+		current, statErr := os.Stat(path)
+		opened, openedErr := file.Stat()
+		position, _ := file.Seek(0, io.SeekCurrent)
+		if statErr != nil || openedErr != nil {
+			continue
+		}
+		if !os.SameFile(current, opened) || current.Size() < position {
+			replacement, err := os.Open(path)
+			if err != nil {
+				continue
+			}
+			// finish the replaced file first
+			if rest, err := ioutil.ReadAll(file); err == nil && len(rest) > 0 {
+				if err = emit(rest); err != nil {
+					replacement.Close()
+					return err
+				}
+				flush()
+			}
+			file.Close()
+			file = replacement
+			partial = partial[:0]
+		}
+	}
+}
+
+// logViewerHandlerFunc returns a handler function that outputs the log file at path
+// the query values select lines; see parseLogFilter
+func logViewerHandlerFunc(path string) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		disableCache(w)
+		if path == "" {
+			respond(w, "Logging to stdout; there is no log file", http.StatusNotFound)
+			return
+		}
+		f, err := parseLogFilter(r.URL.Query())
+		if err != nil {
+			respond(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if f.follow && !ok {
+			respond(w, "Streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			respond(w, fmt.Sprintf("file at \"%s\": %s", path, err), http.StatusInternalServerError)
+			return
+		}
+		defer file.Close()
+
+		// include rotated segments, oldest first
+		segments := rotatedSegments(path)
+		if f.rotated >= 0 && f.rotated < len(segments) {
+			segments = segments[:f.rotated]
+		}
+		var readers []io.Reader
+		for i := len(segments) - 1; i >= 0; i-- {
+			segment, err := openSegment(segments[i])
+			if err != nil {
+				respond(w, fmt.Sprintf("file at \"%s\": %s", segments[i], err), http.StatusInternalServerError)
+				return
+			}
+			defer segment.Close()
+			readers = append(readers, segment)
+		}
+		readers = append(readers, file)
+
+		// a plain tail of the current file doesn't need to read the whole file
+		if f.tail > 0 && !f.timed() && !f.searched() && len(readers) == 1 {
+			if err = seekTail(file, f.tail); err != nil {
+				respond(w, fmt.Sprintf("file at \"%s\": %s", path, err), http.StatusInternalServerError)
+				return
+			}
+			f.tail = 0
+		}
+
+		// don't use respond as this will write the file to the logfile
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err = filterLog(io.MultiReader(readers...), w, f); err != nil || !f.follow {
+			return
+		}
+		offset, err := file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+		f.tail = 0
+		followLog(path, offset, w, flusher.Flush, f, r.Context().Done())
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testLog = `2018/06/01 10:00:00 main.go:1: starting Heihei
+2018/06/01 10:05:00 plug.go:2: set plugOne true
+2018/06/01 11:00:00 plug.go:2: set plugOne false
+continuation of the previous line
+2018/06/02 09:00:00 alarm.go:3: alarm set
+2018/06/02 09:30:00 plug.go:2: set plugTwo true
+`
+
+func TestParseLogFilterError(t *testing.T) {
+	testCases := []string{"tail=-1", "tail=x", "since=yesterday", "until=2018-13-01", "re=(", "follow=maybe"}
+	for _, tc := range testCases {
+		t.Run(tc, func(t *testing.T) {
+			query, _ := url.ParseQuery(tc)
+			if _, err := parseLogFilter(query); err == nil {
+				t.Errorf("expected error for %v; but got none", tc)
+			}
+		})
+	}
+}
+
+func TestFilterLog(t *testing.T) {
+	testCases := []struct {
+		query    string
+		expected []string
+	}{
+		{"", strings.Split(strings.TrimSpace(testLog), "\n")},
+		{"tail=2", []string{"2018/06/02 09:00:00 alarm.go:3: alarm set", "2018/06/02 09:30:00 plug.go:2: set plugTwo true"}},
+		{"q=plugOne", []string{"2018/06/01 10:05:00 plug.go:2: set plugOne true", "2018/06/01 11:00:00 plug.go:2: set plugOne false"}},
+		{"re=plug(One|Two)%20true&tail=1", []string{"2018/06/02 09:30:00 plug.go:2: set plugTwo true"}},
+		{"since=2018-06-01T10:30&until=2018-06-02", []string{"2018/06/01 11:00:00 plug.go:2: set plugOne false", "continuation of the previous line"}},
+		{"q=nothing", nil},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("query %v", tc.query), func(t *testing.T) {
+			query, _ := url.ParseQuery(tc.query)
+			f, err := parseLogFilter(query)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			var out bytes.Buffer
+			if err = filterLog(strings.NewReader(testLog), &out, f); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			var actual []string
+			if out.Len() > 0 {
+				actual = strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+			}
+			if fmt.Sprint(actual) != fmt.Sprint(tc.expected) {
+				t.Errorf("got %q; expected %q", actual, tc.expected)
+			}
+		})
+	}
+}
+
+func TestSeekTail(t *testing.T) {
+	// use lines longer than a block to cross block boundaries
+	long := strings.Repeat("x", tailBlockSize)
+	content := fmt.Sprintf("one\n%s\nthree\nfour\n", long)
+	testCases := []struct {
+		n        int
+		expected string
+	}{
+		{1, "four\n"},
+		{2, "three\nfour\n"},
+		{3, long + "\nthree\nfour\n"},
+		{10, content},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("tail %d", tc.n), func(t *testing.T) {
+			r := strings.NewReader(content)
+			if err := seekTail(r, tc.n); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			rest, _ := ioutil.ReadAll(r)
+			if string(rest) != tc.expected {
+				t.Errorf("got %d bytes; expected %d bytes", len(rest), len(tc.expected))
+			}
+		})
+	}
+}
+
+func TestLogViewerHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	logViewerHandlerFunc("")(w, httptest.NewRequest("GET", "/logfile", nil))
+	if w.Code != 404 {
+		t.Errorf("got status %v; expected 404 when logging to stdout", w.Code)
+	}
+
+	dir, err := ioutil.TempDir("", "heihei")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, logFilename)
+	if err = ioutil.WriteFile(path, []byte(testLog), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w = httptest.NewRecorder()
+	logViewerHandlerFunc(path)(w, httptest.NewRequest("GET", "/logfile?tail=1", nil))
+	if w.Code != 200 || w.Body.String() != "2018/06/02 09:30:00 plug.go:2: set plugTwo true\n" {
+		t.Errorf("got status %v and body %q; expected the last line", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	logViewerHandlerFunc(path)(w, httptest.NewRequest("GET", "/logfile?re=(", nil))
+	if w.Code != 422 {
+		t.Errorf("got status %v; expected 422", w.Code)
+	}
+}
+
+func TestFollowLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heihei")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, logFilename)
+	if err = ioutil.WriteFile(path, []byte(testLog), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := make(chan string, 10)
+	stop := make(chan struct{})
+	done := make(chan error)
+	w := writerFunc(func(p []byte) (int, error) {
+		lines <- string(p)
+		return len(p), nil
+	})
+	go func() {
+		done <- followLog(path, int64(len(testLog)), w, func() {}, logFilter{substr: "plug"}, stop)
+	}()
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintln(file, "2018/06/02 10:00:00 alarm.go:3: alarm unset")
+	fmt.Fprintln(file, "2018/06/02 10:01:00 plug.go:2: set plugTwo false")
+	file.Close()
+
+	if line := <-lines; line != "2018/06/02 10:01:00 plug.go:2: set plugTwo false\n" {
+		t.Errorf("got %q; expected the appended plug line", line)
+	}
+	close(stop)
+	if err = <-done; err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+// writerFunc adapts a function to an io.Writer
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -25,9 +27,11 @@ func init() {
 
 // plugInterface defines an interface for a RF plug
 type plugInterface interface {
-	set(bool)
-	setForDuration(bool, time.Duration)
+	set(context.Context, bool)
+	setForDuration(context.Context, bool, time.Duration)
 	state() bool
+	overrideUntil() time.Time
+	mirror(context.Context, bool)
 }
 
 // alarmInterface defines an interface for an alarm
@@ -41,9 +45,12 @@ func disableCache(w http.ResponseWriter) {
 	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
 }
 
+// httpLog is the logger for the http subsystem
+var httpLog = newLogger("http")
+
 // respond writes the http response and logs the action
 func respond(w http.ResponseWriter, msg string, code int) {
-	log.Printf("Response [%v] %v\n", code, msg)
+	httpLog.debugf("Response [%v] %v", code, msg)
 	if code == http.StatusOK {
 		fmt.Fprintln(w, msg)
 	} else {
@@ -51,28 +58,15 @@ func respond(w http.ResponseWriter, msg string, code int) {
 	}
 }
 
-// about reports about the server
-func aboutHandlerFunc(l plugInterface, a alarmInterface, config configuration) func(http.ResponseWriter, *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		disableCache(w)
-		fmt.Fprintf(w, "Heihei: version %2d\n", version)
-		latitude, longitude := config.latLong()
-		fmt.Fprintf(w, "        at (%v, %v)\n", latitude, longitude)
-		fmt.Fprintf(w, "        light is %v\n", l.state())
-		fmt.Fprintf(w, "        alarm is %v\n", a.isSet())
-		fmt.Fprintf(w, "        build type %s\n", buildType)
-	}
-}
-
 // sunsetFormatter is a utility function to format a sunset
 func sunsetFormatter(when string, sunset time.Time) string {
 	return fmt.Sprintf("%s %s", when, sunset.Format("(Monday 2 January 2006) sunset is approximately at 15:04:05 MST"))
 }
 
 // sunsetHandlerFunc returns a function that reports the time of sunset dependant on the device config
-func sunsetHandlerFunc(config configuration) func(http.ResponseWriter, *http.Request) {
+func sunsetHandlerFunc(store *configStore) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		latitude, longitude := config.latLong()
+		latitude, longitude := store.get().latLong()
 		var err error
 		yesterday, err := sunset(latitude, longitude, -1)
 		if err != nil {
@@ -117,17 +111,17 @@ func getDuration(r *http.Request) (d time.Duration) {
 
 // plugModeHandler deals with light requests when the mode is known
 func plugModeHandler(w http.ResponseWriter, r *http.Request, on bool, p plugInterface) {
-	log.Printf("mode = %v", on)
+	httpLog.withContext(r.Context()).debugf("mode = %v", on)
 	msg := "off"
 	if on {
 		msg = "on"
 	}
 	if d := getDuration(r); d > 0 {
 		respond(w, fmt.Sprintf("%v for %v", d, msg), http.StatusOK)
-		p.setForDuration(on, d)
+		p.setForDuration(r.Context(), on, d)
 	} else {
 		respond(w, msg, http.StatusOK)
-		p.set(on)
+		p.set(r.Context(), on)
 	}
 }
 
@@ -181,35 +175,40 @@ func alarmHandlerFunc(a alarmInterface) func(http.ResponseWriter, *http.Request)
 	}
 }
 
-// notifyHandler sets a notification
-func notifyHandler(w http.ResponseWriter, r *http.Request) {
-	disableCache(w)
+// notifyHandlerFunc returns a handler function that sets a notification; fired notifications are published on bus
+func notifyHandlerFunc(bus *eventBus) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		disableCache(w)
 
-	query, ok := r.URL.Query()["time"]
-	if !ok || len(query) < 1 {
-		respond(w, "Missing 'time' value", http.StatusUnprocessableEntity)
-		return
-	}
+		query, ok := r.URL.Query()["time"]
+		if !ok || len(query) < 1 {
+			respond(w, "Missing 'time' value", http.StatusUnprocessableEntity)
+			return
+		}
 
-	hour, minute, err := decodeClock(query[0])
-	if err != nil {
-		respond(w, "Invalid 'time' value", http.StatusUnprocessableEntity)
-		return
-	}
+		hour, minute, err := decodeClock(query[0])
+		if err != nil {
+			respond(w, "Invalid 'time' value", http.StatusUnprocessableEntity)
+			return
+		}
 
-	timer, err := newNotification(nextTime(time.Now(), hour, minute))
-	if err != nil {
-		respond(w, "Notification error", http.StatusInternalServerError)
-		return
-	}
+		timer, err := newNotification(nextTime(time.Now(), hour, minute))
+		if err != nil {
+			respond(w, "Notification error", http.StatusInternalServerError)
+			return
+		}
 
-	go func() {
-		<-timer.C
-		log.Println("notification fired")
-	}()
+		notificationsSetTotal.inc()
+		go func() {
+			<-timer.C
+			notificationsFiredTotal.inc()
+			newLogger("notification").infof("notification fired")
+			bus.publish(event{Type: eventNotification, Message: query[0]})
+		}()
 
-	respond(w, "Notification set", http.StatusOK)
-	return
+		respond(w, "Notification set", http.StatusOK)
+		return
+	}
 }
 
 // fileHandlerFunc outputs the file with path to the browser
@@ -219,6 +218,7 @@ func fileHandlerFunc(path string) func(http.ResponseWriter, *http.Request) {
 		content, err := ioutil.ReadFile(path)
 		if err != nil {
 			respond(w, fmt.Sprintf("file at \"%s\": %s", path, err), http.StatusInternalServerError)
+			return
 		}
 		// don't use respond as this will write file to the logfile
 		fmt.Fprintf(w, "%s", content)
@@ -227,71 +227,215 @@ func fileHandlerFunc(path string) func(http.ResponseWriter, *http.Request) {
 }
 
 // logHandler is a http handler wrapper for logging
+// each request is given an id that is carried in its context as an API origin
 func logHandler(h http.Handler) http.HandlerFunc {
 	return http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
-			log.Printf("-> %v: from %v\n", r.URL.Path, r.RemoteAddr)
+			o := origin{source: sourceAPI, requestID: newRequestID()}
+			r = r.WithContext(withOrigin(r.Context(), o))
+			l := httpLog.withContext(r.Context()).with(fields{"path": r.URL.Path})
+			l.debugf("-> %v: from %v", r.URL.Path, r.RemoteAddr)
 			h.ServeHTTP(w, r) // call original
-			log.Printf("<- %v\n", r.URL.Path)
+			l.debugf("<- %v", r.URL.Path)
 		})
 }
 
 func main() {
 	var err error
 
-	// get the directory of the executable
+	// parse the command line
+	var listenFlags listenFlag
+	flag.Var(&listenFlags, "listen", "address to serve on e.g. http://:8000 or https://:8443; may be repeated and overrides the configuration")
+	configFlag := flag.String("config", "", "path of the configuration file in JSON, YAML or TOML format; defaults to $"+configEnvironmentVariable+" or "+configFilename+" beside the executable")
+	adminSocket := flag.String("admin-socket", "", "path of a Unix domain socket serving admin endpoints; overrides the configuration")
+	flag.Parse()
+
+	// a subcommand runs instead of the server
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(flag.Args(), os.Stdout, os.Stderr))
+	}
+
+	// get the directory of the executable, where the log files, sensor history and generated certificate are kept
 	ex, err := os.Executable()
 	if err != nil {
 		panic(err)
 	}
 	path := filepath.Dir(ex)
 
-	// load the configuration
-	configFilePath := filepath.Join(path, configFilename)
-	configFile, err := os.Open(configFilePath)
+	// load the configuration; the TLS files it refers to by relative path are found in its directory
+	configFilePath, err := configPath(*configFlag)
 	if err != nil {
 		panic(err)
 	}
-
-	config, err := getConfiguration(configFile)
+	config, err := loadConfiguration(configFilePath, os.Environ())
 	if err != nil {
 		panic(err)
 	}
+	configDir := filepath.Dir(configFilePath)
+
+	// addresses on the command line replace those in the configuration, including reloaded configuration
+	applyFlags := func(c *configuration) {
+		if len(listenFlags) > 0 {
+			c.listen = nil
+			for _, l := range listenFlags {
+				a, _ := parseListenAddress(l) // syntax already checked by the flag package
+				c.listen = append(c.listen, a)
+			}
+		}
+		if *adminSocket != "" {
+			c.adminSocket = *adminSocket
+		}
+	}
+	applyFlags(&config)
+	if err = config.checkListen(); err != nil {
+		panic(err)
+	}
+	store := &configStore{config: config, adjust: applyFlags}
 
 	// initialise logging
-	logfile := os.Stdout
+	var logfile io.WriteCloser = os.Stdout
 	var logFilePath string
+	var logFileErr error
 	if !config.logToStdout {
 		logFilePath = filepath.Join(path, logFilename)
-		logfile, err = os.OpenFile(logFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-		if err != nil {
+		if logfile, logFileErr = openRotatingFile(logFilePath, config.logRotation); logFileErr != nil {
 			logfile = os.Stdout
 		}
 	}
 	defer logfile.Close()
 	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
 	log.SetOutput(logfile)
-
-	log.Printf("***************\n")
-	log.Printf("starting Heihei\n")
+	configureLogging(logfile, config.logging)
+	store.onChange(func(c configuration) { configureLogging(logfile, c.logging) })
+
+	mainLog := newLogger("main")
+	mainLog.infof("***************")
+	mainLog.infof("starting Heihei")
+	if logFileErr != nil {
+		mainLog.errorf("log file %s unavailable, logging to stdout; %v", logFilePath, logFileErr)
+		logFilePath = ""
+	}
+	logMigrations(configFilePath, config.migrated)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// start the light controller
-	lightOne := newPlug(ctx, plugOne)
+	// create the bus for state changes
+	bus := newEventBus(ctx)
+
+	// start the plug controllers; /light controls the plug named light or else the first plug
+	var plugs []namedPlug
+	for _, c := range config.plugs {
+		plugs = append(plugs, namedPlug{name: c.name, plugInterface: newPlug(ctx, c.name, c.id, bus)})
+	}
+	// devices replaying learned codes are controlled like the plugs
+	plugs = append(plugs, newLearnedPlugs(ctx, store, bus)...)
+	lightOne, ok := findPlug(plugs, "light")
+	if !ok {
+		lightOne = plugs[0].plugInterface
+	}
+	watchConfiguration(ctx, configFilePath, store, configWatchInterval)
 
 	// create an alarm
-	alarmOne := newAlarm(ctx, time.Minute)
+	alarmOne := newAlarm(ctx, time.Minute, bus)
+
+	// watch the push buttons
+	startButtons(ctx, config.buttons, plugs, bus)
+
+	// mirror the presses of the plug remotes and learn the codes of other remotes
+	learn := &learner{}
+	startReceiver(ctx, store, remoteFunc(ctx, store, plugs), learn)
 
-	// register the handlers and listen
+	// watch the motion sensors
+	motion := newMotionMonitor(config.motionSensors)
+	startMotionSensors(ctx, store, plugs, motion, bus)
+
+	// switch plugs by the ambient light
+	var readLux func() (float64, error)
+	if config.light != nil {
+		readLux = luxReader(*config.light)
+	}
+	light := newLightMonitor(ctx, store, plugs, readLux)
+
+	// read the sensors, keeping their readings in memory and on disk
+	sensors := newSensorMonitor(config.sensors, config.sensorHistory.samples)
+	if len(config.sensors) > 0 {
+		historyFile, err := openSensorHistory(sensors, resolvePath(path, config.sensorHistory.path), config.logRotation)
+		if err != nil {
+			mainLog.errorf("sensor history not kept on disk; %v", err)
+		} else {
+			defer historyFile.Close()
+		}
+		startSensors(ctx, sensors, openSensor)
+	}
+
+	// hold the temperature with the thermostat plug
+	heater := newThermostat(ctx, store, plugs, bus, readDS18B20)
+
+	// show the state of the server on the status LED
+	startStatusLED(ctx, store, bus, openStatusLED, pinsHealthy)
+
+	// show the state of the server on the status display
+	startDisplay(ctx, store, plugs, alarmOne, openDisplay)
+
+	// monitor the temperature of the thermal zones
+	thermal := newThermalMonitor(ctx, store, bus, readThermalZones)
+
+	// register the handlers
 	mux := http.NewServeMux()
-	mux.HandleFunc("/about", aboutHandlerFunc(lightOne, alarmOne, config))
+	mux.HandleFunc("/about", aboutHandlerFunc(lightOne, alarmOne, thermal, store, configFilePath, logFilePath))
 	mux.HandleFunc("/light", plugHandlerFunc(lightOne))
+	for _, p := range plugs {
+		mux.HandleFunc("/plug/"+p.name, plugHandlerFunc(p))
+	}
 	mux.HandleFunc("/alarm", alarmHandlerFunc(alarmOne))
-	mux.HandleFunc("/sunset", sunsetHandlerFunc(config))
-	mux.HandleFunc("/notify", notifyHandler)
-	mux.HandleFunc("/logfile", fileHandlerFunc(logFilePath))
-	mux.HandleFunc("/config", fileHandlerFunc(configFilePath))
-	log.Fatal(http.ListenAndServe(":8000", logHandler(mux)))
+	mux.HandleFunc("/sunset", sunsetHandlerFunc(store))
+	mux.HandleFunc("/notify", notifyHandlerFunc(bus))
+	mux.HandleFunc("/events", eventsHandlerFunc(bus))
+	mux.HandleFunc("/ws", websocketHandlerFunc(bus))
+	mux.HandleFunc("/api/v1/status", statusHandlerFunc(plugs, alarmOne, monitors{thermal: thermal, motion: motion, light: light, thermostat: heater}, store))
+	mux.HandleFunc(sensorsPath, sensorsHandlerFunc(sensors))
+	mux.HandleFunc(sensorsPath+"/", sensorsHandlerFunc(sensors))
+	mux.HandleFunc("/metrics", metricsHandlerFunc(plugs, sensors))
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/readyz", readyzHandler)
+	mux.HandleFunc("/", dashboardHandler)
+
+	// admin endpoints are kept off the network when an admin socket is available
+	adminMux := mux
+	if config.adminSocket != "" {
+		adminMux = http.NewServeMux()
+		adminMux.HandleFunc("/reload", reloadHandlerFunc(configFilePath, store))
+	}
+	adminMux.HandleFunc("/logfile", logViewerHandlerFunc(logFilePath))
+	adminMux.HandleFunc("/config", fileHandlerFunc(configFilePath))
+	// the configuration file is only rewritten by clients that are trusted
+	configHandler := configHandlerFunc(configFilePath, store)
+	learnHandler := learnHandlerFunc(configFilePath, store, learn)
+	if config.adminSocket == "" && !networkWritesAllowed(config) {
+		mainLog.infof("configuration changes refused; set admin_socket or require client certificates to allow them")
+		configHandler = readOnlyHandlerFunc(configHandler)
+		learnHandler = readOnlyHandlerFunc(learnHandler)
+	}
+	adminMux.HandleFunc("/api/v1/config", configHandler)
+	adminMux.HandleFunc("/api/v1/learn", learnHandler)
+	if config.adminSocket != "" {
+		go func() {
+			mainLog.fatalf("admin socket failed; %v", serveAdmin(config.adminSocket, logHandler(metricsHandler(adminMux))))
+		}()
+	}
+
+	// requests from the network are recorded in the access log
+	handler := http.Handler(logHandler(metricsHandler(mux)))
+	if config.accessLog.path != "" {
+		accessFile, err := openRotatingFile(resolvePath(path, config.accessLog.path), config.logRotation)
+		if err != nil {
+			mainLog.fatalf("access log failed; %v", err)
+		}
+		defer accessFile.Close()
+		handler = accessLogHandler(handler, accessFile, config.accessLog.format)
+	}
+
+	// listen
+	mainLog.fatalf("listener failed; %v", serve(config.listenAddresses(), config, configDir, path, handler))
 }
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// labelSeparator joins label values into a key; it can't appear in valid UTF-8 text
+const labelSeparator = "\xff"
+
+// metricsContentType is the media type of the Prometheus text exposition format
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// startTime is when the process started
+var startTime = time.Now()
+
+// transmitBuckets are the upper bounds in seconds of the transmit duration histogram
+// a transmit holds the modulator on for 350ms so shorter buckets would always be empty
+var transmitBuckets = []float64{0.35, 0.4, 0.5, 0.75, 1, 2.5, 5}
+
+// counters and histograms updated as the server runs
+var (
+	transmitsTotal = newCounterVec("heihei_plug_transmits_total",
+		"Transmissions to the plugs.", "plug")
+	transmitErrorsTotal = newCounterVec("heihei_plug_transmit_errors_total",
+		"Transmissions to the plugs that had a pin error.", "plug")
+	transmitDuration = newHistogramVec("heihei_plug_transmit_duration_seconds",
+		"Time taken to transmit to the plugs, including waiting for the pins.", transmitBuckets, "plug")
+	httpRequestsTotal = newCounterVec("heihei_http_requests_total",
+		"HTTP requests by handler path and status code.", "path", "code")
+	alarmSetsTotal = newCounterVec("heihei_alarm_sets_total",
+		"Changes to the alarm by new state.", "state")
+	alarmRingsTotal = newCounterVec("heihei_alarm_rings_total",
+		"Rings of the alarm.")
+	notificationsSetTotal = newCounterVec("heihei_notifications_set_total",
+		"Notifications set.")
+	notificationsFiredTotal = newCounterVec("heihei_notifications_fired_total",
+		"Notifications fired.")
+)
+
+// counterVec is a counter for each combination of label values
+type counterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64 // keyed by the label values joined with labelSeparator
+}
+
+// newCounterVec creates a counter with the given label names
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+// inc adds one to the counter with the given label values
+func (c *counterVec) inc(values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[strings.Join(values, labelSeparator)]++
+}
+
+// write writes the counter in the text exposition format
+// a counter without labels is written as zero before it is first incremented
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeHeader(w, c.name, c.help, "counter")
+	if len(c.labels) == 0 {
+		fmt.Fprintf(w, "%s %s\n", c.name, formatValue(c.values[""]))
+		return
+	}
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, strings.Split(key, labelSeparator)), formatValue(c.values[key]))
+	}
+}
+
+// histogramVec is a histogram for each combination of label values
+type histogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+// histogramSeries holds the observations for one combination of label values
+type histogramSeries struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// newHistogramVec creates a histogram with the given bucket upper bounds, which must be in increasing order
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogramSeries)}
+}
+
+// observe records v for the given label values
+func (h *histogramVec) observe(v float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := strings.Join(values, labelSeparator)
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.sum += v
+	s.count++
+}
+
+// write writes the histogram in the text exposition format
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	writeHeader(w, h.name, h.help, "histogram")
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		var values []string
+		if len(h.labels) > 0 {
+			values = strings.Split(key, labelSeparator)
+		}
+		labels := append(append([]string(nil), h.labels...), "le")
+		bucketValues := append(append([]string(nil), values...), "")
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			bucketValues[len(values)] = formatValue(bound)
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(labels, bucketValues), cumulative)
+		}
+		bucketValues[len(values)] = "+Inf"
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(labels, bucketValues), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, values), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, values), s.count)
+	}
+}
+
+// gaugeSample is a single value of a gauge
+type gaugeSample struct {
+	values []string
+	value  float64
+}
+
+// writeGauge writes a gauge whose samples are found when metrics are requested
+func writeGauge(w io.Writer, name, help string, labels []string, samples []gaugeSample) {
+	writeHeader(w, name, help, "gauge")
+	for _, s := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(labels, s.values), formatValue(s.value))
+	}
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// formatLabels returns the label set {name="value",...} or nothing if there are no labels
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, escaper.Replace(values[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// boolValue returns 1 for true and 0 for false
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// observeTransmit records a transmission to the named plug
+func observeTransmit(name string, d time.Duration, err error) {
+	transmitsTotal.inc(name)
+	if err != nil {
+		transmitErrorsTotal.inc(name)
+	}
+	transmitDuration.observe(d.Seconds(), name)
+}
+
+// metricsHandler is a http handler wrapper that counts requests by the mux pattern that handles them
+// patterns are used rather than paths so that requests for unknown paths can't create unlimited series
+func metricsHandler(mux *http.ServeMux) http.HandlerFunc {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			recorder := &responseRecorder{ResponseWriter: w}
+			mux.ServeHTTP(recorder, r) // call original
+			_, pattern := mux.Handler(r)
+			httpRequestsTotal.inc(pattern, strconv.Itoa(recorder.statusCode()))
+		})
+}
+
+// metricsHandlerFunc returns a handler function that writes the metrics in the Prometheus text format
+func metricsHandlerFunc(plugs []namedPlug, sensors *sensorMonitor) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		disableCache(w)
+		w.Header().Set("Content-Type", metricsContentType)
+		now := time.Now()
+
+		var states []gaugeSample
+		for _, p := range plugs {
+			states = append(states, gaugeSample{values: []string{p.name}, value: boolValue(p.state())})
+		}
+		writeGauge(w, "heihei_plug_on", "Whether the plug is on.", []string{"plug"}, states)
+		transmitsTotal.write(w)
+		transmitErrorsTotal.write(w)
+		transmitDuration.write(w)
+		httpRequestsTotal.write(w)
+		alarmSetsTotal.write(w)
+		alarmRingsTotal.write(w)
+		notificationsSetTotal.write(w)
+		notificationsFiredTotal.write(w)
+
+		sensors.writeMetrics(w)
+
+		if celsius, err := cpuTemperature(); err == nil {
+			writeGauge(w, "heihei_cpu_temperature_celsius", "Temperature of the CPU.", nil, []gaugeSample{{value: celsius}})
+		}
+		writeGauge(w, "heihei_process_start_time_seconds", "Start time of the process in seconds since the epoch.", nil,
+			[]gaugeSample{{value: float64(startTime.Unix())}})
+		writeGauge(w, "heihei_process_uptime_seconds", "Time since the process started.", nil,
+			[]gaugeSample{{value: now.Sub(startTime).Seconds()}})
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCounterVec(t *testing.T) {
+	c := newCounterVec("test_total", "Test counter.", "path", "code")
+	c.inc("/b", "200")
+	c.inc("/a", "404")
+	c.inc("/b", "200")
+	c.inc(`/"q"`, "200")
+
+	var buf bytes.Buffer
+	c.write(&buf)
+	expected := `# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{path="/\"q\"",code="200"} 1
+test_total{path="/a",code="404"} 1
+test_total{path="/b",code="200"} 2
+`
+	if buf.String() != expected {
+		t.Errorf("got\n%s\nexpected\n%s", buf.String(), expected)
+	}
+
+	// a counter without labels is always present
+	buf.Reset()
+	newCounterVec("unset_total", "Unset counter.").write(&buf)
+	if !strings.HasSuffix(buf.String(), "\nunset_total 0\n") {
+		t.Errorf("got %q; expected a zero value", buf.String())
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	h := newHistogramVec("test_seconds", "Test histogram.", []float64{0.5, 1}, "plug")
+	h.observe(0.25, "light")
+	h.observe(0.5, "light")
+	h.observe(0.75, "light")
+	h.observe(2, "light")
+
+	var buf bytes.Buffer
+	h.write(&buf)
+	expected := `# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{plug="light",le="0.5"} 2
+test_seconds_bucket{plug="light",le="1"} 3
+test_seconds_bucket{plug="light",le="+Inf"} 4
+test_seconds_sum{plug="light"} 3.5
+test_seconds_count{plug="light"} 4
+`
+	if buf.String() != expected {
+		t.Errorf("got\n%s\nexpected\n%s", buf.String(), expected)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/plug/light", func(w http.ResponseWriter, r *http.Request) {})
+	handler := metricsHandler(mux)
+	before := httpRequestsTotal.values["/plug/light"+labelSeparator+"200"]
+	handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/plug/light?mode=on", nil))
+	handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/unknown/1", nil))
+	if got := httpRequestsTotal.values["/plug/light"+labelSeparator+"200"]; got != before+1 {
+		t.Errorf("got %v requests; expected %v", got, before+1)
+	}
+	if httpRequestsTotal.values[labelSeparator+"404"] < 1 {
+		t.Errorf("expected unknown paths to be counted without a path")
+	}
+}
+
+func TestMetricsHandlerFunc(t *testing.T) {
+	observeTransmit("fan", 400*time.Millisecond, errors.New("pin error"))
+	plugs := []namedPlug{{name: "light", plugInterface: &fakePlug{on: true}}, {name: "fan", plugInterface: &fakePlug{}}}
+	handler := metricsHandlerFunc(plugs, nil)
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); ct != metricsContentType {
+		t.Errorf("content type %v; expected %v", ct, metricsContentType)
+	}
+	body := w.Body.String()
+	for _, expected := range []string{
+		"heihei_plug_on{plug=\"light\"} 1\n",
+		"heihei_plug_on{plug=\"fan\"} 0\n",
+		"heihei_plug_transmit_errors_total{plug=\"fan\"} ",
+		"heihei_plug_transmit_duration_seconds_bucket{plug=\"fan\",le=\"0.4\"} ",
+		"heihei_alarm_rings_total ",
+		"# TYPE heihei_process_uptime_seconds gauge\n",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("metrics missing %q", expected)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// configVersion is the version of the configuration schema read by this build
+// configuration without a config_version is version 1
+const configVersion = 2
+
+// configMigration upgrades configuration from one version of the schema to the next
+// migrate returns true if it changed the configuration; keys are the top level keys that it removes
+type configMigration struct {
+	description string
+	keys        []string
+	migrate     func(tree map[string]interface{}) (bool, error)
+}
+
+// configMigrations are in version order; the first upgrades version 1 to version 2
+var configMigrations = []configMigration{
+	{"log_to_stdout moved to logging.output", []string{"log_to_stdout"}, migrateLogToStdout},
+}
+
+// migrateLogToStdout replaces the log_to_stdout flag with the output setting of logging
+func migrateLogToStdout(tree map[string]interface{}) (bool, error) {
+	value, found := tree["log_to_stdout"]
+	if !found {
+		return false, nil
+	}
+	delete(tree, "log_to_stdout")
+	toStdout, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("log_to_stdout should be a boolean; not %v", value)
+	}
+	logging, ok := tree["logging"].(map[string]interface{})
+	if !ok {
+		logging = map[string]interface{}{}
+		tree["logging"] = logging
+	}
+	if _, found = logging["output"]; !found && toStdout {
+		logging["output"] = logOutputStdout
+	}
+	return true, nil
+}
+
+// wholeNumber returns v as an int if it's a whole number
+// JSON numbers are decoded as float64 and YAML and TOML integers as int64
+func wholeNumber(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case float64:
+		return int(n), n == float64(int(n))
+	case int64:
+		return int(n), true
+	case int:
+		return n, true
+	}
+	return 0, false
+}
+
+// migrateTree upgrades the decoded configuration in tree to the current version
+// it returns the version tree had and descriptions of the migrations that changed it
+func migrateTree(tree map[string]interface{}) (from int, migrated []string, err error) {
+	from = 1
+	if v, found := tree["config_version"]; found {
+		var ok bool
+		if from, ok = wholeNumber(v); !ok || from < 1 {
+			return 0, nil, fmt.Errorf("Config version should be a whole number from 1; not %v", v)
+		}
+	}
+	if from > configVersion {
+		return 0, nil, fmt.Errorf("Config version %d is newer than the supported version %d", from, configVersion)
+	}
+
+	for version := from; version < configVersion; version++ {
+		m := configMigrations[version-1]
+		changed, err := m.migrate(tree)
+		if err != nil {
+			return 0, nil, fmt.Errorf("Config migration from version %d failed; %s", version, err)
+		}
+		if changed {
+			migrated = append(migrated, fmt.Sprintf("version %d to %d: %s", version, version+1, m.description))
+		}
+	}
+	if from < configVersion {
+		tree["config_version"] = configVersion
+	}
+	return from, migrated, nil
+}
+
+// migrateConfiguration upgrades the JSON configuration in data to the current version
+// data is returned unchanged if no migration changes it or it isn't a JSON object, leaving decoding errors to be reported later
+func migrateConfiguration(data []byte) ([]byte, []string, error) {
+	var tree map[string]interface{}
+	if json.Unmarshal(data, &tree) != nil {
+		return data, nil, nil
+	}
+	_, migrated, err := migrateTree(tree)
+	if err != nil || len(migrated) == 0 {
+		return data, nil, err
+	}
+	upgraded, err := json.MarshalIndent(tree, "", "  ")
+	if err != nil {
+		return data, nil, err
+	}
+	return append(upgraded, '\n'), migrated, nil
+}
+
+// logMigrations records the migrations applied to the configuration file at path
+func logMigrations(path string, migrated []string) {
+	for _, m := range migrated {
+		configLog.infof("configuration %s migrated from %s", path, m)
+	}
+	if len(migrated) > 0 {
+		configLog.infof("run \"heihei config migrate %s\" to save the migrated configuration", path)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfigMigrations(t *testing.T) {
+	if len(configMigrations) != configVersion-1 {
+		t.Errorf("got %d migrations; expected one for each version before %d", len(configMigrations), configVersion)
+	}
+}
+
+func TestMigrateTree(t *testing.T) {
+	testCases := []struct {
+		raw      string
+		from     int
+		migrated int
+		expected string
+	}{
+		{`{"log_to_stdout":true}`, 1, 1, `{"config_version":2,"logging":{"output":"stdout"}}`},
+		{`{"log_to_stdout":false,"logging":{"level":"debug"}}`, 1, 1, `{"config_version":2,"logging":{"level":"debug"}}`},
+		{`{"log_to_stdout":true,"logging":{"output":"file"}}`, 1, 1, `{"config_version":2,"logging":{"output":"file"}}`},
+		{`{"lights_out":"22:00"}`, 1, 0, `{"config_version":2,"lights_out":"22:00"}`},
+		{`{"config_version":2,"log_to_stdout":true}`, 2, 0, `{"config_version":2,"log_to_stdout":true}`},
+	}
+	for _, tc := range testCases {
+		var tree map[string]interface{}
+		if err := json.Unmarshal([]byte(tc.raw), &tree); err != nil {
+			t.Fatal(err)
+		}
+		from, migrated, err := migrateTree(tree)
+		if err != nil {
+			t.Errorf("unexpected error %v for %s", err, tc.raw)
+			continue
+		}
+		got, _ := json.Marshal(tree)
+		if from != tc.from || len(migrated) != tc.migrated || string(got) != tc.expected {
+			t.Errorf("got %d, %v and %s for %s; expected %d, %d migrations and %s",
+				from, migrated, got, tc.raw, tc.from, tc.migrated, tc.expected)
+		}
+	}
+}
+
+func TestMigrateTreeFormats(t *testing.T) {
+	testCases := []struct {
+		format string
+		raw    string
+		from   int
+	}{
+		{configFormatYAML, "config_version: 2\nlights_out: \"22:00\"\n", 2},
+		{configFormatYAML, "config_version: 1\nlog_to_stdout: true\n", 1},
+		{configFormatTOML, "config_version = 2\nlights_out = \"22:00\"\n", 2},
+		{configFormatTOML, "config_version = 1\nlog_to_stdout = true\n", 1},
+	}
+	for _, tc := range testCases {
+		var tree interface{}
+		var err error
+		if tc.format == configFormatYAML {
+			tree, err = parseYAML([]byte(tc.raw))
+		} else {
+			tree, err = parseTOML([]byte(tc.raw))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if from, _, err := migrateTree(tree.(map[string]interface{})); err != nil || from != tc.from {
+			t.Errorf("got version %d and error %v for %s %q; expected version %d", from, err, tc.format, tc.raw, tc.from)
+		}
+	}
+}
+
+func TestMigrateTreeError(t *testing.T) {
+	for _, raw := range []string{
+		`{"config_version":3}`,
+		`{"config_version":0}`,
+		`{"config_version":1.5}`,
+		`{"config_version":"2"}`,
+		`{"log_to_stdout":"yes"}`,
+	} {
+		var tree map[string]interface{}
+		if err := json.Unmarshal([]byte(raw), &tree); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := migrateTree(tree); err == nil {
+			t.Errorf("expected error for %s", raw)
+		}
+	}
+}
+
+func TestGetConfigMigrated(t *testing.T) {
+	config, err := getConfiguration(bytes.NewBufferString(validConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"version 1 to 2: log_to_stdout moved to logging.output"}
+	if !config.logToStdout || !reflect.DeepEqual(config.migrated, expected) {
+		t.Errorf("got %v and %v; expected log to stdout and %v", config.logToStdout, config.migrated, expected)
+	}
+
+	// current configuration needs no migration
+	current := strings.Replace(validConfig, `"log_to_stdout":true`, `"config_version":2, "logging":{"output":"stdout"}`, 1)
+	if config, err = getConfiguration(bytes.NewBufferString(current)); err != nil {
+		t.Fatal(err)
+	}
+	if !config.logToStdout || len(config.migrated) != 0 {
+		t.Errorf("got %v and %v; expected log to stdout without migration", config.logToStdout, config.migrated)
+	}
+
+	// the old key isn't part of the current schema
+	current = strings.Replace(validConfig, `"log_to_stdout"`, `"config_version":2, "log_to_stdout"`, 1)
+	if _, err = getConfiguration(bytes.NewBufferString(current)); err == nil || !strings.Contains(err.Error(), "log_to_stdout") {
+		t.Errorf("got error %v; expected log_to_stdout to be unknown", err)
+	}
+}
+
+func TestMigrateConfigCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heihei")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, configFilename)
+	if err = ioutil.WriteFile(path, []byte(validConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if status := runCommand([]string{"config", "migrate", path}, &stdout, &stderr); status != 0 {
+		t.Fatalf("got status %d: %s", status, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "log_to_stdout moved to logging.output") {
+		t.Errorf("got output %q; expected the migration to be listed", stdout.String())
+	}
+	if backup, _ := ioutil.ReadFile(path + backupSuffix); string(backup) != validConfig {
+		t.Errorf("got backup %q; expected the original file", backup)
+	}
+	config, err := loadConfiguration(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.logToStdout || len(config.migrated) != 0 {
+		t.Errorf("got %v and %v from the migrated file; expected log to stdout without migration", config.logToStdout, config.migrated)
+	}
+
+	stdout.Reset()
+	if status := runCommand([]string{"config", "migrate", path}, &stdout, &stderr); status != 0 || !strings.Contains(stdout.String(), "already at version") {
+		t.Errorf("got status %d and %q; expected the file to be current", status, stdout.String())
+	}
+
+	// other formats are left for the user to change
+	yamlPath := filepath.Join(dir, "heihei.yaml")
+	if err = ioutil.WriteFile(yamlPath, []byte("location: [1, 2]\nlights_out: \"22:00\"\nlog_to_stdout: true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdout.Reset()
+	stderr.Reset()
+	if status := runCommand([]string{"config", "migrate", yamlPath}, &stdout, &stderr); status != 1 || !strings.Contains(stdout.String(), "log_to_stdout moved") {
+		t.Errorf("got status %d and %q; expected the changes to be listed", status, stdout.String())
+	}
+
+	// current files in other formats need no changes
+	for name, content := range map[string]string{
+		"current.yaml": "config_version: 2\nlocation: [1, 2]\nlights_out: \"22:00\"\n",
+		"current.toml": "config_version = 2\nlocation = [1, 2]\nlights_out = \"22:00\"\n",
+	} {
+		current := filepath.Join(dir, name)
+		if err = ioutil.WriteFile(current, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		stdout.Reset()
+		stderr.Reset()
+		if status := runCommand([]string{"config", "migrate", current}, &stdout, &stderr); status != 0 || !strings.Contains(stdout.String(), "already at version") {
+			t.Errorf("%s: got status %d, %q and %q; expected the file to be current", name, status, stdout.String(), stderr.String())
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"periph.io/x/periph/conn/gpio"
+)
+
+// motionLog is the logger for the motion subsystem
+var motionLog = newLogger("motion")
+
+// motionConfiguration describes a PIR motion sensor wired to a GPIO input
+type motionConfiguration struct {
+	name      string
+	pin       string
+	pull      gpio.Pull
+	activeLow bool
+	rules     []motionRule
+}
+
+// motionRule switches a plug on for a duration when motion is detected within its period
+type motionRule struct {
+	plug     string
+	duration time.Duration
+	period
+}
+
+// motionStatus reports the motion seen by a sensor since the server started
+type motionStatus struct {
+	Sensor     string     `json:"sensor"`
+	Pin        string     `json:"pin"`
+	LastMotion *time.Time `json:"last_motion,omitempty"`
+	Count      int        `json:"count"`
+}
+
+// motionMonitor records the motion detected by each sensor
+type motionMonitor struct {
+	mu      sync.Mutex
+	sensors []motionStatus
+}
+
+// newMotionMonitor creates a monitor for the configured sensors
+func newMotionMonitor(sensors []motionConfiguration) *motionMonitor {
+	m := &motionMonitor{sensors: []motionStatus{}}
+	for _, s := range sensors {
+		m.sensors = append(m.sensors, motionStatus{Sensor: s.name, Pin: s.pin})
+	}
+	return m
+}
+
+// record notes motion detected by the named sensor at now
+func (m *motionMonitor) record(name string, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.sensors {
+		if m.sensors[i].Sensor == name {
+			m.sensors[i].LastMotion = &now
+			m.sensors[i].Count++
+		}
+	}
+}
+
+// status returns the state of each sensor in configuration order; a nil monitor has no sensors
+func (m *motionMonitor) status() []motionStatus {
+	sensors := []motionStatus{}
+	if m == nil {
+		return sensors
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range m.sensors {
+		if s.LastMotion != nil {
+			last := *s.LastMotion
+			s.LastMotion = &last
+		}
+		sensors = append(sensors, s)
+	}
+	return sensors
+}
+
+// motionFunc returns a function that handles motion detected by the named sensor
+// the rules are read from the store so that reloaded rules take effect; each motion restarts the timer of the plug
+// a plug that is already on without a timer, such as one switched on by hand, is left alone
+func motionFunc(ctx context.Context, name string, store *configStore, plugs []namedPlug, m *motionMonitor, bus *eventBus) func() {
+	ctx = withOrigin(ctx, origin{source: sourceMotion})
+	l := motionLog.with(fields{"sensor": name})
+	return func() {
+		now := time.Now()
+		config := store.get()
+		m.record(name, now)
+		l.infof("motion detected")
+		bus.publish(event{Type: eventMotion, Time: now, Name: name, On: true, Source: sourceMotion})
+
+		for _, s := range config.motionSensors {
+			if s.name != name {
+				continue
+			}
+			for _, r := range s.rules {
+				if !r.applies(now, config) {
+					continue
+				}
+				p, ok := findPlug(plugs, r.plug)
+				if !ok {
+					l.errorf("plug %s not found", r.plug)
+					continue
+				}
+				if p.state() && p.overrideUntil().IsZero() {
+					l.with(fields{"plug": r.plug}).debugf("plug already on")
+					continue
+				}
+				p.setForDuration(ctx, true, r.duration)
+			}
+		}
+	}
+}
+
+// startMotionSensors watches each configured sensor; a sensor whose pin can't be used is logged and skipped
+// a sensor is watched as a button that is pressed while it detects motion
+func startMotionSensors(ctx context.Context, store *configStore, plugs []namedPlug, m *motionMonitor, bus *eventBus) {
+	for _, s := range store.get().motionSensors {
+		detected := motionFunc(ctx, s.name, store, plugs, m, bus)
+		b := buttonConfiguration{name: s.name, pin: s.pin, pull: s.pull, activeLow: s.activeLow, debounce: defaultDebounce}
+		pin, err := openInputPin(s.pin)
+		if err == nil {
+			err = watchButton(ctx, b, "motion sensor "+s.name, pin, func(string) { detected() })
+		}
+		if err != nil {
+			motionLog.with(fields{"sensor": s.name}).errorf("sensor on %s unavailable; %v", s.pin, err)
+			continue
+		}
+		motionLog.with(fields{"sensor": s.name}).infof("watching %s", s.pin)
+	}
+}
+
+// motionInputs returns the sensors without their rules, which are the settings only read at start up
+func motionInputs(sensors []motionConfiguration) []motionConfiguration {
+	inputs := make([]motionConfiguration, len(sensors))
+	for i, s := range sensors {
+		inputs[i] = s
+		inputs[i].rules = nil
+	}
+	return inputs
+}
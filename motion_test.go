@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"periph.io/x/periph/conn/gpio"
+)
+
+func TestMotionFunc(t *testing.T) {
+	// a period that starts in an hour and ends an hour later so it doesn't include now
+	later := time.Now().Add(time.Hour).Format("15:04")
+	testCases := []struct {
+		note          string
+		on            bool
+		until         time.Time
+		after         string
+		expectedUntil bool
+	}{
+		{note: "off", expectedUntil: true},
+		{note: "timed", on: true, until: time.Now().Add(time.Second), expectedUntil: true},
+		{note: "on", on: true},
+		{note: "outside period", after: later},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.note, func(t *testing.T) {
+			light := &fakePlug{on: tc.on, until: tc.until}
+			plugs := []namedPlug{{name: "light", plugInterface: light}}
+			rule := motionRule{plug: "light", duration: 5 * time.Minute, period: period{after: tc.after}}
+			if tc.after != "" {
+				rule.before = time.Now().Add(2 * time.Hour).Format("15:04")
+			}
+			store := &configStore{config: configuration{location: [2]float64{51.5, -0.1}, lightsOut: "23:00",
+				motionSensors: []motionConfiguration{{name: "hall", rules: []motionRule{rule}}}}}
+			m := newMotionMonitor(store.get().motionSensors)
+
+			start := time.Now()
+			motionFunc(context.Background(), "hall", store, plugs, m, nil)()
+
+			extended := light.until.After(start.Add(4 * time.Minute))
+			if extended != tc.expectedUntil {
+				t.Errorf("timer set %v; expected %v", extended, tc.expectedUntil)
+			}
+			if s := m.status(); len(s) != 1 || s[0].Count != 1 || s[0].LastMotion == nil {
+				t.Errorf("status %+v; expected one motion", s)
+			}
+		})
+	}
+}
+
+func TestMotionMonitorNil(t *testing.T) {
+	var m *motionMonitor
+	if s := m.status(); s == nil || len(s) != 0 {
+		t.Errorf("got %v; expected an empty list", s)
+	}
+}
+
+func TestGetConfigMotionSensors(t *testing.T) {
+	buf := bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", "motion_sensors":[
+		{"name":"hallway", "pin":"GPIO4", "rules":[{"plug":"light", "duration_seconds":300, "after":"sunset"}]}]}`,
+		magNLat, magNLon, bedtime))
+	config, err := getConfiguration(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []motionConfiguration{{name: "hallway", pin: "GPIO4", pull: gpio.PullDown,
+		rules: []motionRule{{plug: "light", duration: 5 * time.Minute, period: period{after: boundarySunset}}}}}
+	if !reflect.DeepEqual(config.motionSensors, expected) {
+		t.Errorf("got %+v; expected %+v", config.motionSensors, expected)
+	}
+
+	for _, sensors := range []string{
+		`[{"rules":[]}]`,
+		`[{"pin":"GPIO4"}, {"pin":"GPIO4"}]`,
+		`[{"pin":"GPIO4", "pull":"sideways"}]`,
+		`[{"pin":"GPIO4", "rules":[{"plug":"lamp", "duration_seconds":300}]}]`,
+		`[{"pin":"GPIO4", "rules":[{"plug":"light"}]}]`,
+		`[{"pin":"GPIO4", "rules":[{"plug":"light", "duration_seconds":300, "after":"dusk"}]}]`,
+	} {
+		buf = bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", "motion_sensors":%s}`, magNLat, magNLon, bedtime, sensors))
+		if _, err = getConfiguration(buf); err == nil {
+			t.Errorf("expected error for motion sensors %s", sensors)
+		}
+	}
+
+	buf = bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", "buttons":[{"pin":"GPIO4", "plug":"light"}],
+		"motion_sensors":[{"pin":"GPIO4"}]}`, magNLat, magNLon, bedtime))
+	if _, err = getConfiguration(buf); err == nil {
+		t.Errorf("expected error for a pin shared by a button and a motion sensor")
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"periph.io/x/periph/conn/gpio"
+)
+
+// OOK frame timing
+// a frame is a run of pulses ended by a long low gap, which is the sync of the frame that follows
+const (
+	ookBits      = 24                     // an HS1527 frame holds a 20-bit address and 4 data bits
+	ookGap       = 4 * time.Millisecond   // a low longer than this ends a frame; data pulses are at most 3 units of about 500µs
+	ookMinPulses = 16                     // shorter frames are noise
+	ookMaxPulses = 256                    // longer runs without a gap are noise
+	ookSilence   = 100 * time.Millisecond // wait for an edge before ending a frame with the silence
+	// ookRepeatGap is the longest time between the repeated frames of a single press of a remote
+	ookRepeatGap = 300 * time.Millisecond
+	// ookConfirmations is the number of identical frames that confirm a code; a remote repeats each frame several times
+	ookConfirmations = 2
+)
+
+// ookFrame is the widths of the pulses of a frame, alternating high and low starting with high
+// the last pulse is the low gap that ended the frame
+type ookFrame []time.Duration
+
+// ookDecoder splits a stream of pulses into frames
+type ookDecoder struct {
+	pulses []time.Duration
+}
+
+// feed adds a pulse and returns the frame that it ends, if any
+// pulses that don't alternate, such as after a missed edge, restart the frame
+func (d *ookDecoder) feed(high bool, width time.Duration) (ookFrame, bool) {
+	if high != (len(d.pulses)%2 == 0) {
+		d.pulses = d.pulses[:0]
+		if !high {
+			return nil, false
+		}
+	}
+	d.pulses = append(d.pulses, width)
+	if !high && width >= ookGap {
+		f := ookFrame(append([]time.Duration(nil), d.pulses...))
+		d.pulses = d.pulses[:0]
+		return f, len(f) >= ookMinPulses
+	}
+	if len(d.pulses) > ookMaxPulses {
+		d.pulses = d.pulses[:0]
+	}
+	return nil, false
+}
+
+// hs1527 decodes a frame of 24 bits followed by the high pulse of the next sync
+// each bit is a high and a low pulse lasting 4 units, with a 3 unit high for 1 and a 3 unit low for 0
+// the first bit is the most significant; the code is the address followed by data bits D3 to D0
+func (f ookFrame) hs1527() (uint32, bool) {
+	if len(f) != 2*ookBits+2 {
+		return 0, false
+	}
+	var total time.Duration
+	for _, p := range f[:2*ookBits] {
+		total += p
+	}
+	period := total / ookBits
+	var code uint32
+	for i := 0; i < ookBits; i++ {
+		high, low := f[2*i], f[2*i+1]
+		if d := high + low; d < period*3/4 || d > period*5/4 {
+			return 0, false
+		}
+		code <<= 1
+		switch {
+		case high > low*3/2:
+			code |= 1
+		case low > high*3/2:
+		default:
+			return 0, false
+		}
+	}
+	return code, true
+}
+
+// ookRepeats confirms codes that are received repeatedly, once for each press of a remote
+type ookRepeats struct {
+	code  uint32
+	count int
+	last  time.Time
+}
+
+// confirm records code received at time at and returns true when it has been received enough times in a row
+func (r *ookRepeats) confirm(code uint32, at time.Time) bool {
+	if code == r.code && at.Sub(r.last) < ookRepeatGap {
+		r.count++
+	} else {
+		r.code, r.count = code, 1
+	}
+	r.last = at
+	return r.count == ookConfirmations
+}
+
+// watchReceiver starts a routine that times the edges of a receiver on pin and passes each frame to received
+// a frame ended by silence is passed on once no edge has been seen for ookSilence
+func watchReceiver(ctx context.Context, pin gpio.PinIn, pull gpio.Pull, received func(f ookFrame, at time.Time)) error {
+	if err := pin.In(pull, gpio.BothEdges); err != nil {
+		return err
+	}
+	go func() {
+		var d ookDecoder
+		high := pin.Read() == gpio.High
+		last := time.Now()
+		silent := false // the current silence has been passed to the decoder
+		for ctx.Err() == nil {
+			edge := pin.WaitForEdge(ookSilence)
+			now := time.Now()
+			if !edge {
+				if !high && !silent {
+					if f, ok := d.feed(false, now.Sub(last)); ok {
+						received(f, now)
+					}
+				}
+				silent = true
+				high = pin.Read() == gpio.High
+				continue
+			}
+			if !silent || high {
+				if f, ok := d.feed(high, now.Sub(last)); ok {
+					received(f, now)
+				}
+			}
+			high, last, silent = !high, now, false
+		}
+	}()
+	return nil
+}
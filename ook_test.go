@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readCapture reads a pulse capture from testdata/ook
+// a capture holds widths in microseconds alternating high and low, starting with high; lines starting with # are comments
+func readCapture(t *testing.T, name string) []time.Duration {
+	f, err := os.Open(filepath.Join("testdata", "ook", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var pulses []time.Duration
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "#") {
+			continue
+		}
+		for _, field := range strings.Fields(scanner.Text()) {
+			us, err := strconv.Atoi(field)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			pulses = append(pulses, time.Duration(us)*time.Microsecond)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return pulses
+}
+
+// replayCapture feeds the pulses of a capture to a decoder, passing each frame to received with the time that it ended
+func replayCapture(pulses []time.Duration, received func(f ookFrame, at time.Time)) {
+	var d ookDecoder
+	at := time.Date(2020, time.December, 1, 12, 0, 0, 0, time.UTC)
+	for i, width := range pulses {
+		at = at.Add(width)
+		if f, ok := d.feed(i%2 == 0, width); ok {
+			received(f, at)
+		}
+	}
+}
+
+func TestHS1527Captures(t *testing.T) {
+	testCases := []struct {
+		capture  string
+		frames   int // frames that decode as HS1527
+		expected []uint32
+	}{
+		{"socket1_on.txt", 6, []uint32{0x5a3c1f}},
+		{"socket2_off.txt", 5, []uint32{0x5a3c16}},
+		{"all_off_held.txt", 14, []uint32{0x5a3c13}},
+		{"socket3_on.txt", 6, []uint32{0x5a3c1d}},
+		{"socket1_on_off.txt", 10, []uint32{0x5a3c1f, 0x5a3c17}},
+		{"other_remote.txt", 6, []uint32{0x0b2f4f}},
+		{"single_frame.txt", 1, nil},
+		{"noise.txt", 0, nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.capture, func(t *testing.T) {
+			frames := 0
+			var codes []uint32
+			receive := hs1527Receiver(func(code uint32) { codes = append(codes, code) })
+			replayCapture(readCapture(t, tc.capture), func(f ookFrame, at time.Time) {
+				if _, ok := f.hs1527(); ok {
+					frames++
+				}
+				receive(f, at)
+			})
+			if frames != tc.frames {
+				t.Errorf("decoded %d frames; expected %d", frames, tc.frames)
+			}
+			if !reflect.DeepEqual(codes, tc.expected) {
+				t.Errorf("got codes %x; expected %x", codes, tc.expected)
+			}
+		})
+	}
+}
+
+func TestOOKDecoderFeed(t *testing.T) {
+	var d ookDecoder
+	// a missed edge restarts the frame
+	for i := 0; i < ookMinPulses; i++ {
+		d.feed(i%2 == 0, 300*time.Microsecond)
+	}
+	d.feed(false, 300*time.Microsecond)
+	if len(d.pulses) != 0 {
+		t.Errorf("got %d pulses after a repeated low; expected none", len(d.pulses))
+	}
+	d.feed(true, 300*time.Microsecond)
+	if f, ok := d.feed(false, 10*time.Millisecond); ok || len(d.pulses) != 0 {
+		t.Errorf("got frame %v; expected a short frame to be dropped", f)
+	}
+
+	// a run of pulses without a gap is dropped
+	for i := 0; i <= ookMaxPulses; i++ {
+		d.feed(i%2 == 0, 300*time.Microsecond)
+	}
+	if len(d.pulses) != 0 {
+		t.Errorf("got %d pulses; expected a long run to be dropped", len(d.pulses))
+	}
+}
+
+func TestHS1527Timing(t *testing.T) {
+	bit := func(one bool, unit time.Duration) []time.Duration {
+		if one {
+			return []time.Duration{3 * unit, unit}
+		}
+		return []time.Duration{unit, 3 * unit}
+	}
+	frame := func(change func(f ookFrame)) ookFrame {
+		var f ookFrame
+		for i := 0; i < ookBits; i++ {
+			f = append(f, bit(i%3 == 0, 300*time.Microsecond)...)
+		}
+		f = append(f, 300*time.Microsecond, 9300*time.Microsecond)
+		if change != nil {
+			change(f)
+		}
+		return f
+	}
+	if code, ok := frame(nil).hs1527(); !ok || code != 0x924924 {
+		t.Errorf("got %#x %v; expected 0x924924", code, ok)
+	}
+	for note, f := range map[string]ookFrame{
+		"bit too long":   frame(func(f ookFrame) { f[5] = 3 * time.Millisecond }),
+		"bit ambiguous":  frame(func(f ookFrame) { f[2], f[3] = 600*time.Microsecond, 600*time.Microsecond }),
+		"too few pulses": frame(nil)[2:],
+	} {
+		if code, ok := f.hs1527(); ok {
+			t.Errorf("%s: got %#x; expected the frame to be rejected", note, code)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import "time"
+
+// period boundaries other than clock times
+const (
+	boundarySunset    = "sunset"
+	boundarySunrise   = "sunrise"
+	boundaryLightsOut = "lights_out"
+)
+
+// period is the part of each day that a rule applies between after and before
+// after and before are sunset, sunrise, lights_out or a clock time; the period is the whole day if both are empty
+type period struct {
+	after, before string
+}
+
+// defaultPeriodBefore ends a period that only gives a start
+const defaultPeriodBefore = boundarySunrise
+
+// isPeriodBoundary returns true if s can start or end a period
+func isPeriodBoundary(s string) bool {
+	switch s {
+	case boundarySunset, boundarySunrise, boundaryLightsOut:
+		return true
+	}
+	_, _, err := decodeClock(s)
+	return err == nil
+}
+
+// lastBoundary returns the latest time no later than now that boundary occurred
+// false is returned if it didn't occur today or yesterday, as with sunset in a polar summer
+func lastBoundary(boundary string, now time.Time, config configuration) (last time.Time, ok bool) {
+	latitude, longitude := config.latLong()
+	for _, day := range []time.Time{now, now.AddDate(0, 0, -1)} {
+		var at time.Time
+		var err error
+		switch boundary {
+		case boundarySunset:
+			at, err = sunsetOn(latitude, longitude, day)
+		case boundarySunrise:
+			at, err = sunriseOn(latitude, longitude, day)
+		default:
+			clock := boundary
+			if boundary == boundaryLightsOut {
+				clock = config.lightsOut
+			}
+			var hour, minute int
+			if hour, minute, err = decodeClock(clock); err == nil {
+				at = time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, day.Location())
+			}
+		}
+		if err == nil && !at.After(now) {
+			return at, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// applies returns true if the period includes now; it is open if after occurred more recently than before
+func (p period) applies(now time.Time, config configuration) bool {
+	if p.after == "" && p.before == "" {
+		return true
+	}
+	after, before := p.after, p.before
+	if after == "" {
+		after = "00:00"
+	}
+	if before == "" {
+		before = defaultPeriodBefore
+	}
+	start, ok := lastBoundary(after, now, config)
+	if !ok {
+		return false
+	}
+	end, ok := lastBoundary(before, now, config)
+	return !ok || start.After(end)
+}
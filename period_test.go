@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPeriodApplies(t *testing.T) {
+	// sunset in London on 1 December is about 15:55 and sunrise about 07:40
+	config := configuration{location: [2]float64{51.5, -0.1}, lightsOut: "23:00"}
+	at := func(hour, minute int) time.Time {
+		return time.Date(2020, time.December, 1, hour, minute, 0, 0, time.UTC)
+	}
+	testCases := []struct {
+		after, before string
+		now           time.Time
+		expected      bool
+	}{
+		{"", "", at(12, 0), true},
+		{"sunset", "", at(12, 0), false},
+		{"sunset", "", at(17, 0), true},
+		{"sunset", "", at(3, 0), true},
+		{"sunset", "", at(8, 0), false},
+		{"sunset", "lights_out", at(22, 59), true},
+		{"sunset", "lights_out", at(23, 1), false},
+		{"18:00", "06:30", at(6, 0), true},
+		{"18:00", "06:30", at(6, 30), false},
+		{"", "07:00", at(6, 0), true},
+		{"", "07:00", at(7, 30), false},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s-%s at %s", tc.after, tc.before, tc.now.Format("15:04")), func(t *testing.T) {
+			p := period{after: tc.after, before: tc.before}
+			if applies := p.applies(tc.now, config); applies != tc.expected {
+				t.Errorf("applies %v; expected %v", applies, tc.expected)
+			}
+		})
+	}
+}
@@ -1,5 +1,16 @@
 package main
 
+import (
+	"fmt"
+	"strconv"
+
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/gpio/gpioreg"
+)
+
+// pinLog is the logger for the pin subsystem
+var pinLog = newLogger("pin")
+
 // save last error
 var pinError error
 
@@ -34,3 +45,65 @@ func (p pin) on() (err error) {
 	setPinError(err)
 	return
 }
+
+// headerPins are the GPIO numbers of the Raspberry Pi header positions, so that pins can be named either way
+var headerPins = map[string]int{
+	"P1_3": 2, "P1_5": 3, "P1_7": 4, "P1_8": 14, "P1_10": 15, "P1_11": 17, "P1_12": 18, "P1_13": 27,
+	"P1_15": 22, "P1_16": 23, "P1_18": 24, "P1_19": 10, "P1_21": 9, "P1_22": 25, "P1_23": 11, "P1_24": 8,
+	"P1_26": 7, "P1_27": 0, "P1_28": 1, "P1_29": 5, "P1_31": 6, "P1_32": 12, "P1_33": 13, "P1_35": 19,
+	"P1_36": 16, "P1_37": 26, "P1_38": 20, "P1_40": 21,
+}
+
+// encoderPins are the header positions wired to the Energenie encoder and modulator
+var encoderPins = []string{"P1_11", "P1_13", "P1_15", "P1_16", "P1_18", "P1_22"}
+
+// the header positions are registered as aliases so that pins named by position can be opened
+func init() {
+	for position, number := range headerPins {
+		if err := gpioreg.RegisterAlias(position, fmt.Sprintf("GPIO%d", number)); err != nil {
+			pinLog.errorf("header pin %s not registered; %v", position, err)
+		}
+	}
+}
+
+// canonicalPin returns the chipset name, such as GPIO17, of the pin with the given name
+// registered pins are resolved through gpioreg; as nothing is registered until the host is initialised,
+// header positions and numbers are also resolved directly. Other names are returned unchanged
+func canonicalPin(name string) string {
+	if p := gpioreg.ByName(name); p != nil {
+		if r, ok := p.(gpio.RealPin); ok {
+			p = r.Real()
+		}
+		return p.Name()
+	}
+	if number, ok := headerPins[name]; ok {
+		return fmt.Sprintf("GPIO%d", number)
+	}
+	if number, err := strconv.Atoi(name); err == nil && number >= 0 {
+		return fmt.Sprintf("GPIO%d", number)
+	}
+	return name
+}
+
+// pinUsers records what uses each pin by its canonical name
+type pinUsers map[string]string
+
+// newPinUsers returns the pin users with the encoder pins reserved
+func newPinUsers() pinUsers {
+	users := make(pinUsers)
+	for _, p := range encoderPins {
+		users[canonicalPin(p)] = "the Energenie encoder"
+	}
+	return users
+}
+
+// claim records that user, a description such as "a button", uses the pin with the given name
+// an error beginning with kind, such as "Button", is returned if the pin is already used
+func (u pinUsers) claim(name, kind, user string) error {
+	c := canonicalPin(name)
+	if other, ok := u[c]; ok {
+		return fmt.Errorf("%s pin %s is used by %s", kind, name, other)
+	}
+	u[c] = user
+	return nil
+}
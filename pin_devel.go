@@ -2,7 +2,15 @@
 
 package main
 
-import "log"
+import (
+	"errors"
+	"io"
+	"runtime"
+
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/i2c"
+)
+
 
 const buildType = "devel"
 
@@ -35,6 +43,45 @@ func initHAL() error {
 
 // setLevel changes the level of the pin
 func setLevel(p pin, l pinLevel) (err error) {
-	log.Printf("pin %s set %v\n", p, l)
+	pinLog.debugf("pin %s set %v", p, l)
 	return nil
 }
+
+// cpuTemperature returns the temperature of the CPU in degrees Celsius
+func cpuTemperature() (float64, error) {
+	return 0, errors.New("CPU temperature is unavailable in devel builds")
+}
+
+// getHALInfo describes the host; the devel build has no hardware abstraction layer
+func getHALInfo() halInfo {
+	return halInfo{Model: "devel", OS: runtime.GOOS}
+}
+
+// readThermalZones returns no readings as the devel build has no thermal zones
+func readThermalZones() ([]thermalReading, error) {
+	return nil, nil
+}
+
+// openInputPin returns a simulated pin as the devel build has no GPIO header
+func openInputPin(name string) (gpio.PinIn, error) {
+	return simulatedInput(name), nil
+}
+
+// openOutputPin returns a simulated pin as the devel build has no GPIO header
+func openOutputPin(name string) (gpio.PinOut, error) {
+	return simulatedInput(name), nil
+}
+
+// openI2CBus fails as the devel build has no I2C bus
+func openI2CBus(name string) (i2c.BusCloser, error) {
+	return nil, errors.New("I2C is unavailable in devel builds")
+}
+
+// openStatusLED returns a simulated pin as the devel build has no LEDs
+func openStatusLED(c statusLEDConfiguration) (gpio.PinOut, io.Closer, error) {
+	name := c.led
+	if name == "" {
+		name = c.pin
+	}
+	return simulatedInput(name), nil, nil
+}
@@ -3,15 +3,28 @@
 package main
 
 import (
-	"log"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
 
 	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/gpio/gpioreg"
+	"periph.io/x/periph/conn/i2c"
+	"periph.io/x/periph/conn/i2c/i2creg"
+	"periph.io/x/periph/devices"
 	"periph.io/x/periph/host"
+	"periph.io/x/periph/host/distro"
 	"periph.io/x/periph/host/rpi"
+	"periph.io/x/periph/host/sysfs"
 )
 
 const buildType = "rapi"
 
+// cpuThermalZone is the sysfs thermal sensor of the CPU
+const cpuThermalZone = "thermal_zone0"
+
 // pin definitions
 var (
 	// encoder (by board position)
@@ -47,7 +60,139 @@ func initHAL() error {
 func setLevel(p pin, l pinLevel) (err error) {
 	err = p.Out(gpio.Level(l))
 	if err != nil {
-		log.Printf("%v %v failure %v\n", p, l, err)
+		pinLog.errorf("%v %v failure %v", p, l, err)
 	}
 	return
 }
+
+// cpuTemperature returns the temperature of the CPU in degrees Celsius
+func cpuTemperature() (float64, error) {
+	sensor, err := sysfs.ThermalSensorByName(cpuThermalZone)
+	if err != nil {
+		return 0, err
+	}
+	var env devices.Environment
+	if err = sensor.Sense(&env); err != nil {
+		return 0, err
+	}
+	return env.Temperature.Float64(), nil
+}
+
+// getHALInfo describes the host and the periph drivers
+func getHALInfo() halInfo {
+	info := halInfo{Model: distro.DTModel(), OS: distro.OSRelease()["PRETTY_NAME"]}
+	// the state of the first initialisation is returned
+	state, err := host.Init()
+	if err != nil {
+		info.FailedDrivers = append(info.FailedDrivers, err.Error())
+	}
+	if state == nil {
+		return info
+	}
+	for _, d := range state.Loaded {
+		info.Drivers = append(info.Drivers, d.String())
+	}
+	for _, f := range state.Failed {
+		info.FailedDrivers = append(info.FailedDrivers, f.String())
+	}
+	for _, f := range state.Skipped {
+		info.SkippedDrivers = append(info.SkippedDrivers, f.String())
+	}
+	return info
+}
+
+// readThermalZones returns the temperature of each sysfs thermal zone
+func readThermalZones() ([]thermalReading, error) {
+	if _, err := host.Init(); err != nil {
+		return nil, err
+	}
+	var readings []thermalReading
+	for _, t := range sysfs.ThermalSensors {
+		var env devices.Environment
+		if err := t.Sense(&env); err != nil {
+			return readings, err
+		}
+		readings = append(readings, thermalReading{zone: t.String(), kind: t.Type(), celsius: env.Temperature.Float64()})
+	}
+	return readings, nil
+}
+
+// openInputPin returns the header pin with the given name, such as GPIO5 or P1_29
+func openInputPin(name string) (gpio.PinIn, error) {
+	if _, err := host.Init(); err != nil {
+		return nil, err
+	}
+	p := gpioreg.ByName(name)
+	if p == nil {
+		return nil, fmt.Errorf("no GPIO pin named %s", name)
+	}
+	return p, nil
+}
+
+// openOutputPin returns the header pin with the given name, such as GPIO13 or P1_33, for output
+func openOutputPin(name string) (gpio.PinOut, error) {
+	if _, err := host.Init(); err != nil {
+		return nil, err
+	}
+	p := gpioreg.ByName(name)
+	if p == nil {
+		return nil, fmt.Errorf("no GPIO pin named %s", name)
+	}
+	return p, nil
+}
+
+// openI2CBus opens the I2C bus with the given name or number; an empty name opens the first bus
+func openI2CBus(name string) (i2c.BusCloser, error) {
+	if _, err := host.Init(); err != nil {
+		return nil, err
+	}
+	return i2creg.Open(name)
+}
+
+// ledsPath is the sysfs directory of the LEDs
+const ledsPath = "/sys/class/leds"
+
+// ledTrigger restores the kernel trigger of an LED when closed
+type ledTrigger struct {
+	path    string
+	trigger string
+}
+
+func (t ledTrigger) Close() error {
+	return ioutil.WriteFile(t.path, []byte(t.trigger), 0644)
+}
+
+// openStatusLED returns the sysfs LED or the GPIO pin described by c
+// the kernel trigger of an LED, such as mmc0 activity for the ACT LED, is replaced until the returned closer is closed
+func openStatusLED(c statusLEDConfiguration) (gpio.PinOut, io.Closer, error) {
+	if _, err := host.Init(); err != nil {
+		return nil, nil, err
+	}
+	if c.led == "" {
+		p := gpioreg.ByName(c.pin)
+		if p == nil {
+			return nil, nil, fmt.Errorf("no GPIO pin named %s", c.pin)
+		}
+		return p, nil, nil
+	}
+	led, err := sysfs.LEDByName(c.led)
+	if err != nil {
+		return nil, nil, fmt.Errorf("LED %s; %v", c.led, err)
+	}
+	// the trigger file lists the triggers with the current one in brackets
+	path := filepath.Join(ledsPath, c.led, "trigger")
+	triggers, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	restore := ledTrigger{path: path, trigger: "none"}
+	for _, t := range strings.Fields(string(triggers)) {
+		if strings.HasPrefix(t, "[") && strings.HasSuffix(t, "]") {
+			restore.trigger = strings.Trim(t, "[]")
+		}
+	}
+	if err = ioutil.WriteFile(path, []byte("none"), 0644); err != nil {
+		return nil, nil, fmt.Errorf("LED %s trigger can't be cleared; %v", c.led, err)
+	}
+	return led, restore, nil
+}
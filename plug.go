@@ -3,8 +3,6 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
-	"math/rand"
 	"sync"
 	"time"
 )
@@ -20,6 +18,7 @@ const (
 	plugAll plugID = iota
 	plugOne
 	plugTwo
+	plugLearned // a device that replays codes learned from its remote
 )
 
 // initPlugs initialises the pins used to communicate with the plugs
@@ -32,8 +31,8 @@ func initPlugs() (err error) {
 	clearPinError()
 
 	// initialise periph
-	if err := initHAL(); err != nil {
-		return err
+	if halError = initHAL(); halError != nil {
+		return halError
 	}
 
 	// set encoder to 0000
@@ -52,55 +51,99 @@ func initPlugs() (err error) {
 
 type plug struct {
 	id      plugID
-	setChan chan bool
+	name    string
+	bus     *eventBus
+	log     logger
+	setChan chan plugRequest
 	getChan chan bool
-	timer   *time.Timer
+
+	// transmit sends the change of state; setPins for Energenie plugs
+	transmit func(on bool) error
+
+	// timer and until describe the current timed override; protected by timerMutex
+	timerMutex sync.Mutex
+	timer      *time.Timer
+	until      time.Time
+}
+
+// plugRequest is a request to change the state of a plug
+type plugRequest struct {
+	on     bool
+	origin origin
+	mirror bool // the change was made by another transmitter so the pins aren't set
 }
 
 // newPlug creates a new variable to control the plug with the supplied id
-func newPlug(ctx context.Context, id plugID) *plug {
+// changes of state are published on bus under the given name
+func newPlug(ctx context.Context, name string, id plugID, bus *eventBus) *plug {
 	p := &plug{
-		setChan: make(chan bool),
+		setChan: make(chan plugRequest),
 		getChan: make(chan bool),
 		id:      id,
+		name:    name,
+		bus:     bus,
+		log:     newLogger("plug").with(fields{"plug": name}),
 	}
 
 	// initialise the plugs
+	// failure is reported by /readyz and the pin errors of each transmission
 	if err := initPlugs(); err != nil {
-		log.Fatal(err)
+		p.log.errorf("plug initialisation failed; %v", err)
 	}
 
 	// start with plug off
-	p.setPins(false)
-	currentState := false
+	p.transmit = p.setPins
+	p.transmit(false)
+	p.run(ctx)
+	return p
+}
 
-	// start routine to control and manage plug
+// run starts the routine to control and manage the plug
+func (p *plug) run(ctx context.Context) {
+	currentState := false
 	go func() {
 		for {
 			select {
 
-			case newState := <-p.setChan:
-				log.Printf("set %v %v\n", p.id, newState)
-				p.setPins(newState)
-				currentState = newState
+			case req := <-p.setChan:
+				l := p.log.with(fields{"action": "set", "on": req.on, "source": req.origin.source})
+				if req.origin.requestID != "" {
+					l = l.with(fields{"request": req.origin.requestID})
+				}
+				if req.mirror {
+					l.infof("%v changed by %s to %v", p.id, req.origin.source, req.on)
+				} else {
+					l.infof("set %v %v", p.id, req.on)
+					if err := p.transmit(req.on); err != nil {
+						l.errorf("pin error %v", err)
+					}
+				}
+				currentState = req.on
+				p.bus.publish(event{Type: eventPlug, Name: p.name, On: req.on, Source: req.origin.source})
 			case p.getChan <- currentState:
 			case <-ctx.Done():
 				close(p.getChan)
 				close(p.setChan)
+				p.timerMutex.Lock()
 				if p.timer != nil {
 					p.timer.Stop()
 				}
+				p.timerMutex.Unlock()
 				return
 			}
 		}
 	}()
-
-	return p
 }
 
 // setPins turns plug on or off by setting the pins directly.
 // This function isn't intended to called from outside this file.
-func (p *plug) setPins(on bool) error {
+func (p *plug) setPins(on bool) (err error) {
+	start := time.Now()
+	defer func() {
+		observeTransmit(p.name, time.Since(start), err)
+		recordTransmit(p.name, err)
+	}()
+
 	// lock pins
 	mutex.Lock()
 	defer mutex.Unlock()
@@ -150,27 +193,69 @@ func (p *plug) setPins(on bool) error {
 	return lastPinError()
 }
 
-// set sets the plug
-func (p *plug) set(on bool) {
-	p.setChan <- on
+// set sets the plug; ctx carries the origin of the request
+func (p *plug) set(ctx context.Context, on bool) {
+	p.setChan <- plugRequest{on: on, origin: originFrom(ctx)}
+}
+
+// mirror records a change to the plug made by another transmitter, such as its remote; ctx carries the origin of the change
+func (p *plug) mirror(ctx context.Context, on bool) {
+	p.setChan <- plugRequest{on: on, origin: originFrom(ctx), mirror: true}
 }
 
 // setForDuration sets the plug to on and reverts to the inverse state at the end of the duration
-func (p *plug) setForDuration(on bool, d time.Duration) {
-	r := rand.Intn(1000)
-	log.Printf("[%03d] setForDuration start\n", r)
+func (p *plug) setForDuration(ctx context.Context, on bool, d time.Duration) {
+	l := p.log.withContext(ctx).with(fields{"action": "setForDuration", "on": on, "duration": d})
+	l.infof("setForDuration start")
+	p.timerMutex.Lock()
+	defer p.timerMutex.Unlock()
 	if p.timer != nil && p.timer.Stop() {
-		log.Printf("[%03d] Stopped existing timer\n", r)
+		l.infof("Stopped existing timer")
 	}
-	p.set(on)
+	p.set(ctx, on)
+	// the revert is made by the timer on behalf of the original request
+	revert := originFrom(ctx)
+	revert.source = sourceTimer
+	var timer *time.Timer
 	f := func() {
-		log.Printf("[%03d] setForDuration finish\n", r)
-		p.set(!on)
+		l.infof("setForDuration finish")
+		p.set(withOrigin(context.Background(), revert), !on)
+		p.timerMutex.Lock()
+		if p.timer == timer {
+			p.until = time.Time{}
+		}
+		p.timerMutex.Unlock()
 	}
-	p.timer = time.AfterFunc(d, f)
+	p.until = time.Now().Add(d)
+	timer = time.AfterFunc(d, f)
+	p.timer = timer
+}
+
+// overrideUntil returns the time that the current timed override ends
+// the zero time is returned if there is no override
+func (p *plug) overrideUntil() time.Time {
+	p.timerMutex.Lock()
+	defer p.timerMutex.Unlock()
+	return p.until
 }
 
 // state returns the current status of the plug
 func (p *plug) state() bool {
 	return <-p.getChan
 }
+
+// namedPlug associates a plug with its configured name
+type namedPlug struct {
+	name string
+	plugInterface
+}
+
+// findPlug returns the plug with the given name
+func findPlug(plugs []namedPlug, name string) (plugInterface, bool) {
+	for _, p := range plugs {
+		if p.name == name {
+			return p.plugInterface, true
+		}
+	}
+	return nil, false
+}
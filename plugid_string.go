@@ -4,9 +4,9 @@ package main
 
 import "fmt"
 
-const _plugID_name = "plugAllplugOneplugTwo"
+const _plugID_name = "plugAllplugOneplugTwoplugLearned"
 
-var _plugID_index = [...]uint8{0, 7, 14, 21}
+var _plugID_index = [...]uint8{0, 7, 14, 21, 32}
 
 func (i plugID) String() string {
 	if i < 0 || i >= plugID(len(_plugID_index)-1) {
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"periph.io/x/periph/conn/gpio"
+)
+
+// maxRemoteAddress is the largest 20-bit remote address
+const maxRemoteAddress = 1<<20 - 1
+
+// receiverLog is the logger for the 433MHz receiver subsystem
+var receiverLog = newLogger("receiver")
+
+// receiverConfiguration describes a 433MHz OOK receiver and the remotes whose presses are mirrored by the plugs
+type receiverConfiguration struct {
+	pin       string // the periph name of the pin, such as GPIO6 or P1_31
+	pull      gpio.Pull
+	addresses []uint32 // the 20-bit addresses of the remotes
+}
+
+// remotePlugs are the plugs selected by data bits D2 to D0 of a remote code, matching the encoder bits set when transmitting
+var remotePlugs = map[uint32]plugID{
+	0x3: plugAll,
+	0x7: plugOne,
+	0x6: plugTwo,
+}
+
+// receiverInput returns the settings of the receiver that are only read at start up
+func receiverInput(c *receiverConfiguration) interface{} {
+	if c == nil {
+		return nil
+	}
+	return [2]interface{}{c.pin, c.pull}
+}
+
+// remoteFunc returns a function that mirrors the change made by a press of a remote with a configured address
+// the plugs are updated without transmitting and their changes have the remote as their source
+func remoteFunc(ctx context.Context, store *configStore, plugs []namedPlug) func(code uint32) {
+	ctx = withOrigin(ctx, origin{source: sourceRemote})
+	return func(code uint32) {
+		config := store.get()
+		address, data := code>>4, code&0xf
+		l := receiverLog.with(fields{"address": fmt.Sprintf("%#05x", address)})
+		if config.receiver == nil || !hasAddress(config.receiver.addresses, address) {
+			l.infof("code %#06x from an unknown remote", code)
+			return
+		}
+		id, ok := remotePlugs[data&0x7]
+		if !ok {
+			l.debugf("code %#06x doesn't select a plug", code)
+			return
+		}
+		on := data&0x8 != 0
+		for _, c := range config.plugs {
+			if id != plugAll && c.id != id {
+				continue
+			}
+			p, ok := findPlug(plugs, c.name)
+			if !ok || p.state() == on {
+				continue
+			}
+			l.with(fields{"plug": c.name}).infof("changed by remote to %v", on)
+			p.mirror(ctx, on)
+		}
+	}
+}
+
+// hasAddress returns true if addresses includes address
+func hasAddress(addresses []uint32, address uint32) bool {
+	for _, a := range addresses {
+		if a == address {
+			return true
+		}
+	}
+	return false
+}
+
+// hs1527Receiver returns a function that decodes HS1527 frames, passing each code confirmed by repeated frames to pressed
+func hs1527Receiver(pressed func(code uint32)) func(f ookFrame, at time.Time) {
+	var repeats ookRepeats
+	return func(f ookFrame, at time.Time) {
+		code, ok := f.hs1527()
+		if ok && repeats.confirm(code, at) {
+			pressed(code)
+		}
+	}
+}
+
+// startReceiver watches the configured receiver, passing each confirmed code to pressed and each frame to learn
+// nothing is started if no receiver is configured and a receiver whose pin can't be used is logged
+func startReceiver(ctx context.Context, store *configStore, pressed func(code uint32), learn *learner) {
+	c := store.get().receiver
+	if c == nil {
+		return
+	}
+	pin, err := openInputPin(c.pin)
+	if err == nil {
+		decode := hs1527Receiver(pressed)
+		err = watchReceiver(ctx, pin, c.pull, func(f ookFrame, at time.Time) {
+			learn.received(f, at)
+			decode(f, at)
+		})
+	}
+	if err != nil {
+		receiverLog.errorf("receiver on %s unavailable; %v", c.pin, err)
+		return
+	}
+	receiverLog.infof("watching %s", c.pin)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"periph.io/x/periph/conn/gpio"
+)
+
+func TestRemoteFunc(t *testing.T) {
+	config := configuration{
+		plugs:    []plugConfiguration{{name: "light", id: plugOne}, {name: "fan", id: plugTwo}},
+		receiver: &receiverConfiguration{pin: "GPIO26", addresses: []uint32{0x5a3c1}},
+	}
+	testCases := []struct {
+		code     uint32
+		expected map[string]bool
+	}{
+		{0x5a3c1f, map[string]bool{"light": true, "fan": false}},
+		{0x5a3c16, map[string]bool{"light": true, "fan": false}},
+		{0x5a3c1e, map[string]bool{"light": true, "fan": true}},
+		{0x5a3c17, map[string]bool{"light": false, "fan": false}},
+		{0x5a3c13, map[string]bool{"light": false, "fan": false}},
+		{0x5a3c1b, map[string]bool{"light": true, "fan": true}},
+		{0x5a3c1d, map[string]bool{"light": true, "fan": false}}, // socket 3 isn't configured
+		{0x0b2f4b, map[string]bool{"light": true, "fan": false}}, // another remote
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%#x", tc.code), func(t *testing.T) {
+			light, fan := &fakePlug{on: true}, &fakePlug{}
+			plugs := []namedPlug{{name: "light", plugInterface: light}, {name: "fan", plugInterface: fan}}
+			remoteFunc(context.Background(), &configStore{config: config}, plugs)(tc.code)
+			if got := map[string]bool{"light": light.on, "fan": fan.on}; !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("got %v; expected %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestPlugMirror(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bus := newEventBus(ctx)
+	events := bus.subscribe()
+	p := newPlug(ctx, "light", plugOne, bus)
+	// a transmission holds the pins for 350ms
+	start := time.Now()
+	p.mirror(withOrigin(ctx, origin{source: sourceRemote}), true)
+	if !p.state() {
+		t.Errorf("expected the mirrored state")
+	}
+	if d := time.Since(start); d >= 350*time.Millisecond {
+		t.Errorf("mirror took %v; expected no transmission", d)
+	}
+	if e := <-events; e.Type != eventPlug || !e.On || e.Source != sourceRemote {
+		t.Errorf("got %+v; expected a plug event from the remote", e)
+	}
+}
+
+func TestGetConfigReceiver(t *testing.T) {
+	buf := bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", "receiver":{"pin":"GPIO26", "addresses":[369601]}}`,
+		magNLat, magNLon, bedtime))
+	config, err := getConfiguration(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &receiverConfiguration{pin: "GPIO26", pull: gpio.Float, addresses: []uint32{0x5a3c1}}
+	if !reflect.DeepEqual(config.receiver, expected) {
+		t.Errorf("got %+v; expected %+v", config.receiver, expected)
+	}
+
+	for _, receiver := range []string{
+		`{"addresses":[369601]}`,
+		`{"pin":"GPIO16", "addresses":[369601]}`,
+		`{"pin":"GPIO26"}`,
+		`{"pin":"GPIO26", "addresses":[1048576]}`,
+		`{"pin":"GPIO26", "pull":"sideways", "addresses":[369601]}`,
+	} {
+		buf = bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", "buttons":[{"pin":"GPIO16", "plug":"light"}], "receiver":%s}`,
+			magNLat, magNLon, bedtime, receiver))
+		if _, err = getConfiguration(buf); err == nil {
+			t.Errorf("expected error for receiver %s", receiver)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	backupSuffix        = ".bak"
+	maxConfigSize       = 1024 * 1024
+	configWatchInterval = 2 * time.Second
+)
+
+// configLog is the logger for the config subsystem
+var configLog = newLogger("config")
+
+// configStore holds the current configuration so that it can be replaced while the server runs
+type configStore struct {
+	mu       sync.RWMutex
+	config   configuration
+	adjust   func(*configuration) // applies command line overrides to each replacement
+	watchers []func(configuration)
+}
+
+// get returns the current configuration
+func (s *configStore) get() configuration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config
+}
+
+// set replaces the current configuration and tells the watchers
+func (s *configStore) set(c configuration) {
+	if s.adjust != nil {
+		s.adjust(&c)
+	}
+	s.mu.Lock()
+	s.config = c
+	watchers := s.watchers
+	s.mu.Unlock()
+	for _, f := range watchers {
+		f(c)
+	}
+}
+
+// onChange registers f to be called with each replacement configuration
+func (s *configStore) onChange(f func(configuration)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.watchers = append(s.watchers, f)
+}
+
+// restartRequired returns the names of the settings that differ between old and new but are only read at start up
+func restartRequired(old, new configuration) (names []string) {
+	settings := []struct {
+		name     string
+		old, new interface{}
+	}{
+		{"logging.output", old.logToStdout, new.logToStdout},
+		{"tls", old.tls, new.tls},
+		{"listen", old.listen, new.listen},
+		{"admin_socket", old.adminSocket, new.adminSocket},
+		{"plugs", old.plugs, new.plugs},
+		{"log_rotation", old.logRotation, new.logRotation},
+		{"access_log", old.accessLog, new.accessLog},
+		{"buttons", old.buttons, new.buttons},
+		{"motion_sensors", motionInputs(old.motionSensors), motionInputs(new.motionSensors)},
+		{"light_sensor", lightInput(old.light), lightInput(new.light)},
+		{"thermostat", old.thermostat != nil, new.thermostat != nil},
+		{"sensors", old.sensors, new.sensors},
+		{"sensor_history", old.sensorHistory, new.sensorHistory},
+		{"status_led", statusLEDOutput(old.statusLED), statusLEDOutput(new.statusLED)},
+		{"display", displayDevice(old.display), displayDevice(new.display)},
+		{"receiver", receiverInput(old.receiver), receiverInput(new.receiver)},
+		{"transmitter", transmitterOutput(old.transmitter), transmitterOutput(new.transmitter)},
+		{"devices", deviceNames(old.devices), deviceNames(new.devices)},
+	}
+	for _, s := range settings {
+		if !reflect.DeepEqual(s.old, s.new) {
+			names = append(names, s.name)
+		}
+	}
+	return names
+}
+
+// replaceConfiguration stores config, logging any settings that won't take effect until a restart
+func replaceConfiguration(config configuration, store *configStore) []string {
+	old := store.get()
+	store.set(config)
+	restart := restartRequired(old, store.get())
+	if len(restart) > 0 {
+		configLog.warnf("restart required for changes to %v", restart)
+	}
+	return restart
+}
+
+// reloadConfiguration reads and validates the configuration file at path and, if valid, stores it
+// listeners, TLS settings, plugs and log files are only read at start up so changes to them require a restart
+func reloadConfiguration(path string, store *configStore) error {
+	config, err := loadConfiguration(path, os.Environ())
+	if err != nil {
+		return err
+	}
+	logMigrations(path, config.migrated)
+	replaceConfiguration(config, store)
+	configLog.infof("configuration reloaded from %s", path)
+	return nil
+}
+
+// reloadHandlerFunc returns a handler function that reloads the configuration file at path
+func reloadHandlerFunc(path string, store *configStore) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			respond(w, "Reload requires POST", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := reloadConfiguration(path, store); err != nil {
+			respond(w, fmt.Sprintf("Reload failed; %s", err), http.StatusUnprocessableEntity)
+			return
+		}
+		respond(w, "Configuration reloaded", http.StatusOK)
+	}
+}
+
+// copyFile copies the file at src to dst, replacing dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// writeFileAtomic replaces the file at path with data so that readers see either the old or the new content
+// the previous content is kept at path.bak
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err = os.Stat(path); err == nil {
+		if err = copyFile(path, path+backupSuffix); err != nil {
+			return fmt.Errorf("backup failed; %s", err)
+		}
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// make the rename durable
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// configHandlerFunc returns a handler function that reads (GET) or replaces (PUT) the configuration file at path
+// a replacement is validated before it is written and then applied to the store
+func configHandlerFunc(path string, store *configStore) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		disableCache(w)
+		switch r.Method {
+		case http.MethodGet:
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				respond(w, fmt.Sprintf("file at \"%s\": %s", path, err), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", configContentTypes[configFormat(path)])
+			w.Write(content)
+
+		case http.MethodPut:
+			body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxConfigSize))
+			if err != nil {
+				respond(w, fmt.Sprintf("Configuration unreadable; %s", err), http.StatusRequestEntityTooLarge)
+				return
+			}
+			config, err := decodeConfiguration(body, configFormat(path), os.Environ())
+			if err != nil {
+				respond(w, fmt.Sprintf("Invalid configuration; %s", err), http.StatusUnprocessableEntity)
+				return
+			}
+			if err = writeFileAtomic(path, body); err != nil {
+				respond(w, fmt.Sprintf("Configuration not saved; %s", err), http.StatusInternalServerError)
+				return
+			}
+			restart := replaceConfiguration(config, store)
+			configLog.withContext(r.Context()).infof("configuration replaced at %s", path)
+			if restart == nil {
+				restart = []string{}
+			}
+			respondJSON(w, map[string]interface{}{"applied": true, "restart_required": restart})
+
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			respond(w, "Configuration supports GET and PUT", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// networkWritesAllowed returns true if every network listener requires a client certificate
+// endpoints that rewrite the configuration file are otherwise only served on the admin socket
+func networkWritesAllowed(config configuration) bool {
+	if config.tls == nil || !config.tls.requireClientCert() {
+		return false
+	}
+	for _, a := range config.listenAddresses() {
+		if a.scheme != "https" {
+			return false
+		}
+	}
+	return true
+}
+
+// readOnlyHandlerFunc returns a handler function that refuses requests to h other than GET and HEAD
+func readOnlyHandlerFunc(h func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			respond(w, "Changes are only accepted on the admin socket or from clients with a certificate", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// watchConfiguration reloads the configuration file at path when it changes or the process receives SIGHUP
+// the file is polled as no file notification is available without a further dependency
+func watchConfiguration(ctx context.Context, path string, store *configStore, interval time.Duration) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	last, _ := os.Stat(path)
+	go func() {
+		defer signal.Stop(hangup)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-hangup:
+				configLog.infof("SIGHUP received")
+			case <-ticker.C:
+				info, err := os.Stat(path)
+				if err != nil || (last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size()) {
+					continue
+				}
+				last = info
+			case <-ctx.Done():
+				return
+			}
+			if err := reloadConfiguration(path, store); err != nil {
+				configLog.errorf("configuration not reloaded; %v", err)
+			}
+		}
+	}()
+}
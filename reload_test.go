@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReloadHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heihei")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, configFilename)
+	store := &configStore{}
+	handler := reloadHandlerFunc(path, store)
+
+	// invalid configuration is rejected and the current configuration kept
+	if err = ioutil.WriteFile(path, []byte(`{}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", "/reload", nil))
+	if w.Code != 422 {
+		t.Errorf("got status %v; expected 422", w.Code)
+	}
+
+	if err = ioutil.WriteFile(path, []byte(validConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", "/reload", nil))
+	if w.Code != 200 {
+		t.Errorf("got status %v; expected 200", w.Code)
+	}
+	if lat, _ := store.get().latLong(); lat != magNLat {
+		t.Errorf("got latitude %v; expected reloaded value %v", lat, magNLat)
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	old := configuration{lightsOut: "22:00", plugs: defaultPlugs}
+	testCases := []struct {
+		change   func(*configuration)
+		expected []string
+	}{
+		{func(c *configuration) { c.lightsOut = "23:00" }, nil},
+		{func(c *configuration) { c.logging.level = levelDebug }, nil},
+		{func(c *configuration) { c.logToStdout = true }, []string{"logging.output"}},
+		{func(c *configuration) { c.tls = &tlsConfiguration{} }, []string{"tls"}},
+		{func(c *configuration) { c.plugs = []plugConfiguration{{name: "lamp", id: plugTwo}} }, []string{"plugs"}},
+		{func(c *configuration) {
+			c.adminSocket = "/run/heihei.sock"
+			c.accessLog.path = accessLogFilename
+		}, []string{"admin_socket", "access_log"}},
+	}
+	for i, tc := range testCases {
+		c := old
+		tc.change(&c)
+		if got := restartRequired(old, c); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("case %d: got %v; expected %v", i, got, tc.expected)
+		}
+	}
+}
+
+func TestConfigStoreAdjustAndWatch(t *testing.T) {
+	var seen []string
+	store := &configStore{adjust: func(c *configuration) { c.adminSocket = "/flag.sock" }}
+	store.onChange(func(c configuration) { seen = append(seen, c.lightsOut) })
+	store.set(configuration{lightsOut: "22:00"})
+	if got := store.get().adminSocket; got != "/flag.sock" {
+		t.Errorf("got admin socket %q; expected the adjusted value", got)
+	}
+	if !reflect.DeepEqual(seen, []string{"22:00"}) {
+		t.Errorf("got watcher calls %v; expected one", seen)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heihei")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, configFilename)
+
+	if err = writeFileAtomic(path, []byte("one")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(path + backupSuffix); !os.IsNotExist(err) {
+		t.Errorf("expected no backup of a new file; got %v", err)
+	}
+	if err = writeFileAtomic(path, []byte("two")); err != nil {
+		t.Fatal(err)
+	}
+	for p, expected := range map[string]string{path: "two", path + backupSuffix: "one"} {
+		if content, _ := ioutil.ReadFile(p); string(content) != expected {
+			t.Errorf("got %q in %s; expected %q", content, p, expected)
+		}
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 2 {
+		t.Errorf("got %d files; expected temporary files to be removed", len(files))
+	}
+}
+
+func TestConfigHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heihei")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, configFilename)
+	if err = ioutil.WriteFile(path, []byte(validConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store := &configStore{}
+	if err = reloadConfiguration(path, store); err != nil {
+		t.Fatal(err)
+	}
+	handler := configHandlerFunc(path, store)
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/api/v1/config", nil))
+	if w.Code != 200 || w.Body.String() != validConfig {
+		t.Errorf("got %v %q; expected the configuration file", w.Code, w.Body.String())
+	}
+
+	// invalid configuration is neither saved nor applied
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("PUT", "/api/v1/config", strings.NewReader(`{"location":[1,2]}`)))
+	if w.Code != 422 {
+		t.Errorf("got status %v; expected 422", w.Code)
+	}
+	if content, _ := ioutil.ReadFile(path); string(content) != validConfig {
+		t.Errorf("invalid configuration was saved")
+	}
+
+	updated := fmt.Sprintf(`{"location":[%f, %f], "lights_out":"21:15", "log_to_stdout":true, "listen":["http://:9000"]}`,
+		magNLat, magNLon)
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("PUT", "/api/v1/config", strings.NewReader(updated)))
+	if w.Code != 200 {
+		t.Fatalf("got status %v; expected 200: %s", w.Code, w.Body.String())
+	}
+	var result struct {
+		Applied         bool     `json:"applied"`
+		RestartRequired []string `json:"restart_required"`
+	}
+	if err = json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if !result.Applied || !reflect.DeepEqual(result.RestartRequired, []string{"listen"}) {
+		t.Errorf("got %+v; expected applied with restart required for listen", result)
+	}
+	if got := store.get().lightsOut; got != "21:15" {
+		t.Errorf("got lights out %v; expected the new value to be applied", got)
+	}
+	if content, _ := ioutil.ReadFile(path + backupSuffix); string(content) != validConfig {
+		t.Errorf("got backup %q; expected the previous configuration", content)
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("DELETE", "/api/v1/config", nil))
+	if w.Code != 405 {
+		t.Errorf("got status %v; expected 405", w.Code)
+	}
+}
+
+func TestNetworkWritesAllowed(t *testing.T) {
+	https, _ := parseListenAddress("https://:8443")
+	plain, _ := parseListenAddress("http://:8000")
+	testCases := []struct {
+		note     string
+		config   configuration
+		expected bool
+	}{
+		{"no TLS", configuration{}, false},
+		{"no client CA", configuration{tls: &tlsConfiguration{}}, false},
+		{"client CA", configuration{tls: &tlsConfiguration{clientCAFile: "ca.pem"}}, true},
+		{"client CA with HTTP", configuration{tls: &tlsConfiguration{clientCAFile: "ca.pem"}, listen: []listenAddress{https, plain}}, false},
+	}
+	for _, tc := range testCases {
+		if allowed := networkWritesAllowed(tc.config); allowed != tc.expected {
+			t.Errorf("%s: got %v; expected %v", tc.note, allowed, tc.expected)
+		}
+	}
+}
+
+func TestReadOnlyHandler(t *testing.T) {
+	called := 0
+	handler := readOnlyHandlerFunc(func(w http.ResponseWriter, r *http.Request) { called++ })
+	for method, code := range map[string]int{"GET": 200, "PUT": 403, "POST": 403} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(method, "/api/v1/config", nil))
+		if w.Code != code {
+			t.Errorf("%s: got status %v; expected %v", method, w.Code, code)
+		}
+	}
+	if called != 1 {
+		t.Errorf("handler called %d times; expected only for GET", called)
+	}
+}
+
+func TestWatchConfiguration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heihei")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, configFilename)
+	if err = ioutil.WriteFile(path, []byte(validConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	changed := make(chan configuration, 1)
+	store := &configStore{}
+	store.onChange(func(c configuration) { changed <- c })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watchConfiguration(ctx, path, store, 10*time.Millisecond)
+
+	updated := strings.Replace(validConfig, bedtime, "20:45", 1)
+	if err = ioutil.WriteFile(path, []byte(updated), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case c := <-changed:
+		if c.lightsOut != "20:45" {
+			t.Errorf("got lights out %v; expected 20:45", c.lightsOut)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("configuration change not detected")
+	}
+}
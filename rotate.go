@@ -0,0 +1,252 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const compressedSuffix = ".gz"
+
+// rotationConfiguration describes when the log file is rotated and how many old segments are kept
+type rotationConfiguration struct {
+	maxSize  int64         // rotate when the file would grow beyond this many bytes; zero disables
+	maxAge   time.Duration // rotate when the file is older than this; zero disables
+	compress bool          // gzip rotated segments
+	keep     int           // number of rotated segments retained
+}
+
+// defaultRotation is used when the configuration doesn't describe log rotation
+var defaultRotation = rotationConfiguration{maxSize: 10 * 1024 * 1024, keep: 5}
+
+// segmentPath returns the path of the rotated segment with the given number e.g. heihei.log.2
+func segmentPath(path string, number int) string {
+	return fmt.Sprintf("%s.%d", path, number)
+}
+
+// existingSegment returns the path of the rotated segment with the given number, compressed or not
+func existingSegment(path string, number int) (string, bool) {
+	p := segmentPath(path, number)
+	if _, err := os.Stat(p); err == nil {
+		return p, true
+	}
+	if _, err := os.Stat(p + compressedSuffix); err == nil {
+		return p + compressedSuffix, true
+	}
+	return "", false
+}
+
+// rotatedSegments returns the paths of the rotated segments of the log at path, newest first
+func rotatedSegments(path string) (segments []string) {
+	for i := 1; ; i++ {
+		p, ok := existingSegment(path, i)
+		if !ok {
+			return
+		}
+		segments = append(segments, p)
+	}
+}
+
+// openSegment opens a log segment for reading, decompressing it if required
+func openSegment(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, compressedSuffix) {
+		return f, nil
+	}
+	z, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return readCloser{Reader: z, closers: []io.Closer{z, f}}, nil
+}
+
+// readCloser closes several closers when closed
+type readCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r readCloser) Close() (err error) {
+	for _, c := range r.closers {
+		if e := c.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// rotatingFile is a log file that is rotated when it grows too large or too old
+type rotatingFile struct {
+	mu          sync.Mutex
+	path        string
+	config      rotationConfiguration
+	file        *os.File
+	size        int64
+	started     time.Time
+	compressing sync.WaitGroup
+	compressErr error // set by the compression routine, read after waiting for it
+	now         func() time.Time
+}
+
+// openRotatingFile opens the log file at path for appending
+func openRotatingFile(path string, config rotationConfiguration) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, config: config, now: time.Now}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open opens or creates the file at r.path and records its size and start time
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.size = info.Size()
+	r.started = r.now()
+
+	// an existing file started at the time of its first line
+	if r.size > 0 {
+		if f, err := os.Open(r.path); err == nil {
+			s := newLogScanner(f)
+			if s.scan() && !s.at.IsZero() {
+				r.started = s.at
+			}
+			f.Close()
+		}
+	}
+	return nil
+}
+
+// Write appends p to the log file, rotating the file first if required
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.size > 0 && r.needsRotation(int64(len(p))) {
+		if err := r.rotate(); err != nil {
+			// keep logging to the current file rather than lose the message and only retry once it's grown or aged again
+			fmt.Fprintf(r.file, "log rotation failed; %v\n", err)
+			r.size, r.started = 0, r.now()
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// needsRotation returns true if writing n bytes should first rotate the file
+func (r *rotatingFile) needsRotation(n int64) bool {
+	if r.config.maxSize > 0 && r.size+n > r.config.maxSize {
+		return true
+	}
+	return r.config.maxAge > 0 && r.now().Sub(r.started) >= r.config.maxAge
+}
+
+// rotate renames the current file to the first segment, shifting older segments up and removing the oldest
+// the current file is moved aside and kept open until its replacement is open, so a failure leaves logging to it;
+// segments are only removed and compressed once the replacement is open
+func (r *rotatingFile) rotate() error {
+	// a segment still being compressed can't be moved
+	r.compressing.Wait()
+	if r.compressErr != nil {
+		fmt.Fprintf(r.file, "log compression failed; %v\n", r.compressErr)
+		r.compressErr = nil
+	}
+
+	staged := segmentPath(r.path, 0)
+	if _, err := os.Stat(staged); err == nil {
+		return fmt.Errorf("%s is left from an earlier rotation", staged)
+	}
+	if err := os.Rename(r.path, staged); err != nil {
+		return err
+	}
+	rotated := r.file
+	if err := r.open(); err != nil {
+		os.Rename(staged, r.path)
+		return err
+	}
+	rotated.Close()
+	if r.config.keep == 0 {
+		return os.Remove(staged)
+	}
+
+	// remove segments that would be shifted beyond the number retained
+	segments := rotatedSegments(r.path)
+	for len(segments) > r.config.keep-1 {
+		os.Remove(segments[len(segments)-1])
+		segments = segments[:len(segments)-1]
+	}
+
+	// shift the remaining segments, oldest first
+	for i := len(segments); i > 0; i-- {
+		suffix := ""
+		if strings.HasSuffix(segments[i-1], compressedSuffix) {
+			suffix = compressedSuffix
+		}
+		if err := os.Rename(segments[i-1], segmentPath(r.path, i+1)+suffix); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(staged, segmentPath(r.path, 1)); err != nil {
+		return err
+	}
+	if r.config.compress {
+		r.compressing.Add(1)
+		// logging here would deadlock as the log is written through r
+		go func(path string) {
+			defer r.compressing.Done()
+			r.compressErr = compressFile(path)
+		}(segmentPath(r.path, 1))
+	}
+	return nil
+}
+
+// compressFile replaces the file at path with a gzip compressed copy at path.gz
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+compressedSuffix, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	z := gzip.NewWriter(out)
+	if _, err = io.Copy(z, in); err == nil {
+		err = z.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + compressedSuffix)
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Close waits for any compression to finish and closes the current file
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.compressing.Wait()
+	return r.file.Close()
+}
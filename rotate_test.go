@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readSegment returns the content of a log segment
+func readSegment(t *testing.T, path string) string {
+	r, err := openSegment(path)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer r.Close()
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return string(content)
+}
+
+func TestRotateBySize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heihei")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, logFilename)
+
+	r, err := openRotatingFile(path, rotationConfiguration{maxSize: 10, keep: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		fmt.Fprintf(r, "line %d\n", i) // 7 bytes each so every write rotates
+	}
+	r.Close()
+
+	if content := readSegment(t, path); content != "line 3\n" {
+		t.Errorf("current file %q; expected the last line", content)
+	}
+	segments := rotatedSegments(path)
+	if len(segments) != 2 {
+		t.Fatalf("got segments %v; expected 2", segments)
+	}
+	if content := readSegment(t, segments[0]); content != "line 2\n" {
+		t.Errorf("first segment %q; expected line 2", content)
+	}
+	if content := readSegment(t, segments[1]); content != "line 1\n" {
+		t.Errorf("second segment %q; expected line 1", content)
+	}
+}
+
+func TestRotateFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heihei")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, logFilename)
+
+	// a directory that isn't empty can't be replaced by the file being rotated
+	if err = os.MkdirAll(filepath.Join(segmentPath(path, 0), "blocked"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(segmentPath(path, 1), []byte("retained\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := openRotatingFile(path, rotationConfiguration{maxSize: 20, keep: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		if _, err = fmt.Fprintf(r, "line %d\n", i); err != nil {
+			t.Errorf("write %d failed; %v", i, err)
+		}
+	}
+	r.Close()
+
+	// the rotation is retried once the file has grown by the maximum size again rather than for each line
+	content := readSegment(t, path)
+	if strings.Count(content, "log rotation failed") != 1 || !strings.HasSuffix(content, "line 3\n") {
+		t.Errorf("current file %q; expected one failure and every line", content)
+	}
+	if content = readSegment(t, segmentPath(path, 1)); content != "retained\n" {
+		t.Errorf("segment %q; expected it kept", content)
+	}
+}
+
+func TestRotateByAgeCompressed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heihei")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, logFilename)
+
+	now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	r := &rotatingFile{path: path, config: rotationConfiguration{maxAge: time.Hour, compress: true, keep: 3}, now: func() time.Time { return now }}
+	if err = r.open(); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintln(r, "first")
+	now = now.Add(30 * time.Minute)
+	fmt.Fprintln(r, "second")
+	now = now.Add(30 * time.Minute)
+	fmt.Fprintln(r, "third")
+	r.Close()
+
+	segments := rotatedSegments(path)
+	if len(segments) != 1 || !strings.HasSuffix(segments[0], compressedSuffix) {
+		t.Fatalf("got segments %v; expected a single compressed segment", segments)
+	}
+	if content := readSegment(t, segments[0]); content != "first\nsecond\n" {
+		t.Errorf("segment %q; expected the first two lines", content)
+	}
+	if content := readSegment(t, path); content != "third\n" {
+		t.Errorf("current file %q; expected the third line", content)
+	}
+}
+
+func TestRotateKeepNone(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heihei")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, logFilename)
+
+	r, err := openRotatingFile(path, rotationConfiguration{maxSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintln(r, "first line")
+	fmt.Fprintln(r, "second line")
+	r.Close()
+
+	if segments := rotatedSegments(path); len(segments) != 0 {
+		t.Errorf("got segments %v; expected none", segments)
+	}
+	if content := readSegment(t, path); content != "second line\n" {
+		t.Errorf("current file %q; expected the second line", content)
+	}
+}
+
+func TestLogViewerRotated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heihei")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, logFilename)
+	files := map[string]string{
+		path:                 "2018/06/03 10:00:00 main.go:1: three\n",
+		segmentPath(path, 1): "2018/06/02 10:00:00 main.go:1: two\n",
+		segmentPath(path, 2): "2018/06/01 10:00:00 main.go:1: one\n",
+	}
+	for p, content := range files {
+		if err = ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = compressFile(segmentPath(path, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		query    string
+		expected string
+	}{
+		{"", files[path]},
+		{"?rotated=1", files[segmentPath(path, 1)] + files[path]},
+		{"?rotated=all&q=t", files[segmentPath(path, 1)] + files[path]},
+		{"?rotated=all&q=one", files[segmentPath(path, 2)]},
+		{"?rotated=all&tail=2", files[segmentPath(path, 1)] + files[path]},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("query %v", tc.query), func(t *testing.T) {
+			w := httptest.NewRecorder()
+			logViewerHandlerFunc(path)(w, httptest.NewRequest("GET", "/logfile"+tc.query, nil))
+			if w.Body.String() != tc.expected {
+				t.Errorf("got %q; expected %q", w.Body.String(), tc.expected)
+			}
+		})
+	}
+}
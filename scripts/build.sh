@@ -7,6 +7,11 @@ for var in "$@"; do
     esac
 done
 
+# build metadata reported by /about
+COMMIT=$(git rev-parse --short HEAD 2>/dev/null || echo unknown)
+DATE=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+LDFLAGS="-X main.gitCommit=${COMMIT} -X main.buildDate=${DATE}"
+
 # call go generate
 go generate
 
@@ -14,9 +19,9 @@ if [ "$isDevel" = true ]; then
     echo "go test"
     go test -race -cover
     echo "devel build"
-    go build -race
+    go build -race -ldflags "${LDFLAGS}"
 else
     echo "release build"
     go generate -tags 'rapi'
-    GOOS=linux GOARCH=arm GOARM=7 go build -tags 'rapi'
+    GOOS=linux GOARCH=arm GOARM=7 go build -tags 'rapi' -ldflags "${LDFLAGS}"
 fi
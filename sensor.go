@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sensor quantities, named with their unit
+const (
+	quantityTemperature = "temperature_celsius"
+	quantityHumidity    = "humidity_percent"
+	quantityPressure    = "pressure_hpa"
+	quantityIlluminance = "illuminance_lux"
+)
+
+// sensor types
+const (
+	sensorBME280  = "bme280"
+	sensorBH1750  = "bh1750"
+	sensorDS18B20 = "ds18b20"
+)
+
+const (
+	defaultSensorInterval = time.Minute
+	defaultSensorSamples  = 1440 // a day of readings at the default interval
+	sensorHistoryFilename = "sensors.jsonl"
+)
+
+// sensorLog is the logger for the sensor subsystem
+var sensorLog = newLogger("sensor")
+
+// sensor measures one or more quantities
+type sensor interface {
+	sense() (map[string]float64, error)
+}
+
+// sensorConfiguration describes a sensor and how often it is read
+type sensorConfiguration struct {
+	name     string
+	kind     string
+	bus      string // the I2C bus name or number of an I2C sensor; empty for the first bus
+	address  uint16 // the address of an I2C sensor
+	probe    string // the 1-Wire id of a DS18B20; empty for the first probe on the bus
+	interval time.Duration
+}
+
+// sensorHistoryConfiguration describes how many readings are kept in memory for each sensor and the file that keeps them all
+type sensorHistoryConfiguration struct {
+	samples int
+	path    string
+}
+
+// defaultSensorHistory is used when the configuration doesn't describe the sensor history
+var defaultSensorHistory = sensorHistoryConfiguration{samples: defaultSensorSamples, path: sensorHistoryFilename}
+
+// sensorReading is the values measured by a sensor at a time
+type sensorReading struct {
+	Sensor string             `json:"sensor"`
+	Time   time.Time          `json:"time"`
+	Values map[string]float64 `json:"values"`
+}
+
+// readingRing holds the most recent readings of a sensor
+type readingRing struct {
+	readings []sensorReading
+	next     int // index that the next reading is written to
+	full     bool
+}
+
+func newReadingRing(size int) *readingRing {
+	return &readingRing{readings: make([]sensorReading, size)}
+}
+
+// add adds r, replacing the oldest reading if the ring is full
+func (r *readingRing) add(reading sensorReading) {
+	r.readings[r.next] = reading
+	r.next = (r.next + 1) % len(r.readings)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// since returns the readings after t, oldest first
+func (r *readingRing) since(t time.Time) []sensorReading {
+	ordered := r.readings[:r.next]
+	if r.full {
+		ordered = append(append([]sensorReading(nil), r.readings[r.next:]...), ordered...)
+	}
+	readings := []sensorReading{}
+	for _, reading := range ordered {
+		if reading.Time.After(t) {
+			readings = append(readings, reading)
+		}
+	}
+	return readings
+}
+
+// latest returns the most recent reading or nil if there is none
+func (r *readingRing) latest() *sensorReading {
+	if r.next == 0 && !r.full {
+		return nil
+	}
+	latest := r.readings[(r.next+len(r.readings)-1)%len(r.readings)]
+	return &latest
+}
+
+// sensorStatus reports the latest reading of a sensor
+type sensorStatus struct {
+	Name   string         `json:"name"`
+	Type   string         `json:"type"`
+	Latest *sensorReading `json:"latest,omitempty"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// sensorMonitor keeps the readings of the sensors
+type sensorMonitor struct {
+	mu      sync.Mutex
+	sensors []sensorConfiguration
+	history map[string]*readingRing
+	errors  map[string]string
+	file    io.Writer // records every reading; nil if readings aren't kept on disk
+}
+
+// newSensorMonitor creates a monitor for the configured sensors keeping samples readings of each in memory
+func newSensorMonitor(sensors []sensorConfiguration, samples int) *sensorMonitor {
+	m := &sensorMonitor{sensors: sensors, history: make(map[string]*readingRing), errors: make(map[string]string)}
+	for _, s := range sensors {
+		m.history[s.name] = newReadingRing(samples)
+	}
+	return m
+}
+
+// record adds a reading to the history of its sensor and appends it to the file
+func (m *sensorMonitor) record(r sensorReading) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ring, ok := m.history[r.Sensor]
+	if !ok {
+		return
+	}
+	ring.add(r)
+	delete(m.errors, r.Sensor)
+	if m.file != nil {
+		line, err := json.Marshal(r)
+		if err == nil {
+			_, err = fmt.Fprintf(m.file, "%s\n", line)
+		}
+		if err != nil {
+			sensorLog.errorf("sensor history write error %v", err)
+		}
+	}
+}
+
+// recordError notes that the named sensor couldn't be read
+func (m *sensorMonitor) recordError(name string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errors[name] = err.Error()
+}
+
+// load adds the readings of configured sensors from a file written by record
+// lines that can't be decoded, such as one cut short by a power failure, are skipped
+func (m *sensorMonitor) load(r io.Reader) (loaded int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var reading sensorReading
+		if json.Unmarshal(scanner.Bytes(), &reading) != nil {
+			continue
+		}
+		if ring, ok := m.history[reading.Sensor]; ok {
+			ring.add(reading)
+			loaded++
+		}
+	}
+	return loaded
+}
+
+// status returns the latest reading of each sensor in configuration order; a nil monitor has no sensors
+func (m *sensorMonitor) status() []sensorStatus {
+	sensors := []sensorStatus{}
+	if m == nil {
+		return sensors
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range m.sensors {
+		sensors = append(sensors, sensorStatus{Name: s.name, Type: s.kind, Latest: m.history[s.name].latest(), Error: m.errors[s.name]})
+	}
+	return sensors
+}
+
+// readings returns the readings of the named sensor after t, oldest first, or false if there is no such sensor
+func (m *sensorMonitor) readings(name string, t time.Time) ([]sensorReading, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ring, ok := m.history[name]
+	if !ok {
+		return nil, false
+	}
+	return ring.since(t), true
+}
+
+// writeMetrics writes a gauge for each quantity measured by the sensors
+func (m *sensorMonitor) writeMetrics(w io.Writer) {
+	samples := make(map[string][]gaugeSample)
+	for _, s := range m.status() {
+		if s.Latest == nil {
+			continue
+		}
+		for quantity, value := range s.Latest.Values {
+			samples[quantity] = append(samples[quantity], gaugeSample{values: []string{s.Name}, value: value})
+		}
+	}
+	quantities := make([]string, 0, len(samples))
+	for quantity := range samples {
+		quantities = append(quantities, quantity)
+	}
+	sort.Strings(quantities)
+	for _, quantity := range quantities {
+		writeGauge(w, "heihei_sensor_"+quantity, "Latest "+strings.Replace(quantity, "_", " in ", 1)+" reading of the sensor.",
+			[]string{"sensor"}, samples[quantity])
+	}
+}
+
+// openSensorHistory loads the readings kept at path and opens the file to record new readings
+func openSensorHistory(m *sensorMonitor, path string, rotation rotationConfiguration) (io.WriteCloser, error) {
+	if f, err := os.Open(path); err == nil {
+		n := m.load(f)
+		f.Close()
+		sensorLog.infof("loaded %d readings from %s", n, path)
+	}
+	file, err := openRotatingFile(path, rotation)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	m.file = file
+	m.mu.Unlock()
+	return file, nil
+}
+
+// ds18b20 is a 1-Wire temperature probe
+type ds18b20 string
+
+func (d ds18b20) sense() (map[string]float64, error) {
+	celsius, err := readDS18B20(string(d))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]float64{quantityTemperature: celsius}, nil
+}
+
+func (b *bh1750) sense() (map[string]float64, error) {
+	lux, err := b.lux()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]float64{quantityIlluminance: lux}, nil
+}
+
+// openSensor opens the sensor described by c; the returned closer releases its bus and may be nil
+func openSensor(c sensorConfiguration) (sensor, io.Closer, error) {
+	if c.kind == sensorDS18B20 {
+		return ds18b20(c.probe), nil, nil
+	}
+	bus, err := openI2CBus(c.bus)
+	if err != nil {
+		return nil, nil, err
+	}
+	var s sensor
+	switch c.kind {
+	case sensorBME280:
+		s, err = newBME280(bus, c.address)
+	case sensorBH1750:
+		s, err = newBH1750(bus, c.address)
+	default:
+		err = fmt.Errorf("unknown sensor type %s", c.kind)
+	}
+	if err != nil {
+		bus.Close()
+		return nil, nil, err
+	}
+	return s, bus, nil
+}
+
+// startSensors starts a routine for each sensor that reads it at its interval
+// a sensor is opened with open on first use and reopened after a failure, so a sensor that is connected later is found
+func startSensors(ctx context.Context, m *sensorMonitor, open func(sensorConfiguration) (sensor, io.Closer, error)) {
+	for _, c := range m.sensors {
+		go func(c sensorConfiguration) {
+			l := sensorLog.with(fields{"sensor": c.name})
+			var s sensor
+			var closer io.Closer
+			for {
+				var err error
+				if s == nil {
+					s, closer, err = open(c)
+				}
+				var values map[string]float64
+				if err == nil {
+					values, err = s.sense()
+				}
+				if err != nil {
+					l.warnf("sensor read error %v", err)
+					m.recordError(c.name, err)
+					if closer != nil {
+						closer.Close()
+					}
+					s, closer = nil, nil
+				} else {
+					m.record(sensorReading{Sensor: c.name, Time: time.Now(), Values: values})
+				}
+
+				timer := time.NewTimer(c.interval)
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+					if closer != nil {
+						closer.Close()
+					}
+					return
+				}
+			}
+		}(c)
+	}
+}
+
+// sensorsPath is the API path of the sensors; the history of a sensor is found under it by name
+const sensorsPath = "/api/v1/sensors"
+
+// sensorsHandlerFunc returns a handler function that reports the latest reading of each sensor as JSON
+// /api/v1/sensors/name reports the readings of a sensor kept in memory, limited to those after the since parameter
+func sensorsHandlerFunc(m *sensorMonitor) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		disableCache(w)
+		name := strings.Trim(strings.TrimPrefix(r.URL.Path, sensorsPath), "/")
+		if name == "" {
+			respondJSON(w, m.status())
+			return
+		}
+		var since time.Time
+		if s := r.URL.Query().Get("since"); s != "" {
+			var err error
+			if since, err = time.Parse(time.RFC3339, s); err != nil {
+				respond(w, fmt.Sprintf("since should be an RFC 3339 time; %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+		readings, ok := m.readings(name, since)
+		if !ok {
+			respond(w, fmt.Sprintf("Sensor %s not found", name), http.StatusNotFound)
+			return
+		}
+		respondJSON(w, readings)
+	}
+}
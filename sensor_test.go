@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadingRing(t *testing.T) {
+	start := time.Date(2020, time.December, 1, 12, 0, 0, 0, time.UTC)
+	reading := func(i int) sensorReading {
+		return sensorReading{Sensor: "lounge", Time: start.Add(time.Duration(i) * time.Minute), Values: map[string]float64{quantityTemperature: float64(i)}}
+	}
+	ring := newReadingRing(3)
+	if ring.latest() != nil || len(ring.since(time.Time{})) != 0 {
+		t.Errorf("expected an empty ring")
+	}
+	for i := 0; i < 5; i++ {
+		ring.add(reading(i))
+	}
+	if latest := ring.latest(); latest == nil || !reflect.DeepEqual(*latest, reading(4)) {
+		t.Errorf("latest %v; expected %v", latest, reading(4))
+	}
+	if readings := ring.since(time.Time{}); !reflect.DeepEqual(readings, []sensorReading{reading(2), reading(3), reading(4)}) {
+		t.Errorf("got %v; expected the last three readings in order", readings)
+	}
+	if readings := ring.since(start.Add(3 * time.Minute)); !reflect.DeepEqual(readings, []sensorReading{reading(4)}) {
+		t.Errorf("got %v; expected the last reading", readings)
+	}
+}
+
+func TestSensorHistoryFile(t *testing.T) {
+	sensors := []sensorConfiguration{{name: "lounge", kind: sensorBME280}, {name: "heater", kind: sensorDS18B20}}
+	var file bytes.Buffer
+	m := newSensorMonitor(sensors, 10)
+	m.file = &file
+	now := time.Date(2020, time.December, 1, 12, 0, 0, 0, time.UTC)
+	m.record(sensorReading{Sensor: "lounge", Time: now, Values: map[string]float64{quantityTemperature: 20.5, quantityHumidity: 45}})
+	m.record(sensorReading{Sensor: "heater", Time: now, Values: map[string]float64{quantityTemperature: 40}})
+	m.record(sensorReading{Sensor: "removed", Time: now, Values: map[string]float64{quantityTemperature: 1}})
+
+	// a reading cut short is skipped when loaded
+	file.WriteString(`{"sensor":"lounge","time":`)
+	reloaded := newSensorMonitor(sensors[:1], 10)
+	if n := reloaded.load(&file); n != 1 {
+		t.Errorf("loaded %d readings; expected 1", n)
+	}
+	if !reflect.DeepEqual(reloaded.status()[0], m.status()[0]) {
+		t.Errorf("got %+v; expected %+v", reloaded.status()[0], m.status()[0])
+	}
+}
+
+// fakeSensor returns its readings in turn, failing if there are none left
+type fakeSensor struct {
+	mu       sync.Mutex
+	readings []map[string]float64
+}
+
+func (s *fakeSensor) sense() (map[string]float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.readings) == 0 {
+		return nil, errors.New("sensor disconnected")
+	}
+	r := s.readings[0]
+	s.readings = s.readings[1:]
+	return r, nil
+}
+
+func TestStartSensors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sensors := []sensorConfiguration{{name: "lounge", kind: sensorBME280, interval: 10 * time.Millisecond}}
+	m := newSensorMonitor(sensors, 10)
+
+	opened := make(chan bool, 10)
+	fake := &fakeSensor{readings: []map[string]float64{{quantityTemperature: 20}, {quantityTemperature: 21}}}
+	startSensors(ctx, m, func(c sensorConfiguration) (sensor, io.Closer, error) {
+		opened <- true
+		if len(opened) > 1 {
+			return nil, nil, errors.New("not found")
+		}
+		return fake, nil, nil
+	})
+
+	deadline := time.Now().Add(time.Second)
+	for (m.status()[0].Error == "" || len(opened) < 2) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	s := m.status()[0]
+	if s.Latest == nil || s.Latest.Values[quantityTemperature] != 21 || s.Error == "" {
+		t.Errorf("status %+v; expected the second reading and an error", s)
+	}
+	if len(opened) < 2 {
+		t.Errorf("sensor opened %d times; expected it to be reopened after the failure", len(opened))
+	}
+}
+
+func TestSensorsHandler(t *testing.T) {
+	m := newSensorMonitor([]sensorConfiguration{{name: "lounge", kind: sensorBME280}}, 10)
+	start := time.Date(2020, time.December, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		m.record(sensorReading{Sensor: "lounge", Time: start.Add(time.Duration(i) * time.Minute), Values: map[string]float64{quantityPressure: 1000}})
+	}
+	m.recordError("lounge", errors.New("not ignored"))
+	handler := sensorsHandlerFunc(m)
+
+	testCases := []struct {
+		path     string
+		code     int
+		readings int
+	}{
+		{"/api/v1/sensors/lounge", http.StatusOK, 3},
+		{"/api/v1/sensors/lounge?since=2020-12-01T12:00:30Z", http.StatusOK, 2},
+		{"/api/v1/sensors/lounge?since=yesterday", http.StatusBadRequest, 0},
+		{"/api/v1/sensors/hall", http.StatusNotFound, 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler(w, httptest.NewRequest("GET", tc.path, nil))
+			if w.Code != tc.code {
+				t.Fatalf("status %d; expected %d", w.Code, tc.code)
+			}
+			if tc.code != http.StatusOK {
+				return
+			}
+			var readings []sensorReading
+			if err := json.Unmarshal(w.Body.Bytes(), &readings); err != nil || len(readings) != tc.readings {
+				t.Errorf("got %d readings %v; expected %d", len(readings), err, tc.readings)
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/api/v1/sensors", nil))
+	var status []sensorStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status) != 1 || status[0].Name != "lounge" || status[0].Latest == nil || status[0].Error != "not ignored" {
+		t.Errorf("got %+v; expected the latest reading of lounge and its error", status)
+	}
+}
+
+func TestSensorMetrics(t *testing.T) {
+	m := newSensorMonitor([]sensorConfiguration{{name: "lounge", kind: sensorBME280}, {name: "hall", kind: sensorBH1750}}, 10)
+	m.record(sensorReading{Sensor: "lounge", Time: time.Now(), Values: map[string]float64{quantityTemperature: 20.5, quantityHumidity: 45}})
+
+	var b strings.Builder
+	m.writeMetrics(&b)
+	for _, expected := range []string{
+		"# TYPE heihei_sensor_humidity_percent gauge\n",
+		"heihei_sensor_humidity_percent{sensor=\"lounge\"} 45\n",
+		"# HELP heihei_sensor_temperature_celsius Latest temperature in celsius reading of the sensor.\n",
+		"heihei_sensor_temperature_celsius{sensor=\"lounge\"} 20.5\n",
+	} {
+		if !strings.Contains(b.String(), expected) {
+			t.Errorf("metrics missing %q in\n%s", expected, b.String())
+		}
+	}
+	if strings.Contains(b.String(), "hall") {
+		t.Errorf("metrics include a sensor without a reading\n%s", b.String())
+	}
+}
+
+func TestGetConfigSensors(t *testing.T) {
+	buf := bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", "sensors":[
+		{"name":"lounge", "type":"bme280", "address":119, "interval_seconds":30},
+		{"name":"window", "type":"bh1750", "bus":"1"},
+		{"name":"tank", "type":"ds18b20", "probe":"28-0316a2795dff"}], "sensor_history":{"samples":60}}`,
+		magNLat, magNLon, bedtime))
+	config, err := getConfiguration(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []sensorConfiguration{
+		{name: "lounge", kind: sensorBME280, address: bme280AddressHigh, interval: 30 * time.Second},
+		{name: "window", kind: sensorBH1750, bus: "1", address: bh1750AddressLow, interval: defaultSensorInterval},
+		{name: "tank", kind: sensorDS18B20, probe: "28-0316a2795dff", interval: defaultSensorInterval},
+	}
+	if !reflect.DeepEqual(config.sensors, expected) {
+		t.Errorf("got %+v; expected %+v", config.sensors, expected)
+	}
+	if expected := (sensorHistoryConfiguration{samples: 60, path: sensorHistoryFilename}); config.sensorHistory != expected {
+		t.Errorf("got %+v; expected %+v", config.sensorHistory, expected)
+	}
+
+	for _, sensors := range []string{
+		`[{"type":"bme280"}]`,
+		`[{"name":"lounge", "type":"bme280"}, {"name":"lounge", "type":"bh1750"}]`,
+		`[{"name":"lounge", "type":"dht22"}]`,
+		`[{"name":"lounge", "type":"bme280", "address":35}]`,
+		`[{"name":"lounge", "type":"bme280", "probe":"28-0316a2795dff"}]`,
+		`[{"name":"tank", "type":"ds18b20", "address":118}]`,
+		`[{"name":"lounge", "type":"bme280", "interval_seconds":0}]`,
+	} {
+		buf = bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", "sensors":%s}`, magNLat, magNLon, bedtime, sensors))
+		if _, err = getConfiguration(buf); err == nil {
+			t.Errorf("expected error for sensors %s", sensors)
+		}
+	}
+	buf = bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", "sensor_history":{"samples":0}}`, magNLat, magNLon, bedtime))
+	if _, err = getConfiguration(buf); err == nil {
+		t.Errorf("expected error for no samples")
+	}
+}
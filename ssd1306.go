@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+
+	"periph.io/x/periph/conn/i2c"
+)
+
+// SSD1306 addresses, selected by the level of the SA0 pin
+const (
+	ssd1306AddressLow  = 0x3c
+	ssd1306AddressHigh = 0x3d
+)
+
+// SSD1306 geometry; the display memory is 8 pages of 128 columns, each column of a page holding 8 pixels with the top one in bit 0
+const (
+	ssd1306Width  = 128
+	ssd1306Height = 64
+	ssd1306Pages  = ssd1306Height / 8
+)
+
+// SSD1306 control bytes that start each transaction
+const (
+	ssd1306Command = 0x00
+	ssd1306Data    = 0x40
+)
+
+// SSD1306 commands
+const (
+	ssd1306DisplayOff  = 0xae
+	ssd1306DisplayOn   = 0xaf
+	ssd1306ColumnRange = 0x21
+	ssd1306PageRange   = 0x22
+)
+
+// ssd1306Init configures a 128x64 panel with the internal charge pump and horizontal addressing, leaving it blank and switched on
+var ssd1306Init = []byte{
+	ssd1306DisplayOff,
+	0xd5, 0x80, // clock divide ratio and oscillator frequency
+	0xa8, ssd1306Height - 1, // multiplex ratio
+	0xd3, 0x00, // display offset
+	0x40,       // start line 0
+	0x8d, 0x14, // enable the charge pump
+	0x20, 0x00, // horizontal addressing
+	0xa1,       // column 127 is mapped to segment 0
+	0xc8,       // scan the rows from the bottom
+	0xda, 0x12, // alternative row pin configuration
+	0x81, 0xcf, // contrast
+	0xd9, 0xf1, // pre-charge period
+	0xdb, 0x40, // row deselect level
+	0xa4, // show the display memory
+	0xa6, // not inverted
+	ssd1306DisplayOn,
+}
+
+// frame is the content of an SSD1306 display memory
+type frame [ssd1306Pages * ssd1306Width]byte
+
+// ssd1306 is a Solomon Systech SSD1306 128x64 OLED display on an I2C bus
+type ssd1306 struct {
+	dev i2c.Dev
+}
+
+// newSSD1306 initialises the display at addr
+func newSSD1306(bus i2c.Bus, addr uint16) (*ssd1306, error) {
+	d := &ssd1306{dev: i2c.Dev{Bus: bus, Addr: addr}}
+	if err := d.command(ssd1306Init...); err != nil {
+		return nil, fmt.Errorf("SSD1306 at %#x not responding; %v", addr, err)
+	}
+	return d, nil
+}
+
+// command sends commands with their parameters
+func (d *ssd1306) command(c ...byte) error {
+	return d.dev.Tx(append([]byte{ssd1306Command}, c...), nil)
+}
+
+// draw writes f to the display memory a page at a time
+func (d *ssd1306) draw(f *frame) error {
+	if err := d.command(ssd1306ColumnRange, 0, ssd1306Width-1, ssd1306PageRange, 0, ssd1306Pages-1); err != nil {
+		return err
+	}
+	for page := 0; page < ssd1306Pages; page++ {
+		data := append([]byte{ssd1306Data}, f[page*ssd1306Width:(page+1)*ssd1306Width]...)
+		if err := d.dev.Tx(data, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// off switches the display off, leaving the display memory unchanged
+func (d *ssd1306) off() error {
+	return d.command(ssd1306DisplayOff)
+}
+
+func (d *ssd1306) String() string {
+	return fmt.Sprintf("SSD1306{%s}", &d.dev)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"periph.io/x/periph/conn/gpio"
+)
+
+// maxQueuedTransmits limits the double blinks waiting to be shown so that a burst of transmissions doesn't hold up the other patterns
+const maxQueuedTransmits = 4
+
+// statusLEDLog is the logger for the status LED subsystem
+var statusLEDLog = newLogger("led")
+
+// statusLEDConfiguration describes an LED that signals the state of the server and the patterns it shows
+type statusLEDConfiguration struct {
+	led       string // the sysfs name of an on-board LED, such as led0 for the ACT LED of a Raspberry Pi
+	pin       string // the GPIO pin driving an LED; used if led is empty
+	activeLow bool
+	heartbeat bool // blink slowly while healthy
+	errors    bool // blink fast while there are pin errors
+	transmit  bool // blink twice for each RF transmission
+	alarm     bool // light while the alarm is ringing
+}
+
+// statusLEDOutput returns the part of the configuration that is only read at start up
+func statusLEDOutput(c *statusLEDConfiguration) interface{} {
+	if c == nil {
+		return nil
+	}
+	return [3]interface{}{c.led, c.pin, c.activeLow}
+}
+
+// ledStep holds the LED on or off for a duration
+type ledStep struct {
+	on bool
+	d  time.Duration
+}
+
+// ledPattern is a sequence of steps shown by the LED
+// an interruptible pattern is cut short when an event changes the state so that the new state is shown promptly
+type ledPattern struct {
+	name          string
+	steps         []ledStep
+	interruptible bool
+}
+
+// LED patterns
+var (
+	ledHeartbeat = ledPattern{name: "heartbeat", steps: []ledStep{{true, 100 * time.Millisecond}, {false, 1900 * time.Millisecond}}, interruptible: true}
+	ledErrors    = ledPattern{name: "errors", steps: []ledStep{{true, 100 * time.Millisecond}, {false, 100 * time.Millisecond}}}
+	ledTransmit  = ledPattern{name: "transmit", steps: []ledStep{{true, 80 * time.Millisecond}, {false, 120 * time.Millisecond},
+		{true, 80 * time.Millisecond}, {false, 320 * time.Millisecond}}}
+	ledAlarm = ledPattern{name: "alarm", steps: []ledStep{{true, time.Second}}, interruptible: true}
+	ledOff   = ledPattern{name: "off", steps: []ledStep{{false, time.Second}}, interruptible: true}
+)
+
+// ledState is what the status LED has learnt from the events and health checks
+type ledState struct {
+	ringing   bool
+	transmits int // double blinks still to be shown
+	pinError  bool
+}
+
+// update records the effect of e on the state and returns true if it changed
+func (s *ledState) update(e event) bool {
+	switch e.Type {
+	case eventPlug:
+		// a change made by a remote is received rather than transmitted
+		if e.Source != sourceRemote && s.transmits < maxQueuedTransmits {
+			s.transmits++
+			return true
+		}
+	case eventAlarm:
+		ringing := s.ringing
+		if !e.On {
+			s.ringing = false
+		} else if e.Message == "ringing" {
+			s.ringing = true
+		}
+		return ringing != s.ringing
+	}
+	return false
+}
+
+// nextPattern returns the pattern to show next; a ringing alarm comes first, then transmissions, pin errors and the heartbeat
+// patterns that aren't enabled by c are skipped and a nil configuration leaves the LED off
+func (s *ledState) nextPattern(c *statusLEDConfiguration) ledPattern {
+	if c == nil {
+		return ledOff
+	}
+	switch {
+	case c.alarm && s.ringing:
+		return ledAlarm
+	case c.transmit && s.transmits > 0:
+		s.transmits--
+		return ledTransmit
+	case c.errors && s.pinError:
+		return ledErrors
+	case c.heartbeat:
+		return ledHeartbeat
+	}
+	return ledOff
+}
+
+// pinsHealthy returns true if the pins were initialised and the last transmission to each plug succeeded
+func pinsHealthy() bool {
+	return checkHAL().OK && checkTransmits().OK
+}
+
+// startStatusLED starts a routine that shows the state of the server on the configured LED
+// the LED is opened with open; healthy reports whether there are pin errors
+// nothing is started if no status LED is configured
+func startStatusLED(ctx context.Context, store *configStore, bus *eventBus, open func(statusLEDConfiguration) (gpio.PinOut, io.Closer, error), healthy func() bool) {
+	c := store.get().statusLED
+	if c == nil {
+		return
+	}
+	out, closer, err := open(*c)
+	if err != nil {
+		statusLEDLog.errorf("status LED unavailable; %v", err)
+		return
+	}
+	events := bus.subscribe()
+	go func() {
+		defer func() {
+			bus.unsubscribe(events)
+			if closer != nil {
+				closer.Close()
+			}
+		}()
+		l := statusLEDLog.with(fields{"led": c.led + c.pin})
+		activeLow := c.activeLow
+		var state ledState
+		for {
+			state.pinError = !healthy()
+			pattern := state.nextPattern(store.get().statusLED)
+			if !showPattern(ctx, out, activeLow, pattern, events, &state, l) {
+				out.Out(gpio.Level(activeLow))
+				return
+			}
+		}
+	}()
+}
+
+// showPattern shows the steps of pattern, updating state with the events received meanwhile
+// false is returned once ctx is done or the bus has stopped
+func showPattern(ctx context.Context, out gpio.PinOut, activeLow bool, pattern ledPattern, events <-chan event, state *ledState, l logger) bool {
+	for _, step := range pattern.steps {
+		if err := out.Out(gpio.Level(step.on != activeLow)); err != nil {
+			l.warnf("status LED %s error %v", pattern.name, err)
+		}
+		timer := time.NewTimer(step.d)
+	wait:
+		for {
+			select {
+			case <-timer.C:
+				break wait
+			case e, ok := <-events:
+				if !ok {
+					timer.Stop()
+					return false
+				}
+				if state.update(e) && pattern.interruptible {
+					timer.Stop()
+					return true
+				}
+			case <-ctx.Done():
+				timer.Stop()
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"periph.io/x/periph/conn/gpio"
+)
+
+func TestLEDNextPattern(t *testing.T) {
+	all := &statusLEDConfiguration{led: "led0", heartbeat: true, errors: true, transmit: true, alarm: true}
+	testCases := []struct {
+		note     string
+		state    ledState
+		config   *statusLEDConfiguration
+		expected []string
+	}{
+		{"heartbeat when healthy", ledState{}, all, []string{"heartbeat", "heartbeat"}},
+		{"errors before the heartbeat", ledState{pinError: true}, all, []string{"errors", "errors"}},
+		{"each transmit then errors", ledState{transmits: 2, pinError: true}, all, []string{"transmit", "transmit", "errors"}},
+		{"alarm before everything", ledState{ringing: true, transmits: 1, pinError: true}, all, []string{"alarm", "alarm"}},
+		{"disabled patterns skipped", ledState{ringing: true, transmits: 1},
+			&statusLEDConfiguration{led: "led0", heartbeat: true}, []string{"heartbeat"}},
+		{"off without patterns", ledState{pinError: true}, &statusLEDConfiguration{led: "led0"}, []string{"off"}},
+		{"off without configuration", ledState{ringing: true}, nil, []string{"off"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.note, func(t *testing.T) {
+			var patterns []string
+			for range tc.expected {
+				patterns = append(patterns, tc.state.nextPattern(tc.config).name)
+			}
+			if !reflect.DeepEqual(patterns, tc.expected) {
+				t.Errorf("got %v; expected %v", patterns, tc.expected)
+			}
+		})
+	}
+}
+
+func TestLEDStateUpdate(t *testing.T) {
+	var s ledState
+	if !s.update(event{Type: eventAlarm, On: true, Message: "ringing"}) || !s.ringing {
+		t.Errorf("expected a ringing alarm to change the state")
+	}
+	if s.update(event{Type: eventAlarm, On: true, Message: "ringing"}) || s.update(event{Type: eventNotification}) {
+		t.Errorf("expected no change from repeated rings and notifications")
+	}
+	if !s.update(event{Type: eventAlarm}) || s.ringing {
+		t.Errorf("expected unsetting the alarm to stop it ringing")
+	}
+	for i := 0; i < maxQueuedTransmits+2; i++ {
+		s.update(event{Type: eventPlug, On: true})
+	}
+	if s.transmits != maxQueuedTransmits {
+		t.Errorf("%d transmits queued; expected %d", s.transmits, maxQueuedTransmits)
+	}
+}
+
+// recordingPin is a simulated pin that records the levels it is driven to
+type recordingPin struct {
+	*simulatedPin
+	mu     sync.Mutex
+	levels []gpio.Level
+}
+
+func (r *recordingPin) Out(l gpio.Level) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.levels = append(r.levels, l)
+	return nil
+}
+
+func (r *recordingPin) recorded() []gpio.Level {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]gpio.Level(nil), r.levels...)
+}
+
+func TestStartStatusLED(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bus := newEventBus(ctx)
+	led := &recordingPin{simulatedPin: newSimulatedPin("led0")}
+	store := &configStore{config: configuration{statusLED: &statusLEDConfiguration{led: "led0", activeLow: true, transmit: true, alarm: true}}}
+	startStatusLED(ctx, store, bus, func(c statusLEDConfiguration) (gpio.PinOut, io.Closer, error) {
+		return led, nil, nil
+	}, func() bool { return true })
+
+	// waits for the LED to be driven to the levels after those already recorded
+	expect := func(note string, expected ...gpio.Level) {
+		from := len(led.recorded())
+		deadline := time.Now().Add(2 * time.Second)
+		for len(led.recorded()) < from+len(expected) && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if levels := led.recorded(); len(levels) < from+len(expected) || !reflect.DeepEqual(levels[from:from+len(expected)], expected) {
+			t.Errorf("%s: got levels %v after %d; expected %v", note, levels, from, expected)
+		}
+	}
+
+	// the LED is active low so it is lit by driving it low
+	expect("off", gpio.High)
+	bus.publish(event{Type: eventPlug, Name: "light", On: true})
+	expect("double blink", gpio.Low, gpio.High, gpio.Low, gpio.High)
+	bus.publish(event{Type: eventAlarm, On: true, Message: "ringing"})
+	expect("alarm", gpio.Low, gpio.Low)
+	bus.publish(event{Type: eventAlarm})
+	expect("alarm stopped", gpio.High)
+}
+
+func TestGetConfigStatusLED(t *testing.T) {
+	buf := bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", "status_led":{"led":"led0", "heartbeat":false}}`,
+		magNLat, magNLon, bedtime))
+	config, err := getConfiguration(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &statusLEDConfiguration{led: "led0", errors: true, transmit: true, alarm: true}
+	if !reflect.DeepEqual(config.statusLED, expected) {
+		t.Errorf("got %+v; expected %+v", config.statusLED, expected)
+	}
+
+	for _, led := range []string{
+		`{}`,
+		`{"led":"led0", "pin":"GPIO26"}`,
+		`{"pin":"GPIO16"}`,
+		`{"pin":"GPIO26", "blink":true}`,
+	} {
+		buf = bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", "buttons":[{"pin":"GPIO16", "plug":"light"}], "status_led":%s}`,
+			magNLat, magNLon, bedtime, led))
+		if _, err = getConfiguration(buf); err == nil {
+			t.Errorf("expected error for status LED %s", led)
+		}
+	}
+}
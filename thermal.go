@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// thermalLog is the logger for the thermal subsystem
+var thermalLog = newLogger("thermal")
+
+// thermalConfiguration describes the sampling of the thermal zones; a zero threshold disables alerts
+type thermalConfiguration struct {
+	interval   time.Duration
+	threshold  float64 // degrees Celsius above which an alert is raised
+	hysteresis float64 // degrees Celsius below the threshold that a zone must cool to before it can alert again
+}
+
+// defaultThermal is used when the configuration doesn't describe thermal monitoring
+var defaultThermal = thermalConfiguration{interval: 30 * time.Second, hysteresis: 5}
+
+// thermalReading is the temperature of a thermal zone
+type thermalReading struct {
+	zone    string
+	kind    string
+	celsius float64
+}
+
+// zoneStatus reports the temperatures seen in a thermal zone since the server started
+type zoneStatus struct {
+	Zone     string    `json:"zone"`
+	Type     string    `json:"type,omitempty"`
+	Current  float64   `json:"current_celsius"`
+	Min      float64   `json:"min_celsius"`
+	Max      float64   `json:"max_celsius"`
+	Time     time.Time `json:"time"`
+	Alerting bool      `json:"alerting"`
+}
+
+// thermalMonitor samples the thermal zones in the background
+type thermalMonitor struct {
+	mu    sync.Mutex
+	zones map[string]*zoneStatus
+}
+
+// newThermalMonitor starts a routine that samples the thermal zones with read at the configured interval
+// over-temperature alerts and recoveries are published on bus as notifications
+func newThermalMonitor(ctx context.Context, store *configStore, bus *eventBus, read func() ([]thermalReading, error)) *thermalMonitor {
+	m := &thermalMonitor{zones: make(map[string]*zoneStatus)}
+	go func() {
+		for {
+			config := store.get().thermal
+			readings, err := read()
+			if err != nil {
+				thermalLog.warnf("thermal zone read error %v", err)
+			}
+			for _, e := range m.record(time.Now(), readings, config) {
+				thermalLog.with(fields{"zone": e.Name}).warnf("%s", e.Message)
+				bus.publish(e)
+			}
+
+			if config.interval <= 0 {
+				config.interval = defaultThermal.interval
+			}
+			timer := time.NewTimer(config.interval)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+	}()
+	return m
+}
+
+// record updates the zones with readings taken at now and returns the notifications to raise
+func (m *thermalMonitor) record(now time.Time, readings []thermalReading, config thermalConfiguration) (notifications []event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range readings {
+		z, ok := m.zones[r.zone]
+		if !ok {
+			z = &zoneStatus{Zone: r.zone, Type: r.kind, Min: r.celsius, Max: r.celsius}
+			m.zones[r.zone] = z
+		}
+		z.Current, z.Time = r.celsius, now
+		if r.celsius < z.Min {
+			z.Min = r.celsius
+		}
+		if r.celsius > z.Max {
+			z.Max = r.celsius
+		}
+
+		switch {
+		case config.threshold == 0:
+			z.Alerting = false
+		case !z.Alerting && r.celsius > config.threshold:
+			z.Alerting = true
+			notifications = append(notifications, event{Type: eventNotification, Time: now, Name: r.zone, On: true,
+				Message: fmt.Sprintf("%s is %.1f°C, above the threshold of %.1f°C", r.zone, r.celsius, config.threshold)})
+		case z.Alerting && r.celsius < config.threshold-config.hysteresis:
+			z.Alerting = false
+			notifications = append(notifications, event{Type: eventNotification, Time: now, Name: r.zone, On: false,
+				Message: fmt.Sprintf("%s has cooled to %.1f°C", r.zone, r.celsius)})
+		}
+	}
+	return notifications
+}
+
+// status returns the state of each zone in name order; a nil monitor has no zones
+func (m *thermalMonitor) status() []zoneStatus {
+	zones := []zoneStatus{}
+	if m == nil {
+		return zones
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, z := range m.zones {
+		zones = append(zones, *z)
+	}
+	sort.Slice(zones, func(i, j int) bool { return zones[i].Zone < zones[j].Zone })
+	return zones
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThermalRecord(t *testing.T) {
+	m := &thermalMonitor{zones: make(map[string]*zoneStatus)}
+	config := thermalConfiguration{interval: time.Second, threshold: 70, hysteresis: 5}
+	now := time.Now()
+
+	testCases := []struct {
+		celsius  float64
+		alerting bool
+		notified bool
+	}{
+		{60, false, false},
+		{71, true, true},
+		{72, true, false}, // already alerting
+		{67, true, false}, // within the hysteresis
+		{64, false, true}, // cooled
+		{71, true, true},
+	}
+	for i, tc := range testCases {
+		notifications := m.record(now, []thermalReading{{zone: "thermal_zone0", kind: "cpu-thermal", celsius: tc.celsius}}, config)
+		z := m.status()[0]
+		if z.Alerting != tc.alerting || (len(notifications) == 1) != tc.notified {
+			t.Errorf("case %d: got alerting %v and notifications %v; expected %v and %v", i, z.Alerting, notifications, tc.alerting, tc.notified)
+		}
+	}
+
+	z := m.status()[0]
+	if z.Zone != "thermal_zone0" || z.Type != "cpu-thermal" || z.Current != 71 || z.Min != 60 || z.Max != 72 {
+		t.Errorf("got %+v; expected current 71, min 60 and max 72", z)
+	}
+
+	// no threshold, no alerts
+	if notifications := m.record(now, []thermalReading{{zone: "thermal_zone0", celsius: 90}}, thermalConfiguration{}); len(notifications) != 0 {
+		t.Errorf("got %v; expected no notifications without a threshold", notifications)
+	}
+}
+
+func TestThermalMonitor(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bus := newEventBus(ctx)
+	events := bus.subscribe()
+	store := &configStore{config: configuration{thermal: thermalConfiguration{interval: 10 * time.Millisecond, threshold: 50}}}
+	read := func() ([]thermalReading, error) {
+		return []thermalReading{{zone: "thermal_zone0", celsius: 55.5}}, nil
+	}
+	m := newThermalMonitor(ctx, store, bus, read)
+
+	select {
+	case e := <-events:
+		if e.Type != eventNotification || e.Name != "thermal_zone0" || !strings.Contains(e.Message, "55.5°C") {
+			t.Errorf("got event %+v; expected an over-temperature notification", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification")
+	}
+	if zones := m.status(); len(zones) != 1 || zones[0].Current != 55.5 {
+		t.Errorf("got zones %+v; expected the reading", zones)
+	}
+}
+
+func TestThermalMonitorNil(t *testing.T) {
+	var m *thermalMonitor
+	if zones := m.status(); zones == nil || len(zones) != 0 {
+		t.Errorf("got %v; expected an empty list", zones)
+	}
+}
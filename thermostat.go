@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// thermostat limits
+const (
+	minTargetCelsius = 5
+	maxTargetCelsius = 30
+)
+
+// thermostat defaults
+const (
+	defaultThermostatInterval   = 30 * time.Second
+	defaultThermostatHysteresis = 0.5
+	defaultMinCycle             = 5 * time.Minute
+	defaultMaxRun               = 3 * time.Hour
+)
+
+// thermostatLog is the logger for the thermostat subsystem
+var thermostatLog = newLogger("thermostat")
+
+// setpoint changes the target temperature at a time of day
+type setpoint struct {
+	at     string // a clock time or a period boundary such as sunset
+	target float64
+}
+
+// thermostatConfiguration describes a plug that heats until a 1-Wire probe reaches a target temperature
+type thermostatConfiguration struct {
+	plug       string
+	probe      string // the 1-Wire id of the DS18B20; empty for the first probe on the bus
+	target     float64
+	hysteresis float64 // degrees Celsius below the target that the temperature falls before heating starts
+	minOn      time.Duration
+	minOff     time.Duration
+	maxRun     time.Duration // longest time the plug is left on; zero for no limit
+	interval   time.Duration
+	setpoints  []setpoint
+}
+
+// targetAt returns the target of the setpoint that started most recently or the configured target if there is none
+func (c thermostatConfiguration) targetAt(now time.Time, config configuration) float64 {
+	target := c.target
+	var latest time.Time
+	for _, s := range c.setpoints {
+		if at, ok := lastBoundary(s.at, now, config); ok && at.After(latest) {
+			target, latest = s.target, at
+		}
+	}
+	return target
+}
+
+// thermostatStatus reports the state of the thermostat
+type thermostatStatus struct {
+	Plug    string     `json:"plug"`
+	Celsius *float64   `json:"celsius,omitempty"`
+	Target  float64    `json:"target_celsius"`
+	Heating bool       `json:"heating"`
+	Since   *time.Time `json:"since,omitempty"`
+	Cutoff  bool       `json:"cutoff"`
+	Error   string     `json:"error,omitempty"`
+	Time    time.Time  `json:"time"`
+}
+
+// thermostat switches its plug to hold the temperature at the target
+type thermostat struct {
+	mu      sync.Mutex
+	heating bool
+	changed time.Time // when heating last changed
+	cutoff  bool      // heating stopped at the maximum run time and won't restart until the plug is switched on elsewhere
+	latest  *thermostatStatus
+}
+
+// newThermostat starts a routine that reads the probe with read at the configured interval and switches the plug
+// nothing is started if no thermostat is configured
+func newThermostat(ctx context.Context, store *configStore, plugs []namedPlug, bus *eventBus, read func(probe string) (float64, error)) *thermostat {
+	t := &thermostat{}
+	if store.get().thermostat == nil {
+		return t
+	}
+	ctx = withOrigin(ctx, origin{source: sourceThermostat})
+	alive, stop := startHeartbeat("thermostat", time.Now())
+	go func() {
+		defer stop()
+		for {
+			config := store.get()
+			interval := defaultThermostatInterval
+			if c := config.thermostat; c != nil {
+				interval = c.interval
+				if p, ok := findPlug(plugs, c.plug); ok {
+					celsius, err := read(c.probe)
+					t.control(ctx, time.Now(), celsius, err, config, p, bus)
+				}
+			}
+
+			alive.beat(time.Now(), interval)
+			timer := time.NewTimer(interval)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+	}()
+	return t
+}
+
+// control switches p for a reading of celsius, or a read error, taken at now
+// a plug switched by something else is followed, and the minimum times are counted from that change
+// a failed reading or the maximum run time switches the plug off regardless of the minimum on time
+func (t *thermostat) control(ctx context.Context, now time.Time, celsius float64, readErr error, config configuration, p plugInterface, bus *eventBus) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c := config.thermostat
+	l := thermostatLog.with(fields{"plug": c.plug})
+
+	on := p.state()
+	if on != t.heating {
+		t.heating, t.changed = on, now
+		if on {
+			t.cutoff = false
+		}
+	}
+	target := c.targetAt(now, config)
+	elapsed := now.Sub(t.changed)
+
+	heat, reason := on, ""
+	switch {
+	case readErr != nil:
+		l.errorf("probe read error %v", readErr)
+		heat, reason = false, "probe failed"
+	case on && c.maxRun > 0 && elapsed >= c.maxRun:
+		heat, reason = false, "maximum run time reached"
+		t.cutoff = true
+		bus.publish(event{Type: eventNotification, Time: now, Name: c.plug, Source: sourceThermostat,
+			Message: fmt.Sprintf("%s switched off after %v at %.1f°C, below the target of %.1f°C", c.plug, elapsed.Round(time.Second), celsius, target)})
+	case on && celsius >= target && elapsed >= c.minOn:
+		heat, reason = false, "target reached"
+	case !on && !t.cutoff && celsius < target-c.hysteresis && elapsed >= c.minOff:
+		heat, reason = true, "below target"
+	}
+	if heat != on {
+		l.with(fields{"celsius": celsius, "target": target}).infof("heating %v; %s", heat, reason)
+		p.set(ctx, heat)
+		t.heating, t.changed = heat, now
+	}
+
+	s := &thermostatStatus{Plug: c.plug, Target: target, Heating: t.heating, Cutoff: t.cutoff, Time: now}
+	if readErr != nil {
+		s.Error = readErr.Error()
+	} else {
+		s.Celsius = &celsius
+	}
+	if !t.changed.IsZero() {
+		since := t.changed
+		s.Since = &since
+	}
+	t.latest = s
+}
+
+// status returns the state after the latest reading; a nil thermostat or one without a reading returns nil
+func (t *thermostat) status() *thermostatStatus {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.latest == nil {
+		return nil
+	}
+	latest := *t.latest
+	return &latest
+}
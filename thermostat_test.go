@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// thermostatReading is a probe reading taken a number of minutes after the first
+type thermostatReading struct {
+	minute  int
+	celsius float64
+	err     error
+}
+
+func TestThermostatControl(t *testing.T) {
+	testCases := []struct {
+		note     string
+		readings []thermostatReading
+		manual   map[int]bool // plug state set by hand before the reading with the index
+		expected []bool
+		cutoff   bool
+	}{
+		{
+			note:     "heats below the hysteresis",
+			readings: []thermostatReading{{0, 19.8, nil}, {1, 19.4, nil}, {2, 19.6, nil}},
+			expected: []bool{false, true, true},
+		},
+		{
+			note:     "stops at the target after the minimum on time",
+			readings: []thermostatReading{{0, 19, nil}, {2, 20.2, nil}, {5, 20.2, nil}},
+			expected: []bool{true, true, false},
+		},
+		{
+			note:     "waits the minimum off time",
+			readings: []thermostatReading{{0, 19, nil}, {5, 20, nil}, {7, 18, nil}, {10, 18, nil}},
+			expected: []bool{true, false, false, true},
+		},
+		{
+			note:     "switches off when the probe fails",
+			readings: []thermostatReading{{0, 19, nil}, {1, 0, errors.New("no probe")}},
+			expected: []bool{true, false},
+		},
+		{
+			note:     "cuts off at the maximum run time",
+			readings: []thermostatReading{{0, 15, nil}, {59, 16, nil}, {60, 16, nil}, {70, 16, nil}},
+			expected: []bool{true, true, false, false},
+			cutoff:   true,
+		},
+		{
+			note:     "cutoff cleared by switching on by hand",
+			readings: []thermostatReading{{0, 15, nil}, {60, 16, nil}, {70, 16, nil}, {75, 20.5, nil}},
+			manual:   map[int]bool{2: true},
+			expected: []bool{true, false, true, false},
+		},
+	}
+	start := time.Date(2020, time.December, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range testCases {
+		t.Run(tc.note, func(t *testing.T) {
+			heater := &fakePlug{}
+			config := configuration{location: [2]float64{51.5, -0.1}, lightsOut: "23:00", thermostat: &thermostatConfiguration{
+				plug: "heater", target: 20, hysteresis: 0.5, minOn: 5 * time.Minute, minOff: 5 * time.Minute, maxRun: time.Hour}}
+			th := &thermostat{}
+
+			for i, r := range tc.readings {
+				if on, ok := tc.manual[i]; ok {
+					heater.on = on
+				}
+				now := start.Add(time.Duration(r.minute) * time.Minute)
+				th.control(context.Background(), now, r.celsius, r.err, config, heater, nil)
+				if heater.on != tc.expected[i] {
+					t.Errorf("reading %d at minute %d; heating %v, expected %v", i, r.minute, heater.on, tc.expected[i])
+				}
+			}
+			s := th.status()
+			if s == nil || s.Heating != heater.on || s.Cutoff != tc.cutoff || s.Target != 20 {
+				t.Errorf("status %+v; expected heating %v cutoff %v", s, heater.on, tc.cutoff)
+			}
+		})
+	}
+}
+
+func TestThermostatTarget(t *testing.T) {
+	config := configuration{location: [2]float64{51.5, -0.1}, lightsOut: "23:00"}
+	c := thermostatConfiguration{target: 18, setpoints: []setpoint{{"07:00", 20}, {boundaryLightsOut, 16}}}
+	testCases := []struct {
+		hour     int
+		expected float64
+	}{
+		{6, 16},
+		{7, 20},
+		{22, 20},
+		{23, 16},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("hour %d", tc.hour), func(t *testing.T) {
+			now := time.Date(2020, time.December, 1, tc.hour, 30, 0, 0, time.UTC)
+			if target := c.targetAt(now, config); target != tc.expected {
+				t.Errorf("target %v; expected %v", target, tc.expected)
+			}
+		})
+	}
+	if target := (thermostatConfiguration{target: 18}).targetAt(time.Now(), config); target != 18 {
+		t.Errorf("target %v without setpoints; expected 18", target)
+	}
+}
+
+func TestThermostatNil(t *testing.T) {
+	var th *thermostat
+	if s := th.status(); s != nil {
+		t.Errorf("got %v; expected no status", s)
+	}
+}
+
+func TestGetConfigThermostat(t *testing.T) {
+	plugs := `"plugs":[{"name":"light", "socket":1}, {"name":"heater", "socket":2}], "light_sensor":{"rules":[{"plug":"light", "below_lux":50}]}`
+	buf := bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", %s, "thermostat":{
+		"plug":"heater", "target_celsius":19, "max_run_minutes":90, "setpoints":[{"at":"07:00", "target_celsius":21}]}}`,
+		magNLat, magNLon, bedtime, plugs))
+	config, err := getConfiguration(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &thermostatConfiguration{plug: "heater", target: 19, hysteresis: defaultThermostatHysteresis, minOn: defaultMinCycle,
+		minOff: defaultMinCycle, maxRun: 90 * time.Minute, interval: defaultThermostatInterval, setpoints: []setpoint{{"07:00", 21}}}
+	if !reflect.DeepEqual(config.thermostat, expected) {
+		t.Errorf("got %+v; expected %+v", config.thermostat, expected)
+	}
+
+	for _, thermostat := range []string{
+		`{"plug":"heater"}`,
+		`{"plug":"boiler", "target_celsius":19}`,
+		`{"plug":"light", "target_celsius":19}`,
+		`{"plug":"heater", "target_celsius":45}`,
+		`{"plug":"heater", "target_celsius":19, "hysteresis_celsius":-1}`,
+		`{"plug":"heater", "target_celsius":19, "interval_seconds":0}`,
+		`{"plug":"heater", "target_celsius":19, "min_on_seconds":600, "max_run_minutes":5}`,
+		`{"plug":"heater", "target_celsius":19, "setpoints":[{"at":"noon", "target_celsius":21}]}`,
+		`{"plug":"heater", "target_celsius":19, "setpoints":[{"at":"07:00"}]}`,
+	} {
+		buf = bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", %s, "thermostat":%s}`,
+			magNLat, magNLon, bedtime, plugs, thermostat))
+		if _, err = getConfiguration(buf); err == nil {
+			t.Errorf("expected error for thermostat %s", thermostat)
+		}
+	}
+
+	// other rules can't switch the thermostat's plug
+	for _, tc := range []struct {
+		rules    string
+		expected string
+	}{
+		{`"motion_sensors":[{"name":"hall", "pin":"GPIO5", "rules":[{"plug":"heater", "duration_seconds":60}]}]`,
+			"Thermostat plug 'heater' should not also be switched by motion sensor 'hall'"},
+		{`"light_sensor":{"rules":[{"plug":"heater", "below_lux":50}]}`, "Thermostat plug 'heater' should not also be switched by the light sensor"},
+	} {
+		buf = bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", "plugs":[{"name":"light", "socket":1}, {"name":"heater", "socket":2}],
+			%s, "thermostat":{"plug":"heater", "target_celsius":19}}`, magNLat, magNLon, bedtime, tc.rules))
+		if _, err = getConfiguration(buf); err == nil || !strings.HasSuffix(err.Error(), tc.expected) {
+			t.Errorf("got error %v; expected %s", err, tc.expected)
+		}
+	}
+}
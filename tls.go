@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	certFilename = "heihei-cert.pem"
+	keyFilename  = "heihei-key.pem"
+	certValidity = 10 * 365 * 24 * time.Hour
+)
+
+// tlsConfiguration holds the paths used to set up the HTTPS listener
+// empty certFile and keyFile values mean that a self-signed certificate is used
+type tlsConfiguration struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+}
+
+// requireClientCert returns true if clients must present a certificate signed by the client CA
+func (c tlsConfiguration) requireClientCert() bool {
+	return c.clientCAFile != ""
+}
+
+// resolvePath returns path unchanged if it is absolute, otherwise it is joined to dir
+func resolvePath(dir, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// certificateHosts returns the names and addresses that the self-signed certificate is valid for
+func certificateHosts() []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if name, err := os.Hostname(); err == nil {
+		hosts = append(hosts, name, name+".local")
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return hosts
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+			hosts = append(hosts, ipNet.IP.String())
+		}
+	}
+	return hosts
+}
+
+// generateCertificate creates a PEM encoded self-signed certificate and private key valid for the given hosts
+func generateCertificate(hosts []string, now time.Time) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Heihei"}, CommonName: "heihei"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(certValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// loadOrCreateCertificate loads the certificate and key at the given paths
+// if neither file exists, a self-signed certificate is generated and saved for use on subsequent starts
+func loadOrCreateCertificate(certPath, keyPath string) (tls.Certificate, error) {
+	_, certErr := os.Stat(certPath)
+	_, keyErr := os.Stat(keyPath)
+	if os.IsNotExist(certErr) && os.IsNotExist(keyErr) {
+		httpLog.infof("generating self-signed certificate %s", certPath)
+		certPEM, keyPEM, err := generateCertificate(certificateHosts(), time.Now())
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		if err = ioutil.WriteFile(keyPath, keyPEM, 0600); err != nil {
+			return tls.Certificate{}, err
+		}
+		if err = ioutil.WriteFile(certPath, certPEM, 0644); err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+	return tls.LoadX509KeyPair(certPath, keyPath)
+}
+
+// newTLSConfig creates the TLS configuration for the HTTPS listener
+// relative paths in c are resolved against dir, the directory of the configuration; a generated certificate is stored in stateDir
+func newTLSConfig(c tlsConfiguration, dir, stateDir string) (*tls.Config, error) {
+	var cert tls.Certificate
+	var err error
+	if c.certFile == "" {
+		cert, err = loadOrCreateCertificate(filepath.Join(stateDir, certFilename), filepath.Join(stateDir, keyFilename))
+	} else {
+		cert, err = tls.LoadX509KeyPair(resolvePath(dir, c.certFile), resolvePath(dir, c.keyFile))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("TLS certificate error; %s", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if c.requireClientCert() {
+		caPEM, err := ioutil.ReadFile(resolvePath(dir, c.clientCAFile))
+		if err != nil {
+			return nil, fmt.Errorf("TLS client CA error; %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("TLS client CA error; no certificates found in %s", c.clientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
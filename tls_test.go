@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGenerateCertificate(t *testing.T) {
+	now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	certPEM, keyPEM, err := generateCertificate([]string{"heihei.local", "192.168.1.2"}, now)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err = tls.X509KeyPair(certPEM, keyPEM); err != nil {
+		t.Fatalf("certificate and key do not match; %v", err)
+	}
+
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err = cert.VerifyHostname("heihei.local"); err != nil {
+		t.Errorf("hostname error %v", err)
+	}
+	if err = cert.VerifyHostname("192.168.1.2"); err != nil {
+		t.Errorf("ip address error %v", err)
+	}
+	if !cert.NotAfter.After(now.AddDate(1, 0, 0)) {
+		t.Errorf("certificate expires too soon; %v", cert.NotAfter)
+	}
+}
+
+func TestLoadOrCreateCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heihei")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certPath, keyPath := filepath.Join(dir, certFilename), filepath.Join(dir, keyFilename)
+
+	first, err := loadOrCreateCertificate(certPath, keyPath)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if info, err := os.Stat(keyPath); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("key file not saved privately; %v %v", info, err)
+	}
+
+	second, err := loadOrCreateCertificate(certPath, keyPath)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if string(first.Certificate[0]) != string(second.Certificate[0]) {
+		t.Errorf("certificate regenerated; expected the saved certificate to be reused")
+	}
+}
+
+func TestNewTLSConfigClientCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heihei")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	caPEM, _, err := generateCertificate([]string{"ca"}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "ca.pem"), caPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the client CA is found beside the configuration and the generated certificate is stored apart from it
+	stateDir := filepath.Join(dir, "state")
+	if err = os.Mkdir(stateDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	config, err := newTLSConfig(tlsConfiguration{clientCAFile: "ca.pem"}, dir, stateDir)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(stateDir, certFilename)); err != nil {
+		t.Errorf("expected the generated certificate in the state directory; %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, certFilename)); err == nil {
+		t.Errorf("expected no certificate beside the configuration")
+	}
+	if config.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Errorf("client auth %v; expected client certificates to be required", config.ClientAuth)
+	}
+
+	if _, err = newTLSConfig(tlsConfiguration{clientCAFile: "missing.pem"}, dir, dir); err == nil {
+		t.Errorf("expected error for missing client CA")
+	}
+}
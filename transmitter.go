@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"time"
+
+	"periph.io/x/periph/conn/gpio"
+)
+
+// defaultTransmitRepeats is the number of times a learned code is sent; remotes repeat each frame while pressed
+const defaultTransmitRepeats = 8
+
+// maxPulse is the longest pulse of a learned code
+const maxPulse = 100 * time.Millisecond
+
+// transmitterLog is the logger for the 433MHz transmitter subsystem
+var transmitterLog = newLogger("transmitter")
+
+// transmitterConfiguration describes a 433MHz OOK transmitter module driven by a GPIO pin
+type transmitterConfiguration struct {
+	pin     string // the periph name of the pin, such as GPIO13 or P1_33
+	repeats int    // the number of times each frame is sent
+}
+
+// deviceConfiguration describes a device controlled by replaying the codes learned from its remote
+// a code is empty until it has been learned
+type deviceConfiguration struct {
+	name    string
+	on, off ookFrame
+}
+
+// transmitterOutput returns the setting of the transmitter that is only read at start up
+func transmitterOutput(c *transmitterConfiguration) interface{} {
+	if c == nil {
+		return nil
+	}
+	return c.pin
+}
+
+// deviceNames returns the names of the devices, which are only read at start up; their codes are read for each transmission
+func deviceNames(devices []deviceConfiguration) (names []string) {
+	for _, d := range devices {
+		names = append(names, d.name)
+	}
+	return names
+}
+
+// findDevice returns the device with the given name
+func findDevice(devices []deviceConfiguration, name string) (deviceConfiguration, bool) {
+	for _, d := range devices {
+		if d.name == name {
+			return d, true
+		}
+	}
+	return deviceConfiguration{}, false
+}
+
+// replay sends frame repeats times by setting pin high and low for the width of each pulse, leaving it low
+// the gap that ends the frame is also sent first so that receivers synchronise to the first frame;
+// the pulses are a few hundred microseconds so the thread is locked and the time is polled rather than slept
+func replay(pin gpio.PinOut, frame ookFrame, repeats int) error {
+	// share the radio band with the Energenie transmitter
+	mutex.Lock()
+	defer mutex.Unlock()
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	start := time.Now()
+	at := frame[len(frame)-1]
+	if err := pin.Out(gpio.Low); err != nil {
+		return err
+	}
+	for time.Since(start) < at {
+	}
+	for r := 0; r < repeats; r++ {
+		for i, width := range frame {
+			if err := pin.Out(gpio.Level(i%2 == 0)); err != nil {
+				pin.Out(gpio.Low)
+				return err
+			}
+			at += width
+			for time.Since(start) < at {
+			}
+		}
+	}
+	return pin.Out(gpio.Low)
+}
+
+// newLearnedPlug creates a plug for the named device that replays its learned codes on pin
+// the codes and repeats are read from store for each transmission so learning a code takes effect immediately
+func newLearnedPlug(ctx context.Context, name string, store *configStore, pin gpio.PinOut, bus *eventBus) *plug {
+	p := &plug{
+		setChan: make(chan plugRequest),
+		getChan: make(chan bool),
+		id:      plugLearned,
+		name:    name,
+		bus:     bus,
+		log:     newLogger("plug").with(fields{"plug": name}),
+	}
+	p.transmit = func(on bool) (err error) {
+		start := time.Now()
+		defer func() {
+			observeTransmit(name, time.Since(start), err)
+			recordTransmit(name, err)
+		}()
+		config := store.get()
+		d, _ := findDevice(config.devices, name)
+		code, state := d.on, "on"
+		if !on {
+			code, state = d.off, "off"
+		}
+		if len(code) == 0 || config.transmitter == nil {
+			return fmt.Errorf("no %s code learned for %s", state, name)
+		}
+		return replay(pin, code, config.transmitter.repeats)
+	}
+
+	// start with the device off if it can be switched off
+	if d, _ := findDevice(store.get().devices, name); len(d.off) > 0 {
+		if err := p.transmit(false); err != nil {
+			p.log.errorf("transmit error %v", err)
+		}
+	}
+	p.run(ctx)
+	return p
+}
+
+// newLearnedPlugs creates a plug for each configured device, transmitting on the configured transmitter
+// no plugs are created if the transmitter's pin can't be used, which is logged
+func newLearnedPlugs(ctx context.Context, store *configStore, bus *eventBus) (plugs []namedPlug) {
+	config := store.get()
+	if config.transmitter == nil {
+		return nil
+	}
+	pin, err := openOutputPin(config.transmitter.pin)
+	if err == nil {
+		err = pin.Out(gpio.Low)
+	}
+	if err != nil {
+		transmitterLog.errorf("transmitter on %s unavailable; %v", config.transmitter.pin, err)
+		return nil
+	}
+	for _, d := range config.devices {
+		plugs = append(plugs, namedPlug{name: d.name, plugInterface: newLearnedPlug(ctx, d.name, store, pin, bus)})
+	}
+	return plugs
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"periph.io/x/periph/conn/gpio"
+)
+
+// timedPin is a simulated pin that records the levels it is driven to and when
+type timedPin struct {
+	*simulatedPin
+	mu     sync.Mutex
+	levels []gpio.Level
+	times  []time.Time
+}
+
+func (p *timedPin) Out(l gpio.Level) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.levels = append(p.levels, l)
+	p.times = append(p.times, time.Now())
+	return nil
+}
+
+func (p *timedPin) recorded() []gpio.Level {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]gpio.Level(nil), p.levels...)
+}
+
+func TestReplay(t *testing.T) {
+	pin := &timedPin{simulatedPin: newSimulatedPin("GPIO12")}
+	f := ookFrame{time.Millisecond, 3 * time.Millisecond, 3 * time.Millisecond, time.Millisecond, time.Millisecond, 10 * time.Millisecond}
+	if err := replay(pin, f, 2); err != nil {
+		t.Fatal(err)
+	}
+	expected := []gpio.Level{gpio.Low,
+		gpio.High, gpio.Low, gpio.High, gpio.Low, gpio.High, gpio.Low,
+		gpio.High, gpio.Low, gpio.High, gpio.Low, gpio.High, gpio.Low,
+		gpio.Low}
+	if !reflect.DeepEqual(pin.levels, expected) {
+		t.Fatalf("got levels %v; expected %v", pin.levels, expected)
+	}
+	// each edge follows the first by at least the width of the pulses before it; the first is recorded just after the start
+	// and later edges may be delayed by the scheduler on a busy machine
+	at := f[len(f)-1]
+	for i, edge := range pin.times[1:] {
+		if late := edge.Sub(pin.times[0]) - at; late < -50*time.Microsecond || late > 50*time.Millisecond {
+			t.Errorf("edge %d is %v after its time", i+1, late)
+		}
+		at += f[i%len(f)]
+	}
+}
+
+func TestLearnedPlug(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bus := newEventBus(ctx)
+	code := ookFrame{time.Millisecond, time.Millisecond, time.Millisecond, 5 * time.Millisecond}
+	store := &configStore{config: configuration{transmitter: &transmitterConfiguration{pin: "GPIO12", repeats: 3},
+		devices: []deviceConfiguration{{name: "doorbell", on: code}}}}
+	pin := &timedPin{simulatedPin: newSimulatedPin("GPIO12")}
+	p := newLearnedPlug(ctx, "doorbell", store, pin, bus)
+	if levels := pin.recorded(); len(levels) != 0 {
+		t.Errorf("got levels %v; expected nothing sent at start without an off code", levels)
+	}
+
+	// the state is read once the transmission is over
+	p.set(ctx, true)
+	if on, levels := p.state(), pin.recorded(); len(levels) != 2+3*len(code) || !on {
+		t.Errorf("got %d levels; expected the on code sent 3 times", len(levels))
+	}
+	// the state changes although there's no off code to send
+	p.set(ctx, false)
+	if on, levels := p.state(), pin.recorded(); len(levels) != 2+3*len(code) || on {
+		t.Errorf("got %d levels; expected nothing more sent", len(levels))
+	}
+
+	// a learned code is used without a restart
+	store.set(configuration{transmitter: &transmitterConfiguration{pin: "GPIO12", repeats: 1},
+		devices: []deviceConfiguration{{name: "doorbell", on: code, off: code}}})
+	p.set(ctx, false)
+	if _, levels := p.state(), pin.recorded(); len(levels) != 2+3*len(code)+2+len(code) {
+		t.Errorf("got %d levels; expected the off code sent once", len(levels))
+	}
+}
+
+func TestGetConfigDevices(t *testing.T) {
+	buf := bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", "transmitter":{"pin":"GPIO5"},
+		"devices":[{"name":"doorbell", "on":[300, 900, 900, 300, 300, 900, 900, 300, 300, 900, 900, 300, 300, 900, 900, 9000]}, {"name":"fan"}]}`,
+		magNLat, magNLon, bedtime))
+	config, err := getConfiguration(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (&transmitterConfiguration{pin: "GPIO5", repeats: defaultTransmitRepeats}); !reflect.DeepEqual(config.transmitter, expected) {
+		t.Errorf("got transmitter %+v; expected %+v", config.transmitter, expected)
+	}
+	if len(config.devices) != 2 || len(config.devices[0].on) != 16 || config.devices[0].on[15] != 9*time.Millisecond || config.devices[1].on != nil {
+		t.Errorf("got devices %+v; expected the doorbell code and the fan without codes", config.devices)
+	}
+
+	pulses := "[300, 900, 900, 300, 300, 900, 900, 300, 300, 900, 900, 300, 300, 900, 900, 9000]"
+	for _, c := range []string{
+		`"devices":[{"name":"doorbell"}]`,
+		`"transmitter":{}`,
+		`"transmitter":{"pin":"GPIO16"}`,
+		`"transmitter":{"pin":"GPIO5", "repeats":0}`,
+		`"transmitter":{"pin":"GPIO5"}, "devices":[{"name":"light"}]`,
+		`"transmitter":{"pin":"GPIO5"}, "devices":[{"name":"bell"}, {"name":"bell"}]`,
+		`"transmitter":{"pin":"GPIO5"}, "devices":[{"name":"Bell"}]`,
+		`"transmitter":{"pin":"GPIO5"}, "devices":[{"name":"bell", "on":[300, 900, 9000]}]`,
+		`"transmitter":{"pin":"GPIO5"}, "devices":[{"name":"bell", "off":[300, 900, 900, 300, 300, 900, 900, 300, 300, 900, 900, 300, 300, 900, 900]}]`,
+		`"transmitter":{"pin":"GPIO5"}, "devices":[{"name":"bell", "off":` + pulses[:len(pulses)-5] + `200000]}]`,
+	} {
+		buf = bytes.NewBufferString(fmt.Sprintf(`{"location":[%f, %f], "lights_out":"%s", "buttons":[{"pin":"GPIO16", "plug":"light"}], %s}`,
+			magNLat, magNLon, bedtime, c))
+		if _, err = getConfiguration(buf); err == nil {
+			t.Errorf("expected error for %s", c)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ds18b20Family is the 1-Wire family code that prefixes the id of each DS18B20
+const ds18b20Family = "28-"
+
+// w1DevicesPath is the sysfs directory of the devices on the 1-Wire bus
+var w1DevicesPath = "/sys/bus/w1/devices"
+
+// ds18b20Probes returns the ids of the DS18B20 probes on the 1-Wire bus in order
+func ds18b20Probes() ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(w1DevicesPath, ds18b20Family+"*"))
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(matches))
+	for i, m := range matches {
+		ids[i] = filepath.Base(m)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// readDS18B20 returns the temperature in degrees Celsius measured by the probe with the given id
+// an empty id reads the first probe on the bus
+func readDS18B20(id string) (float64, error) {
+	if id == "" {
+		ids, err := ds18b20Probes()
+		if err != nil {
+			return 0, err
+		}
+		if len(ids) == 0 {
+			return 0, fmt.Errorf("no DS18B20 found in %s", w1DevicesPath)
+		}
+		id = ids[0]
+	}
+	data, err := ioutil.ReadFile(filepath.Join(w1DevicesPath, id, "w1_slave"))
+	if err != nil {
+		return 0, err
+	}
+	return parseW1Slave(string(data))
+}
+
+// parseW1Slave extracts the temperature from the w1_slave file of a DS18B20, such as
+//
+//	72 01 4b 46 7f ff 0e 10 57 : crc=57 YES
+//	72 01 4b 46 7f ff 0e 10 57 t=23125
+//
+// the first line reports whether the CRC of the data matched and the second gives the temperature in millidegrees
+func parseW1Slave(data string) (float64, error) {
+	lines := strings.Split(strings.TrimSpace(data), "\n")
+	if len(lines) != 2 {
+		return 0, errors.New("DS18B20 output has an unexpected format")
+	}
+	if !strings.HasSuffix(strings.TrimSpace(lines[0]), "YES") {
+		return 0, errors.New("DS18B20 CRC check failed")
+	}
+	i := strings.LastIndex(lines[1], "t=")
+	if i < 0 {
+		return 0, errors.New("DS18B20 output has no temperature")
+	}
+	milli, err := strconv.Atoi(strings.TrimSpace(lines[1][i+2:]))
+	if err != nil {
+		return 0, fmt.Errorf("DS18B20 temperature is invalid; %v", err)
+	}
+	// 85°C is the power on reset value, read when a conversion didn't complete
+	if milli == 85000 {
+		return 0, errors.New("DS18B20 conversion incomplete")
+	}
+	return float64(milli) / 1000, nil
+}
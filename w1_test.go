@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseW1Slave(t *testing.T) {
+	testCases := []struct {
+		note     string
+		data     string
+		expected float64
+		err      bool
+	}{
+		{note: "valid", data: "72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 4b 46 7f ff 0e 10 57 t=23125\n", expected: 23.125},
+		{note: "below zero", data: "5e ff 4b 46 7f ff 02 10 b4 : crc=b4 YES\n5e ff 4b 46 7f ff 02 10 b4 t=-10125\n", expected: -10.125},
+		{note: "crc failure", data: "72 01 4b 46 7f ff 0e 10 57 : crc=12 NO\n72 01 4b 46 7f ff 0e 10 57 t=23125\n", err: true},
+		{note: "power on reset", data: "50 05 4b 46 7f ff 0c 10 1c : crc=1c YES\n50 05 4b 46 7f ff 0c 10 1c t=85000\n", err: true},
+		{note: "no temperature", data: "72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 4b 46 7f ff 0e 10 57\n", err: true},
+		{note: "truncated", data: "72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n", err: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.note, func(t *testing.T) {
+			celsius, err := parseW1Slave(tc.data)
+			if tc.err {
+				if err == nil {
+					t.Errorf("expected an error; got %v", celsius)
+				}
+			} else if err != nil || celsius != tc.expected {
+				t.Errorf("got %v %v; expected %v", celsius, err, tc.expected)
+			}
+		})
+	}
+}
+
+func TestReadDS18B20(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heihei")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(path string) { w1DevicesPath = path }(w1DevicesPath)
+	w1DevicesPath = dir
+
+	if _, err = readDS18B20(""); err == nil {
+		t.Errorf("expected an error without probes")
+	}
+
+	probes := map[string]string{
+		"28-0316a2795dff": "t=19500",
+		"28-01143bbdc2aa": "t=21000",
+	}
+	for id, reading := range probes {
+		if err = os.Mkdir(filepath.Join(dir, id), 0755); err != nil {
+			t.Fatal(err)
+		}
+		data := "72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 4b 46 7f ff 0e 10 57 " + reading + "\n"
+		if err = ioutil.WriteFile(filepath.Join(dir, id, "w1_slave"), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the bus master is listed alongside the devices
+	if err = os.Mkdir(filepath.Join(dir, "w1_bus_master1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if celsius, err := readDS18B20(""); err != nil || celsius != 21 {
+		t.Errorf("first probe %v %v; expected 21", celsius, err)
+	}
+	if celsius, err := readDS18B20("28-0316a2795dff"); err != nil || celsius != 19.5 {
+		t.Errorf("named probe %v %v; expected 19.5", celsius, err)
+	}
+	if _, err := readDS18B20("28-000000000000"); err == nil {
+		t.Errorf("expected an error for a missing probe")
+	}
+}
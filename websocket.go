@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// websocketGUID is defined by RFC 6455 for computing the handshake accept key
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// websocket opcodes
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xA
+)
+
+// wsMaxControlPayload is the largest payload a control frame may carry
+const wsMaxControlPayload = 125
+
+// websocketAccept returns the Sec-WebSocket-Accept value for the client's Sec-WebSocket-Key
+func websocketAccept(key string) string {
+	h := sha1.New()
+	io.WriteString(h, key+websocketGUID)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// headerContains returns true if the comma separated header values contain token, ignoring case
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeFrame writes a single unmasked, unfragmented frame as sent by a server
+func writeFrame(w io.Writer, opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n <= wsMaxControlPayload:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// readFrame reads a single masked frame as sent by a client
+// only control frames and small data frames are expected so large payloads are rejected
+func readFrame(r io.Reader) (opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		return
+	}
+	opcode = header[0] & 0x0F
+	if header[1]&0x80 == 0 {
+		return opcode, nil, errors.New("websocket client frame is not masked")
+	}
+	n := uint64(header[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(r, ext[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		return opcode, nil, errors.New("websocket client frame is too large")
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(r, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
+
+// websocketHandlerFunc returns a handler function that streams events as JSON text messages over a WebSocket
+func websocketHandlerFunc(b *eventBus) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Sec-WebSocket-Key")
+		if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") || key == "" {
+			respond(w, "WebSocket upgrade required", http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Sec-WebSocket-Version") != "13" {
+			w.Header().Set("Sec-WebSocket-Version", "13")
+			respond(w, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+			return
+		}
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			respond(w, "WebSocket unsupported", http.StatusInternalServerError)
+			return
+		}
+		conn, rw, err := hijacker.Hijack()
+		if err != nil {
+			eventLog.errorf("websocket hijack error %v", err)
+			return
+		}
+		defer conn.Close()
+
+		fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n"+
+			"Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", websocketAccept(key))
+		if err = rw.Flush(); err != nil {
+			return
+		}
+
+		// the reader routine answers pings and reports when the client goes away
+		// writes are made only by this routine so pongs are passed back on a channel
+		pongC := make(chan []byte, 1)
+		closedC := make(chan struct{})
+		go func(r *bufio.Reader) {
+			defer close(closedC)
+			for {
+				opcode, payload, err := readFrame(r)
+				if err != nil {
+					return
+				}
+				switch opcode {
+				case wsClose:
+					return
+				case wsPing:
+					select {
+					case pongC <- payload:
+					default:
+					}
+				}
+			}
+		}(rw.Reader)
+
+		c := b.subscribe()
+		defer b.unsubscribe(c)
+		keepAlive := time.NewTicker(sseKeepAlive)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case e, ok := <-c:
+				if !ok {
+					writeFrame(conn, wsClose, nil)
+					return
+				}
+				data, err := json.Marshal(e)
+				if err != nil {
+					eventLog.errorf("event encoding error %v", err)
+					continue
+				}
+				err = writeFrame(conn, wsText, data)
+			case payload := <-pongC:
+				err = writeFrame(conn, wsPong, payload)
+			case <-keepAlive.C:
+				err = writeFrame(conn, wsPing, nil)
+			case <-closedC:
+				writeFrame(conn, wsClose, nil)
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+}
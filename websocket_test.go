@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newServeMuxFunc returns a ServeMux with a single handler function
+func newServeMuxFunc(pattern string, f func(http.ResponseWriter, *http.Request)) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc(pattern, f)
+	return mux
+}
+
+func TestWebsocketAccept(t *testing.T) {
+	// example from RFC 6455 section 1.3
+	if actual := websocketAccept("dGhlIHNhbXBsZSBub25jZQ=="); actual != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("got %v; expected s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", actual)
+	}
+}
+
+func TestWriteFrameLength(t *testing.T) {
+	testCases := []struct {
+		length int
+		header []byte
+	}{
+		{5, []byte{0x81, 5}},
+		{200, []byte{0x81, 126, 0, 200}},
+		{70000, []byte{0x81, 127, 0, 0, 0, 0, 0, 1, 0x11, 0x70}},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("length %d", tc.length), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writeFrame(&buf, wsText, make([]byte, tc.length)); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(buf.Bytes(), tc.header) || buf.Len() != len(tc.header)+tc.length {
+				t.Errorf("got header % x; expected % x", buf.Bytes()[:len(tc.header)], tc.header)
+			}
+		})
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	// masked "Hello" from RFC 6455 section 5.7
+	frame := []byte{0x81, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58}
+	opcode, payload, err := readFrame(bytes.NewReader(frame))
+	if err != nil || opcode != wsText || string(payload) != "Hello" {
+		t.Errorf("got %v %q %v; expected text Hello", opcode, payload, err)
+	}
+
+	// unmasked frames are rejected
+	if _, _, err = readFrame(bytes.NewReader([]byte{0x81, 0x05, 'H', 'e', 'l', 'l', 'o'})); err == nil {
+		t.Errorf("expected error for unmasked frame")
+	}
+}
+
+func TestWebsocketHandlerRejectsPlainRequest(t *testing.T) {
+	w := httptest.NewRecorder()
+	websocketHandlerFunc(nil)(w, httptest.NewRequest("GET", "/ws", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %v; expected %v", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestWebsocketHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bus := newEventBus(ctx)
+	server := httptest.NewServer(newServeMuxFunc("/ws", websocketHandlerFunc(bus)))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(conn, "GET /ws HTTP/1.1\r\nHost: heihei\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected handshake %v %v", resp.Status, resp.Header)
+	}
+
+	go func() {
+		for ctx.Err() == nil {
+			bus.publish(event{Type: eventAlarm, On: true})
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	// server frames are unmasked so read the two byte header directly
+	var header [2]byte
+	if _, err = r.Read(header[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Read(header[1:]); err != nil {
+		t.Fatal(err)
+	}
+	if header[0] != 0x80|wsText || header[1]&0x80 != 0 {
+		t.Fatalf("unexpected frame header % x", header)
+	}
+	payload := make([]byte, header[1])
+	if _, err = r.Read(payload); err != nil {
+		t.Fatal(err)
+	}
+	var e event
+	if err = json.Unmarshal(payload, &e); err != nil || e.Type != eventAlarm || !e.On {
+		t.Errorf("unexpected event %s %v", payload, err)
+	}
+}